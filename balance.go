@@ -0,0 +1,137 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package braid
+
+import (
+	"context"
+	"time"
+)
+
+// balanceRounds is how many spans each job's first span is sized to be one of, were the download
+// divided into equal spans. They're kept small so that every connection's speed is measured early on.
+const balanceRounds = 8
+
+// minSpan is the smallest span a job takes when load balancing, so that the end of the download isn't
+// broken into more requests than it's worth.
+const minSpan = 64 << 10
+
+// SetLoadBalancing sets whether the download is divided between jobs in proportion to the speed each
+// job's connection has shown. Rather than being divided up front, each job takes a span of the download
+// at a time: a small one at first, to measure its throughput, then its share of what remains by speed,
+// so that a fast connection, or a fast mirror, takes on more of the download and jobs finish together.
+// Each span has its own entry in JobStats. It replaces the division set by SetChunkSize, and doesn't apply
+// to streams, downloads that can be resumed, or resources whose length is unknown or without ranges.
+func (r *Request) SetLoadBalancing(enabled bool) {
+	r.loadBalancing = enabled
+}
+
+// balancer divides bytes next to end-1 of the resource between jobs as they ask for them, sizing each
+// span by the throughput the job has shown. It is guarded by the Request's mu, so that the bytes not yet
+// taken are counted in Stats along with the jobs' ranges.
+type balancer struct {
+	next, end int64
+	// rates holds the bytes per second each worker fetched its last spans at
+	rates   []float64
+	workers int
+}
+
+func newBalancer(offset, length int64, workers int) *balancer {
+	return &balancer{next: offset, end: length, rates: make([]float64, workers), workers: workers}
+}
+
+// remaining returns the number of bytes not yet taken by a job.
+func (b *balancer) remaining() int64 {
+	return b.end - b.next
+}
+
+// size returns the number of bytes worker should take next.
+func (b *balancer) size(worker int) int64 {
+	remaining := b.remaining()
+	var size int64
+	if b.rates[worker] == 0 {
+		size = remaining / int64(b.workers*balanceRounds)
+	} else {
+		// a worker yet to be measured is expected to be as fast as the average
+		var sum float64
+		var measured int
+		for _, rate := range b.rates {
+			if rate > 0 {
+				sum += rate
+				measured++
+			}
+		}
+		sum += sum / float64(measured) * float64(b.workers-measured)
+		// half the worker's share is taken at a time, so that a change in speed is caught up with
+		size = int64(float64(remaining) * b.rates[worker] / sum / 2)
+	}
+	if size < minSpan {
+		size = minSpan
+	}
+	if remaining-size < minSpan {
+		size = remaining
+	}
+	return size
+}
+
+// report records that worker fetched n bytes in d.
+func (b *balancer) report(worker int, n int64, d time.Duration) {
+	if n <= 0 || d <= 0 {
+		return
+	}
+	rate := float64(n) / d.Seconds()
+	if last := b.rates[worker]; last > 0 {
+		// recent spans count for more, as a connection's speed may change
+		rate = (rate + last) / 2
+	}
+	b.rates[worker] = rate
+}
+
+// takeSpan adds a job for worker's next span of the download, returning false once there is none left.
+func (r *Request) takeSpan(worker int) (jobID int, min, max int64, ok bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	b := r.balancer
+	if b.remaining() <= 0 {
+		return 0, 0, 0, false
+	}
+	min = b.next
+	max = min + b.size(worker)
+	b.next = max
+	return r.addJobLocked(min, max), min, max, true
+}
+
+// balancedWorker fetches spans of the download taken from the balancer until there are none left, the
+// download is cancelled or a span fails.
+func (r *Request) balancedWorker(ctx context.Context, worker int, errChan chan error) {
+	defer r.wg.Done()
+	for ctx.Err() == nil {
+		jobID, min, max, ok := r.takeSpan(worker)
+		if !ok {
+			return
+		}
+		stat, _ := r.jobStat(jobID)
+		start := time.Now()
+		r.wg.Add(1)
+		r.fetchFile(ctx, min, max, jobID, errChan, nil)
+		read := stat.read.Load()
+		if read != max-min {
+			// the job has reported why it failed, and the worker sends no more than one error
+			return
+		}
+		r.mu.Lock()
+		r.balancer.report(worker, read, time.Since(start))
+		r.mu.Unlock()
+	}
+}
@@ -0,0 +1,100 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package braid
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestBalancer(t *testing.T) {
+	b := newBalancer(0, 64<<20, 2)
+	if size := b.size(0); size != 4<<20 {
+		t.Fatalf("expected an unmeasured worker to take %d bytes, got %d", 4<<20, size)
+	}
+
+	b.next = 8 << 20
+	b.report(0, 3<<20, time.Second)
+	b.report(1, 1<<20, time.Second)
+	// half of each worker's share of the 56MiB remaining, by speed
+	if size := b.size(0); size != 21<<20 {
+		t.Fatalf("expected the fast worker to take %d bytes, got %d", 21<<20, size)
+	}
+	if size := b.size(1); size != 7<<20 {
+		t.Fatalf("expected the slow worker to take %d bytes, got %d", 7<<20, size)
+	}
+
+	// the end of the download isn't left as a sliver
+	b.next = b.end - minSpan - 1
+	if size := b.size(1); size != minSpan+1 {
+		t.Fatalf("expected the rest of the download to be taken, got %d", size)
+	}
+}
+
+func TestLoadBalancing(t *testing.T) {
+	var filename string = "balance.bin"
+	content := randomContent(4 << 20)
+	defer os.Remove(filename)
+
+	// two paths to the resource, one four times faster than the other
+	var fastServed, slowServed int64
+	fast := countingServer(content, 4<<20, false, &fastServed)
+	defer fast.Close()
+	slow := countingServer(content, 1<<20, false, &slowServed)
+	defer slow.Close()
+
+	br, err := NewRequest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	br.SetJobs(2)
+	br.SetLoadBalancing(true)
+	// the first connection, which the HEAD request leaves for a job, takes the fast path and the second
+	// the slow one, each job reusing its connection for every span it fetches
+	var dialed atomic.Int32
+	br.dial = func(d *net.Dialer, ctx context.Context, network, addr string) (net.Conn, error) {
+		if dialed.Add(1) == 1 {
+			return d.DialContext(ctx, network, fast.Listener.Addr().String())
+		}
+		return d.DialContext(ctx, network, slow.Listener.Addr().String())
+	}
+	file, err := br.FetchFile(context.Background(), fast.URL, filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+
+	got, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, content) {
+		t.Fatalf("downloaded content doesn't match")
+	}
+	if fastServed+slowServed != int64(len(content)) {
+		t.Fatalf("expected %d bytes served, got %d and %d", len(content), fastServed, slowServed)
+	}
+	if fastServed < 2*slowServed {
+		t.Fatalf("expected the fast path to serve the most, got %d fast and %d slow", fastServed, slowServed)
+	}
+	if len(br.JobStats()) <= 2 {
+		t.Fatalf("expected the download to be taken in spans, got %d jobs", len(br.JobStats()))
+	}
+}
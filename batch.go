@@ -0,0 +1,77 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package braid
+
+import (
+	"sync"
+	"time"
+)
+
+// Batch groups several Requests so that their progress can be reported as one,
+// e.g. to drive a single progress bar for a multi-file download.
+type Batch struct {
+	mu       sync.Mutex
+	requests []*Request
+}
+
+// BatchStat holds statistics combined across all Requests in a Batch.
+type BatchStat struct {
+	TotalBytes int64
+	ReadBytes  int64
+	// BytesPerSec is the average throughput since the first request in the batch started.
+	BytesPerSec float64
+	Requests    int
+}
+
+// NewBatch returns a new, empty batch.
+func NewBatch() *Batch {
+	return &Batch{}
+}
+
+// Add adds r to the batch. Requests may be added before or during their download.
+func (b *Batch) Add(r *Request) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.requests = append(b.requests, r)
+}
+
+// BatchStats retrieves current statistics combined across all requests in the batch.
+// It is thread safe and can be called from a goroutine.
+func (b *Batch) BatchStats() BatchStat {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	stat := BatchStat{Requests: len(b.requests)}
+	var started time.Time
+	for _, r := range b.requests {
+		s := r.Stats()
+		stat.TotalBytes += s.TotalBytes
+		stat.ReadBytes += s.ReadBytes
+
+		r.mu.Lock()
+		if !r.started.IsZero() && (started.IsZero() || r.started.Before(started)) {
+			started = r.started
+		}
+		r.mu.Unlock()
+	}
+
+	if !started.IsZero() {
+		if elapsed := time.Since(started).Seconds(); elapsed > 0 {
+			stat.BytesPerSec = float64(stat.ReadBytes) / elapsed
+		}
+	}
+
+	return stat
+}
@@ -0,0 +1,82 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package braid
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestBatchStats(t *testing.T) {
+	sizes := []int64{1 << 20, 3 << 20}
+
+	// the resource must look unchanged between requests
+	modtime := time.Now()
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var size int64
+		fmt.Sscanf(r.URL.Path, "/%d", &size)
+		http.ServeContent(w, r, "data.bin", modtime, &data{size: size})
+	}))
+	defer ts.Close()
+
+	batch := NewBatch()
+	var wg sync.WaitGroup
+	for i, size := range sizes {
+		br, err := NewRequest()
+		if err != nil {
+			t.Fatal(err)
+		}
+		batch.Add(br)
+
+		filename := fmt.Sprintf("batch%d.bin", i)
+		url := fmt.Sprintf("%s/%d", ts.URL, size)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			file, err := br.FetchFile(context.Background(), url, filename)
+			if err != nil {
+				t.Error(err)
+			}
+			file.Close()
+			os.Remove(filename)
+		}()
+	}
+	wg.Wait()
+
+	var total int64
+	for _, size := range sizes {
+		total += size
+	}
+
+	stat := batch.BatchStats()
+	if stat.Requests != len(sizes) {
+		t.Fatalf("expected %d requests, got %d", len(sizes), stat.Requests)
+	}
+	if stat.TotalBytes != total {
+		t.Fatalf("BatchStats TotalBytes: expected %d, got %d", total, stat.TotalBytes)
+	}
+	if stat.ReadBytes != total {
+		t.Fatalf("BatchStats ReadBytes: expected %d, got %d", total, stat.ReadBytes)
+	}
+	if stat.BytesPerSec <= 0 {
+		t.Fatalf("BatchStats BytesPerSec should be positive, got %f", stat.BytesPerSec)
+	}
+}
@@ -16,42 +16,205 @@ limitations under the License.
 package braid
 
 import (
-	"bufio"
+	"compress/gzip"
 	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"hash"
 	"io"
+	"log/slog"
+	"net"
 	"net/http"
+	"net/http/httptrace"
 	"os"
+	"path/filepath"
+	"runtime"
 	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
 type Logger func(string, ...interface{})
 
-var logger Logger = func(a string, b ...interface{}) {}
+// defaultLogger is the logger of new requests, which is muted unless set by SetLogger.
+var defaultLogger Logger = func(a string, b ...interface{}) {}
 
-// SetLogger sets where log should be sent.
+// SetLogger sets where the log of requests created after it is called should be sent.
 // By default log is muted
+//
+// Deprecated: use Request.SetLogger, which lets concurrent requests log to different destinations.
 func SetLogger(l Logger) {
-	// wrap supplied logger & prepend the library name
-	logger = func(a string, b ...interface{}) {
+	defaultLogger = prefixLogger(l)
+}
+
+// prefixLogger wraps l to prepend the library name to messages.
+func prefixLogger(l Logger) Logger {
+	return func(a string, b ...interface{}) {
 		l("braid: "+a, b...)
 	}
 }
 
+// DefaultBufferSize is the size of the buffers that jobs read responses into by default.
+const DefaultBufferSize = 32 * 1024
+
 // DefaultJobs is the number of parallel HTTP requests to be made by default.
 const DefaultJobs = 5
 
+// DefaultFileMode is the permissions FetchFile creates files with by default, before the umask is applied.
+const DefaultFileMode os.FileMode = 0644
+
 type Request struct {
-	jobs      int
+	jobs            int
+	minJobs         int
+	minChunkSize    int64
+	chunkSize       int64
+	bufferSize      int
+	splitFunc       func(length int64, jobs int) [][2]int64
+	userAgent       string
+	acceptEncoding  string
+	authorization   string
+	requestHeader   http.Header
+	requestModifier func(*http.Request)
+	tokenProvider   func(ctx context.Context) (string, error)
+	eagerStart      bool
+	requireRanges   bool
+	maxSize         int64
+	verifyLength    bool
+	resume          bool
+	fileMode        os.FileMode
+	createDirs      bool
+	preallocate     bool
+	sync            bool
+	preserveModTime bool
+	maxTotalRetries int
+	maxRetries      int
+	retryBackoff    time.Duration
+	minSpeed        int64
+	minSpeedWindow  time.Duration
+	timeout         time.Duration
+	jobTimeout      time.Duration
+	limiter         *rateLimiter
+	cache           Cache
+	ifModified      condition
+	progressFunc    func(Stat)
+	metrics         MetricsSink
+	tracer          Tracer
+
+	httpClient       *http.Client
+	resolverCacheTTL time.Duration
+	proxyURL         string
+	tlsConfig        *tls.Config
+	maxRedirects     int
+	checkRedirect    func(req *http.Request, via []*http.Request) error
+	jar              http.CookieJar
+	lookupHost       func(ctx context.Context, host string) ([]string, error)
+	localAddrs       []net.Addr
+	dial             func(d *net.Dialer, ctx context.Context, network, addr string) (net.Conn, error)
+
+	dialTimeout           time.Duration
+	tlsHandshakeTimeout   time.Duration
+	responseHeaderTimeout time.Duration
+	maxIdleConnsPerHost   int
+
+	mirrors        []string
+	mirrorCooldown time.Duration
+
+	adaptiveJobs    bool
+	calibrationFunc func(Calibration)
+
+	adaptiveConcurrency  bool
+	minConcurrency       int
+	concurrencyThreshold int
+	maxConnsPerHost      int
+
+	streamBufferSize int64
+
+	hedging    bool
+	hedgeAfter float64
+
+	loadBalancing bool
+
+	segmentSize   int64
+	segmentHashes [][]byte
+
+	digestHash       func() hash.Hash
+	expectedDigest   string
+	concurrentVerify bool
+
+	// state of the current download
 	url       string
 	wg        sync.WaitGroup
 	mu        sync.Mutex
-	userAgent string
+	cancel    context.CancelFunc
+	stop      context.CancelCauseFunc
+	client    *http.Client
+	logger    Logger
+	slogger   *slog.Logger
+	transport *http.Transport
+	mirrorSet *mirrorSet
+	verifier  *segmentVerifier
+	digester  *digester
+	digest    []byte
+	ifMatch   string
+	ifRange   string
+	gzipped   bool
+	retries   int32
+	workers   int
+
+	// tokenMu guards token, the cached token from the token provider
+	tokenMu sync.Mutex
+	token   string
+
+	// bufPool holds the read buffers of finished jobs for reuse
+	bufPool sync.Pool
+
+	// progressMu serialises updates of progress, and lastProgress is when the last was made
+	progressMu   sync.Mutex
+	lastProgress int64
+	rate         rateMeter
+
+	// connections used by requests, updated atomically
+	connsReused int32
+	connsNew    int32
+
+	// details of the response used to discover the resource
+	resolvedURL string
+	header      http.Header
+
+	// w is where fetched bytes are written
+	w io.WriterAt
+
+	// concurrency limits how many jobs fetch at once, see SetAdaptiveConcurrency
+	concurrency *concurrencyLimit
+
+	// events queues events for the channel returned by Events
+	events *eventQueue
+
+	// faultFunc is consulted before each write to simulate errors, see SetFaultFunc
+	faultFunc func(jobID int, offset int64) error
 
 	// these are covered by mutex
-	file  *os.File
-	stats []Stat
+	file     *os.File
+	stats    []*jobStat
+	ranges   [][2]int64
+	hedges   map[int]*hedge
+	balancer *balancer
+	length   int64
+	started  time.Time
+	finished time.Time
+	// done is closed when the download ends, however it ends
+	done chan struct{}
+}
+
+// jobStat is the progress of a single job. It is updated atomically so that jobs don't contend on the mutex.
+type jobStat struct {
+	total atomic.Int64
+	read  atomic.Int64
 }
 
 type Stat struct {
@@ -59,193 +222,1878 @@ type Stat struct {
 	ReadBytes  int64
 }
 
-// NewRequest returns a new request.
-func NewRequest() (*Request, error) {
+// FetchResult holds the downloaded file together with details of the download.
+type FetchResult struct {
+	File *os.File
+	Stat Stat
+	// Size is the length of the resource
+	Size int64
+	// URL is the URL of the resource after following any redirects
+	URL         string
+	Header      http.Header
+	ContentType string
+	ETag        string
+	Elapsed     time.Duration
+	// Jobs is the number of jobs the resource was fetched by in parallel
+	Jobs int
+	// Retries is the number of retries made, including requests sent to another mirror
+	Retries int
+	Conns   ConnStats
+}
+
+// NewRequest returns a new request, configured by any options given.
+func NewRequest(opts ...Option) (*Request, error) {
 	r := &Request{
-		jobs: DefaultJobs,
+		jobs:           DefaultJobs,
+		rate:           rateMeter{window: rateWindow},
+		mirrorCooldown: DefaultMirrorCooldown,
+		maxRedirects:   DefaultMaxRedirects,
+		retryBackoff:   DefaultRetryBackoff,
+		fileMode:       DefaultFileMode,
+		preallocate:    true,
+		verifyLength:   true,
+		bufferSize:     DefaultBufferSize,
+		logger:         defaultLogger,
+	}
+	for _, opt := range opts {
+		opt(r)
 	}
 
 	return r, nil
 }
 
+// Reset clears the state left by the last download, such as its file, statistics and response
+// headers, while keeping the configuration, so that the request can be reused for another download.
+// Downloads can be made one after another without it, but Reset makes sure nothing of the last one
+// is carried over. It must not be called while a download is in progress.
+func (r *Request) Reset() {
+	r.url = ""
+	r.wg = sync.WaitGroup{}
+	r.cancel = nil
+	r.client = nil
+	r.transport = nil
+	r.mirrorSet = nil
+	r.verifier = nil
+	r.digester = nil
+	r.digest = nil
+	r.ifMatch = ""
+	r.ifRange = ""
+	r.gzipped = false
+	r.retries = 0
+	r.workers = 0
+	r.resolvedURL = ""
+	r.header = nil
+	r.w = nil
+	r.concurrency = nil
+	atomic.StoreInt32(&r.connsReused, 0)
+	atomic.StoreInt32(&r.connsNew, 0)
+	atomic.StoreInt64(&r.lastProgress, 0)
+	r.rate.reset()
+
+	r.tokenMu.Lock()
+	r.token = ""
+	r.tokenMu.Unlock()
+
+	// these are read by Cancel and the likes of Stats from other goroutines
+	r.mu.Lock()
+	r.stop = nil
+	r.file = nil
+	r.stats = nil
+	r.ranges = nil
+	r.hedges = nil
+	r.balancer = nil
+	r.length = 0
+	r.started = time.Time{}
+	r.finished = time.Time{}
+	r.done = nil
+	r.mu.Unlock()
+}
+
 // SetJobs sets the number of parallel requests that will be made. DefaultJobs is used by default.
 func (r *Request) SetJobs(jobs int) {
 	r.jobs = jobs
 }
 
+// SetMinChunkSize sets the smallest chunk each job should fetch. For small resources the number of jobs
+// is reduced so that no chunk is smaller than size. Zero (the default) disables the reduction.
+func (r *Request) SetMinChunkSize(size int64) {
+	r.minChunkSize = size
+}
+
+// SetChunkSize sets the size of the chunks the download is divided into, independent of the number of jobs.
+// Chunks are queued and each job fetches the next chunk in the queue once it has finished its last, so that
+// a fast connection takes on more of the download than a slow one. Each chunk has its own entry in JobStats.
+// Zero (the default) divides the download into one chunk per job.
+func (r *Request) SetChunkSize(size int64) {
+	r.chunkSize = size
+}
+
+// SetBufferSize sets the size of the buffers that jobs read responses into, which bounds the memory used by
+// a download to roughly the number of jobs times size. Buffers are shared between jobs, and reused as jobs
+// finish. DefaultBufferSize is used by default, or if size isn't positive.
+func (r *Request) SetBufferSize(size int) {
+	if size <= 0 {
+		size = DefaultBufferSize
+	}
+	r.bufferSize = size
+}
+
+// SetAutoJobs sets the number of jobs to be derived from the length of the resource, with a job for every
+// minChunkSize bytes up to maxJobs. A resource smaller than minChunkSize is fetched by a single job.
+// It is the same as calling SetMinChunkSize(minChunkSize) and SetJobs(maxJobs).
+func (r *Request) SetAutoJobs(minChunkSize int64, maxJobs int) {
+	r.SetMinChunkSize(minChunkSize)
+	r.SetJobs(maxJobs)
+}
+
+// SetMinJobs sets the least number of parallel requests that will be made when the server supports ranges.
+//
+// The number of jobs is determined as follows: SetJobs sets the number to use, SetMinChunkSize may then
+// reduce it for small resources, and finally SetMinJobs raises it back to at least jobs.
+// SetMinJobs therefore takes precedence over both SetMinChunkSize and SetJobs.
+func (r *Request) SetMinJobs(jobs int) {
+	r.minJobs = jobs
+}
+
+// SetSplitFunc sets the function used to divide the resource into ranges for the jobs to fetch.
+// It is called with the resource length and number of jobs, and must return contiguous [start,end)
+// ranges, in order, covering [0,length) exactly, otherwise the download fails. The number of ranges
+// returned determines the number of jobs. EvenSplit is used by default.
+func (r *Request) SetSplitFunc(f func(length int64, jobs int) [][2]int64) {
+	r.splitFunc = f
+}
+
 // SetUserAgent sets the 'User-Agent' HTTP header used when making requests
 func (r *Request) SetUserAgent(userAgent string) {
 	r.userAgent = userAgent
 }
 
+// SetHeader sets a header sent with every request, replacing any value already set for key.
+// A Range header is ignored, as braid manages ranges itself. SetUserAgent and the
+// credentials set by SetBasicAuth or SetBearerToken take precedence over headers set this way.
+func (r *Request) SetHeader(key, value string) {
+	if r.requestHeader == nil {
+		r.requestHeader = make(http.Header)
+	}
+	r.requestHeader.Set(key, value)
+}
+
+// SetHeaders sets headers sent with every request, replacing any values already set for their keys.
+// See SetHeader.
+func (r *Request) SetHeaders(header http.Header) {
+	if r.requestHeader == nil {
+		r.requestHeader = make(http.Header)
+	}
+	for key, values := range header {
+		r.requestHeader[http.CanonicalHeaderKey(key)] = append([]string(nil), values...)
+	}
+}
+
+// SetRequestModifier sets a function to be called with every request braid sends, the HEAD request and each
+// job's GET, after braid has set its own headers. It may add or change headers, for example to sign the
+// request, but not the Range header, which is restored to braid's value once it returns.
+// The function is called from the jobs' goroutines, so may be called concurrently.
+func (r *Request) SetRequestModifier(f func(*http.Request)) {
+	r.requestModifier = f
+}
+
+// SetBasicAuth sets the username and password sent with every request, including to mirrors,
+// using HTTP basic authentication. It replaces any bearer token set with SetBearerToken.
+func (r *Request) SetBasicAuth(username, password string) {
+	r.authorization = "Basic " + base64.StdEncoding.EncodeToString([]byte(username+":"+password))
+}
+
+// SetBearerToken sets the token sent with every request, including to mirrors, in a bearer
+// 'Authorization' header. It replaces any credentials set with SetBasicAuth.
+func (r *Request) SetBearerToken(token string) {
+	r.authorization = "Bearer " + token
+}
+
+// SetTokenProvider sets a function that supplies the token sent with every request in a bearer
+// 'Authorization' header, for tokens that may expire during a long download. The token is fetched when
+// first needed and reused until a request is rejected with 401 Unauthorized, when a fresh one is fetched
+// and the request retried once. It takes precedence over SetBasicAuth and SetBearerToken.
+func (r *Request) SetTokenProvider(f func(ctx context.Context) (string, error)) {
+	r.tokenProvider = f
+	r.tokenMu.Lock()
+	r.token = ""
+	r.tokenMu.Unlock()
+}
+
+// SetFileMode sets the permissions FetchFile creates files with, before the umask is applied.
+// DefaultFileMode is used by default.
+func (r *Request) SetFileMode(mode os.FileMode) {
+	r.fileMode = mode
+}
+
+// SetCreateDirs sets whether FetchFile should create any missing parent directories of the filename
+// it is given. Disabled by default.
+func (r *Request) SetCreateDirs(create bool) {
+	r.createDirs = create
+}
+
+// SetSync sets whether FetchFile should flush the completed download to stable storage before returning,
+// syncing both the file and the directory it's renamed into. FetchToFile syncs the file it's given.
+// Disabled by default.
+func (r *Request) SetSync(sync bool) {
+	r.sync = sync
+}
+
+// SetPreserveModTime sets whether FetchFile should set the modification time of the completed download
+// to the resource's Last-Modified time, as reported by the server when the download started. The time
+// is left alone if the server doesn't report a valid one. Disabled by default.
+func (r *Request) SetPreserveModTime(preserve bool) {
+	r.preserveModTime = preserve
+}
+
+// SetEagerStart sets whether the first job should start downloading straight away rather than waiting
+// for a HEAD request to complete. The length of the resource is then learnt from the first job's response,
+// saving a round trip. Disabled by default.
+func (r *Request) SetEagerStart(eager bool) {
+	r.eagerStart = eager
+}
+
+// SetRequireRanges sets whether the download must be made in parallel. If the server responds to a ranged
+// request with the whole resource, the download is aborted with ErrRangesNotSupported rather than
+// falling back to a single job. Similarly if the length of the resource is unknown, the download is
+// aborted with ErrNoContentLength. Disabled by default.
+func (r *Request) SetRequireRanges(require bool) {
+	r.requireRanges = require
+}
+
+// SetVerifyLength sets whether the number of bytes fetched is checked against the length of the resource
+// once the download completes, failing with ErrShortDownload if they differ. A resource of unknown
+// length, such as one streamed without a Content-Length, isn't checked. Enabled by default.
+func (r *Request) SetVerifyLength(verify bool) {
+	r.verifyLength = verify
+}
+
+// SetMaxSize sets the largest resource that may be downloaded, guarding against a server sending far
+// more than expected. A resource whose length is known to be larger fails with ErrTooLarge before
+// anything is fetched, and a download of unknown length fails with ErrTooLarge once it reads more than
+// n bytes. Zero (the default) means no maximum.
+func (r *Request) SetMaxSize(n int64) {
+	r.maxSize = n
+}
+
+// SetMaxTotalRetries caps the number of retries made across all jobs during a download, such as
+// when a chunk is sent to another mirror. Once the budget is spent the download is aborted with
+// ErrRetryBudgetExhausted. Zero (the default) means no cap.
+func (r *Request) SetMaxTotalRetries(n int) {
+	r.maxTotalRetries = n
+}
+
+// takeRetry takes a retry from the download's budget, returning ErrRetryBudgetExhausted if there are none left.
+func (r *Request) takeRetry() error {
+	retries := atomic.AddInt32(&r.retries, 1)
+	if r.maxTotalRetries > 0 && int(retries) > r.maxTotalRetries {
+		return fmt.Errorf("%w after %d retries", ErrRetryBudgetExhausted, r.maxTotalRetries)
+	}
+	return nil
+}
+
+// SetSegmentHashes sets the expected SHA-256 hashes of consecutive size byte segments of the resource.
+// Each segment is verified as soon as it has been completely written, and the download is
+// aborted with ErrSegmentMismatch on the first mismatch rather than after the whole file has arrived.
+// The final segment may be shorter than size.
+func (r *Request) SetSegmentHashes(size int64, hashes [][]byte) {
+	r.segmentSize = size
+	r.segmentHashes = hashes
+}
+
+// SetDigest sets the hash used to compute a digest of the downloaded resource, which is available
+// from Digest once the download completes. By default no digest is computed.
+func (r *Request) SetDigest(newHash func() hash.Hash) {
+	r.digestHash = newHash
+}
+
+// SetExpectedChecksum sets the hash used to compute a digest of the downloaded resource, as SetDigest does,
+// along with the digest expected as a hex string. If the digest of the completed download doesn't match,
+// the downloaded file and any resume state are removed and ErrChecksumMismatch is returned.
+func (r *Request) SetExpectedChecksum(newHash func() hash.Hash, expectedHex string) {
+	r.digestHash = newHash
+	r.expectedDigest = expectedHex
+}
+
+// SetConcurrentVerify sets whether the digest should be computed while the download is in progress.
+// Completed data is read back and hashed in the background as it arrives, rather than the whole file
+// being read back once every job has finished. Disabled by default.
+func (r *Request) SetConcurrentVerify(concurrent bool) {
+	r.concurrentVerify = concurrent
+}
+
+// Digest returns the digest computed for the last download, or nil if none was computed. See SetDigest.
+func (r *Request) Digest() []byte {
+	return r.digest
+}
+
+// Encodings supported by DigestString.
+const (
+	DigestHex       = "hex"
+	DigestBase64    = "base64"
+	DigestBase64URL = "base64url"
+)
+
+// DigestString returns the digest computed for the last download in the given encoding: DigestHex
+// (as used by most checksum tools), DigestBase64 (as used by S3's Content-MD5) or DigestBase64URL.
+func (r *Request) DigestString(encoding string) (string, error) {
+	if r.digest == nil {
+		return "", fmt.Errorf("no digest was computed")
+	}
+	switch encoding {
+	case DigestHex:
+		return hex.EncodeToString(r.digest), nil
+	case DigestBase64:
+		return base64.StdEncoding.EncodeToString(r.digest), nil
+	case DigestBase64URL:
+		return base64.URLEncoding.EncodeToString(r.digest), nil
+	}
+	return "", fmt.Errorf("unknown digest encoding '%s'", encoding)
+}
+
+// ResponseHeaders returns a copy of the headers of the response that described the resource in the
+// last download, or nil if there was none. They're from the HEAD request, or from the ranged GET that
+// replaced it, so that details such as Content-Type, Content-Disposition and Last-Modified are
+// available once FetchFile returns.
+func (r *Request) ResponseHeaders() http.Header {
+	return r.header.Clone()
+}
+
 // Stats retrieves current statistics. It is thread safe and can be called from a goroutine.
 func (r *Request) Stats() Stat {
 	stat := Stat{}
 	r.mu.Lock()
 	defer r.mu.Unlock()
 	for _, s := range r.stats {
-		stat.TotalBytes += s.TotalBytes
-		stat.ReadBytes += s.ReadBytes
+		stat.TotalBytes += s.total.Load()
+		stat.ReadBytes += s.read.Load()
+	}
+	if r.balancer != nil {
+		stat.TotalBytes += r.balancer.remaining()
 	}
 
 	return stat
 }
 
-// FetchFile fetches the resource, returning the result as an *os.File
-// The caller is responsible for closing the returned file.
-// Filename must be writable, will be created if missing and will be truncated.
-func (r *Request) FetchFile(ctx context.Context, url, filename string) (*os.File, error) {
-	var err error
-	var length int
-	var req *http.Request
-	var res *http.Response
+// Percent returns how much of the current or last download has been fetched as a percentage, from 0
+// to 100, or -1 while the length of the resource is unknown. It is thread safe and can be called from
+// a goroutine.
+func (r *Request) Percent() float64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.length < 0 {
+		return -1
+	}
+	var total, read int64
+	for _, s := range r.stats {
+		total += s.total.Load()
+		read += s.read.Load()
+	}
+	if r.balancer != nil {
+		total += r.balancer.remaining()
+	}
+	if total <= 0 {
+		return 0
+	}
+	percent := float64(read) * 100 / float64(total)
+	if percent > 100 {
+		percent = 100
+	}
+	return percent
+}
+
+// JobStats returns a copy of the statistics of each job, so that a lagging job can be spotted.
+// There is an entry for each job launched, in the order they were launched, which includes the jobs
+// that measure throughput when adaptive jobs are enabled. When resuming, chunks that a previous
+// session completed have an entry too. It is thread safe and can be called from a goroutine.
+func (r *Request) JobStats() []Stat {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	stats := make([]Stat, len(r.stats))
+	for i, s := range r.stats {
+		stats[i] = Stat{TotalBytes: s.total.Load(), ReadBytes: s.read.Load()}
+	}
+	return stats
+}
 
-	r.file, err = os.OpenFile(filename, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0777)
+// FetchFile fetches the resource, returning the result as an *os.File positioned at its start.
+// The caller is responsible for closing the returned file. No file is returned if the download fails,
+// unless it's stopped by Cancel.
+// The download is written to filename with a '.part' suffix, which is renamed to filename once the download
+// succeeds, so filename never holds a partial download. If the download fails, the partial download is
+// removed unless it can be resumed, see SetResume, or was stopped by Cancel.
+// If the server supplies an ETag or Last-Modified header, ErrResourceChanged is returned should the
+// resource change while it is being downloaded. The error of each job that fails is a *RangeError
+// giving the range it didn't fetch, which can be found with errors.As.
+func (r *Request) FetchFile(ctx context.Context, url, filename string) (*os.File, error) {
+	file, err := r.fetch(ctx, url, filename, nil, r.resume)
+	if r.resume && errors.Is(err, ErrResourceChanged) {
+		// bytes from two versions of the resource mustn't be stitched together
+		r.log(slog.LevelWarn, "resource changed since the download started, restarting")
+		if file != nil {
+			file.Close()
+		}
+		file, err = r.fetch(ctx, url, filename, nil, false)
+	}
 	if err != nil {
+		if keepPartial(file, err) {
+			return file, err
+		}
+		if file != nil {
+			file.Close()
+			// a partial download is only worth keeping if there is the state to resume it
+			if _, statErr := os.Stat(resumeFilename(filename)); !r.resume || statErr != nil {
+				os.Remove(partFilename(filename))
+			}
+		}
 		return nil, err
 	}
+	return file, nil
+}
+
+// FetchWriterAt fetches the resource, writing it to w at the offset of each byte in the resource,
+// and returns the number of bytes written. Jobs write to w concurrently, though never to overlapping ranges.
+// Resuming and caching only apply to FetchFile. Verifying segments or computing a digest requires w
+// to also implement io.ReaderAt, so that the download can be read back.
+func (r *Request) FetchWriterAt(ctx context.Context, url string, w io.WriterAt) (int64, error) {
+	_, err := r.fetch(ctx, url, "", w, false)
+	return r.Stats().ReadBytes, err
+}
+
+// FetchToFile fetches the resource to f, replacing its contents, and returns the number of bytes
+// written. The file is allocated to the length of the resource first, unless disabled by SetPreallocate.
+// The file is left open and its offset unchanged; closing it is up to the caller. Unlike FetchFile there
+// is no partial file to rename, so a failed download leaves f holding what was fetched. Resuming and
+// caching only apply to FetchFile.
+func (r *Request) FetchToFile(ctx context.Context, url string, f *os.File) (int64, error) {
+	_, err := r.fetch(ctx, url, "", callerFile{f}, false)
+	return r.Stats().ReadBytes, err
+}
+
+// callerFile is a file given by the caller to download to, which fetch prepares like one it opens itself.
+type callerFile struct {
+	*os.File
+}
+
+// fetch fetches the resource to w or, if w is nil, to filename. If resume is true, it continues
+// from where a previous download to filename left off.
+func (r *Request) fetch(ctx context.Context, url, filename string, w io.WriterAt, resume bool) (file *os.File, err error) {
+	end := r.begin()
+	defer end()
+	var length int64
+
+	ctx, span := r.startSpan(ctx, "braid.fetch")
+	if span != nil {
+		span.SetAttribute("braid.url", url)
+		defer func() {
+			r.mu.Lock()
+			span.SetAttribute("braid.size", r.length)
+			r.mu.Unlock()
+			span.SetAttribute("braid.bytes", r.Stats().ReadBytes)
+			span.SetAttribute("braid.jobs", r.workers)
+			endSpan(span, err)
+		}()
+	}
+
+	var state *resumeState
+	if resume && w == nil {
+		state = r.loadResumeState(filename, url)
+	}
+
+	defer func() { r.closeEvents(err) }()
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+	ctx, stop := r.cancellable(ctx)
+	defer stop()
+
+	parent := ctx
+	defer func() { err = r.cancelledError(parent, err) }()
+	ctx, r.cancel = context.WithCancel(ctx)
+	defer r.cancel()
+
+	stream, _ := w.(*streamBuffer)
+	if stream != nil {
+		// jobs waiting for the reader to catch up stop once the download is cancelled
+		stop := context.AfterFunc(ctx, stream.abort)
+		defer stop()
+	}
 
 	r.url = url
-	client := &http.Client{}
-	req, err = http.NewRequest("HEAD", r.url, nil)
+	r.client, err = r.newClient()
 	if err != nil {
 		return nil, err
 	}
-	req = req.WithContext(ctx)
-	if r.userAgent != "" {
-		req.Header.Set("User-Agent", r.userAgent)
+	if r.transport != nil {
+		defer r.transport.CloseIdleConnections()
 	}
-	res, err = client.Do(req)
-	if err != nil {
-		return nil, fmt.Errorf("error fetching HEAD: %s\n", err)
+	r.mirrorSet = newMirrorSet(append([]string{url}, r.mirrors...), r.mirrorCooldown)
+	r.resolvedURL = ""
+	r.header = nil
+	r.ifMatch = ""
+	r.ifRange = ""
+	r.gzipped = false
+	r.retries = 0
+	r.workers = 0
+	r.concurrency = nil
+	atomic.StoreInt32(&r.connsReused, 0)
+	atomic.StoreInt32(&r.connsNew, 0)
+
+	var cached CacheEntry
+	if r.cache != nil && w == nil {
+		cached, _ = r.cache.Get(url)
 	}
 
-	headers := res.Header
-	length, err = strconv.Atoi(headers["Content-Length"][0])
-	if err != nil {
-		return nil, err
+	// a cached copy is only checked for if the caller hasn't given their own
+	cond := r.ifModified
+	fromCache := cond == condition{} && cached.ETag != ""
+	if fromCache {
+		cond.etag = cached.ETag
+	}
+
+	// first is the already open response for job 0 when starting eagerly
+	var first *http.Response
+	rangesSupported := true
+	if r.eagerStart && state == nil {
+		first, length, err = r.probeGet(ctx, "bytes=0-", cond)
+		if err == ErrNotModified && fromCache {
+			if file := r.openCached(url, cached); file != nil {
+				return file, nil
+			}
+			first, length, err = r.probeGet(ctx, "bytes=0-", r.ifModified)
+		}
+		if err != nil {
+			return nil, err
+		}
+		r.resolvedURL = first.Request.URL.String()
+		r.header = first.Header
+		if gzipEncoded(first.Header) {
+			// the response was only the start of the encoded bytes
+			first.Body.Close()
+			first, length = nil, -1
+			r.gzipped = true
+		}
+	} else {
+		first, length, rangesSupported, err = r.discover(ctx, cond)
+		if err == ErrNotModified && fromCache {
+			if file := r.openCached(url, cached); file != nil {
+				return file, nil
+			}
+			first, length, rangesSupported, err = r.discover(ctx, r.ifModified)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if r.gzipped {
+		r.log(slog.LevelInfo, "resource is only served gzip encoded, fetching it whole")
+	}
+
+	// jobs request the URL the resource was found at rather than following the same redirects, which
+	// may lead elsewhere, such as to a CDN whose range support differs from that probed
+	if r.resolvedURL != "" {
+		r.mirrorSet.resolve(r.resolvedURL)
+	}
+
+	if r.maxSize > 0 && length > r.maxSize {
+		if first != nil {
+			first.Body.Close()
+		}
+		return nil, fmt.Errorf("%w: %d bytes is more than the maximum of %d", ErrTooLarge, length, r.maxSize)
+	}
+
+	// a full response can't be split, so is read by a single job
+	if first != nil && first.StatusCode == http.StatusOK {
+		rangesSupported = false
+	}
+	if length < 0 {
+		if r.requireRanges {
+			if first != nil {
+				first.Body.Close()
+			}
+			return nil, ErrNoContentLength
+		}
+		r.log(slog.LevelInfo, "resource length is unknown, using a single job")
+		rangesSupported = false
+	}
+	if length == 0 && first != nil {
+		// an empty resource has nothing to fetch, so the file is just created
+		first.Body.Close()
+		first = nil
+	}
+	if !rangesSupported {
+		if r.requireRanges {
+			if first != nil {
+				first.Body.Close()
+			}
+			return nil, ErrRangesNotSupported
+		}
+		r.log(slog.LevelInfo, "server doesn't support ranges, using a single job")
+		if state != nil {
+			r.log(slog.LevelInfo, "not resuming: server doesn't support ranges")
+			state = nil
+		}
+	}
+
+	if len(r.mirrors) > 0 && rangesSupported && length > 0 {
+		r.checkMirrors(ctx, length)
+	}
+
+	// jobs make their requests conditional on the resource being unchanged, so that a resource
+	// that changes mid-download isn't stitched together from two versions
+	if rangesSupported && r.header != nil {
+		r.ifRange = ifRangeValidator(r.header)
+	}
+
+	flags := os.O_RDWR | os.O_CREATE | os.O_TRUNC
+	if state != nil {
+		if err = state.check(partFilename(filename), length, r.header); err != nil {
+			r.log(slog.LevelInfo, fmt.Sprintf("not resuming: %s", err))
+			state = nil
+		} else {
+			r.log(slog.LevelInfo, fmt.Sprintf("resuming download to %s", filename))
+			r.ifMatch = state.ETag
+			flags &^= os.O_TRUNC
+		}
+	}
+
+	r.file = nil
+	r.w = w
+	if w == nil {
+		// file is opened for reading too so that segments can be read back for verification
+		r.file, err = r.openFile(partFilename(filename), flags)
+		if err != nil {
+			if first != nil {
+				first.Body.Close()
+			}
+			return nil, err
+		}
+		if r.preallocate && length > 0 {
+			if err = allocate(r.file, length); err != nil {
+				if first != nil {
+					first.Body.Close()
+				}
+				return r.file, err
+			}
+		}
+		r.w = r.file
+	} else if f, ok := w.(callerFile); ok {
+		// the caller's file is replaced by the download
+		err = f.Truncate(0)
+		if err == nil && r.preallocate && length > 0 {
+			err = allocate(f.File, length)
+		}
+		if err != nil {
+			if first != nil {
+				first.Body.Close()
+			}
+			return nil, err
+		}
+	}
+
+	// the download is read back to verify it
+	ra, _ := r.w.(io.ReaderAt)
+	if (r.segmentHashes != nil || r.digestHash != nil) && ra == nil {
+		if first != nil {
+			first.Body.Close()
+		}
+		return nil, errors.New("verifying the download requires an io.ReaderAt")
+	}
+
+	r.verifier = nil
+	if r.segmentHashes != nil && length < 0 {
+		if first != nil {
+			first.Body.Close()
+		}
+		return r.file, fmt.Errorf("segment hashes can't be verified: %w", ErrNoContentLength)
+	}
+	if r.segmentHashes != nil {
+		r.verifier, err = newSegmentVerifier(ra, length, r.segmentSize, r.segmentHashes)
+		if err != nil {
+			if first != nil {
+				first.Body.Close()
+			}
+			return r.file, err
+		}
+	}
+
+	r.digest = nil
+	r.digester = nil
+	if r.digestHash != nil {
+		r.digester = newDigester(ra, length, r.digestHash, r.concurrentVerify)
+		defer r.digester.stop()
 	}
 
-	if r.jobs <= 0 {
-		r.jobs = 1
+	jobs := 1
+	if rangesSupported {
+		jobs = r.jobCount(length)
 	}
-	chunkSize := length / r.jobs
-	chunkSizeLast := length % r.jobs
 
 	r.mu.Lock()
-	r.stats = make([]Stat, r.jobs)
+	r.stats = nil
+	r.ranges = nil
+	r.hedges = nil
+	r.balancer = nil
+	r.length = length
+	r.started = time.Now()
+	r.rate.reset()
+	r.finished = time.Time{}
 	r.mu.Unlock()
-	r.wg.Add(r.jobs)
 
-	logger("fetching %s\n", r.url)
-	logger("launching %d jobs\n", r.jobs)
+	r.log(slog.LevelInfo, fmt.Sprintf("fetching %s", r.url))
+
+	// offset is where the parallel jobs start from
+	var offset int64
+	if r.adaptiveJobs && rangesSupported && first == nil && state == nil && length >= 2*calibrationLength {
+		offset, jobs, err = r.calibrate(ctx)
+		if err != nil {
+			return r.file, err
+		}
+	}
+
+	// a stream is fetched in chunks that fill its buffer, so that jobs work just ahead of the reader
+	chunkSize := r.chunkSize
+	if stream != nil && chunkSize == 0 {
+		chunkSize = stream.chunkSize(jobs)
+	}
 
-	errChan := make(chan error)
-	for i := 0; i < r.jobs; i++ {
+	// spans are sized as jobs ask for them, so the progress that resuming saves isn't known up front
+	balance := r.loadBalancing && stream == nil && state == nil && !(r.resume && w == nil) &&
+		length > 0 && rangesSupported && first == nil
 
-		min := chunkSize * i
-		max := chunkSize * (i + 1)
+	var chunks []ManifestChunk
+	if balance {
+		// jobs take spans from the balancer instead
+	} else if state != nil {
+		chunks = state.Chunks
+	} else if length < 0 {
+		// the job reads until the response ends
+		chunks = []ManifestChunk{{Start: 0, End: -1}}
+	} else {
+		chunks, err = r.layout(offset, length, jobs, chunkSize, rangesSupported)
+		if err != nil {
+			if first != nil {
+				first.Body.Close()
+			}
+			return r.file, err
+		}
+	}
 
-		if i == r.jobs-1 {
-			max += chunkSizeLast
+	queue := make(chan queuedChunk, len(chunks))
+	for i, c := range chunks {
+		jobID := r.addJob(c.Start, c.End)
+		if c.Bytes > 0 {
+			if err = r.resumed(jobID, c); err != nil {
+				return r.file, err
+			}
+		}
+		if c.Start+c.Bytes == c.End {
+			continue
+		}
+		q := queuedChunk{jobID: jobID, min: c.Start + c.Bytes, max: c.End}
+		if i == 0 && first != nil {
+			q.resp = first
 		}
+		queue <- q
+	}
+	close(queue)
 
+	// without a chunk size there is a job for each chunk
+	workers := len(queue)
+	if chunkSize > 0 && jobs < workers {
+		workers = jobs
+	}
+	if balance {
+		workers = jobs
 		r.mu.Lock()
-		r.stats[i].TotalBytes = int64(max - min)
+		r.balancer = newBalancer(offset, length, workers)
 		r.mu.Unlock()
-		go r.fetchFile(ctx, min, max, i, errChan)
 	}
+	r.workers = workers
+	r.concurrency = r.newConcurrency(workers)
 
-	quitChan := make(chan struct{})
-	var mu sync.Mutex
-	errors := ""
+	r.log(slog.LevelInfo, fmt.Sprintf("launching %d jobs to fetch %d chunks", workers, len(queue)),
+		slog.Int("jobs", workers), slog.Int("chunks", len(queue)))
 
-	var errWg sync.WaitGroup
-	errWg.Add(1)
-	go func() {
-		defer errWg.Done()
-		for {
-			select {
-			case err := <-errChan:
-				mu.Lock()
-				errors += err.Error() + "\n"
-				mu.Unlock()
-			case <-quitChan:
-				return
-			}
+	// jobs never block sending their error
+	errChan := make(chan error, len(chunks)+workers)
+	r.wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		if balance {
+			go r.balancedWorker(ctx, i, errChan)
+		} else {
+			go r.worker(ctx, queue, errChan)
 		}
-	}()
+	}
 
-	r.wg.Wait()
-	close(quitChan)
-	errWg.Wait()
+	// progress can't be saved without knowing where the resource ends
+	saveState := r.resume && w == nil && length >= 0
 
-	mu.Lock()
-	defer mu.Unlock()
-	if errors != "" {
-		return r.file, fmt.Errorf("%s", errors)
-	} else {
-		return r.file, nil
+	var saveQuit chan struct{}
+	if saveState {
+		saveQuit = make(chan struct{})
+		go r.saveResumeStateEvery(filename, saveQuit)
 	}
-}
 
-func (r *Request) fetchFile(ctx context.Context, min int, max int, jobID int, errChan chan error) {
-	defer r.wg.Done()
-	client := &http.Client{}
-	req, err := http.NewRequest("GET", r.url, nil)
-	if err != nil {
-		errChan <- err
-		return
+	var hedgeQuit chan struct{}
+	if r.hedging && length > 0 && rangesSupported {
+		hedgeQuit = make(chan struct{})
+		go r.hedgeLagging(ctx, queue, hedgeQuit)
 	}
-	req = req.WithContext(ctx)
-	range_header := "bytes=" + strconv.Itoa(min) + "-" + strconv.Itoa(max-1)
-	req.Header.Add("Range", range_header)
 
-	if r.userAgent != "" {
-		req.Header.Set("User-Agent", r.userAgent)
+	errs := r.wait(errChan)
+	if hedgeQuit != nil {
+		close(hedgeQuit)
 	}
-
-	resp, err := client.Do(req)
-	if err != nil {
-		errChan <- err
-		return
+	if len(errs) == 0 && ctx.Err() != nil {
+		// chunks still queued when the download was cancelled were never fetched
+		errs = append(errs, ctx.Err())
 	}
-	defer resp.Body.Close()
 
-	reader := bufio.NewReader(resp.Body)
+	if saveQuit != nil {
+		close(saveQuit)
+	}
 
-	read := 0
-	for {
-		var end bool
-		line, err := reader.ReadBytes('\n')
-		if err != nil {
-			if err == io.EOF {
-				end = true
-			} else {
-				errChan <- err
-				return
+	if len(errs) > 0 {
+		err = jobsError(parent, errs)
+		if errors.Is(err, ErrRangeNotSatisfiable) {
+			// the resource isn't what it was thought to be, so what has been fetched is worthless
+			if w == nil {
+				r.discard(filename)
 			}
+			return nil, err
 		}
-		var count int
-		count, err = r.file.WriteAt(line, int64(min+read))
-		read += len(line)
+		if saveState {
+			if err := r.saveResumeState(filename); err != nil {
+				r.log(slog.LevelWarn, fmt.Sprintf("error saving resume state: %s", err))
+			}
+		}
+		return r.file, err
+	}
+
+	if length < 0 {
 		r.mu.Lock()
-		r.stats[jobID].ReadBytes = int64(read)
+		r.length = r.stats[0].read.Load()
+		length = r.length
 		r.mu.Unlock()
+		if r.digester != nil {
+			r.digester.length = length
+		}
+	} else if err = r.checkLength(length); err != nil {
+		r.log(slog.LevelError, err.Error())
+		return r.file, err
+	}
+
+	if r.digester != nil {
+		r.digest, err = r.digester.sum()
 		if err != nil {
-			errChan <- err
-			return
+			return r.file, err
 		}
+		if r.expectedDigest != "" && !strings.EqualFold(hex.EncodeToString(r.digest), r.expectedDigest) {
+			err = fmt.Errorf("%w: expected %s, got %x", ErrChecksumMismatch, r.expectedDigest, r.digest)
+			r.log(slog.LevelError, err.Error())
+			if w == nil {
+				// a corrupt download mustn't be resumed or mistaken for a good one
+				r.discard(filename)
+			}
+			return nil, err
+		}
+	}
 
-		if count != len(line) {
-			err = fmt.Errorf("write error: expected %d bytes, got %d bytes\n", len(line), count)
-			logger(err.Error())
-			errChan <- err
-			return
+	if f, ok := w.(callerFile); ok && r.sync {
+		if err = syncFile(f.File); err != nil {
+			return nil, err
 		}
+	}
 
-		if end {
-			break
+	if w == nil {
+		if err = r.complete(filename); err != nil {
+			return nil, err
 		}
 	}
+
+	if r.resume && w == nil {
+		removeResumeState(filename)
+	}
+
+	if r.cache != nil && w == nil {
+		if etag := r.header.Get("ETag"); etag != "" {
+			r.cache.Put(url, CacheEntry{ETag: etag, Size: length, Path: filename})
+		}
+	}
+
+	return r.file, nil
+}
+
+// openFile opens filename with flags, creating its parent directories first if SetCreateDirs is enabled.
+func (r *Request) openFile(filename string, flags int) (*os.File, error) {
+	if r.createDirs {
+		if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+			return nil, err
+		}
+	}
+	return os.OpenFile(filename, flags, r.fileMode)
+}
+
+// syncFile flushes f to stable storage. It's a variable so that tests can observe it.
+var syncFile = (*os.File).Sync
+
+// syncDir flushes the directory dir to stable storage, so that a rename into it is durable. It does
+// nothing on Windows, where directories can't be synced.
+func syncDir(dir string) error {
+	if runtime.GOOS == "windows" {
+		return nil
+	}
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return syncFile(d)
+}
+
+// discard closes and removes the downloaded file along with any resume state.
+func (r *Request) discard(filename string) {
+	r.file.Close()
+	os.Remove(partFilename(filename))
+	removeResumeState(filename)
+}
+
+// complete renames the finished download to filename, reopening it as the file to be returned. Its
+// modification time is set first if SetPreserveModTime is enabled.
+func (r *Request) complete(filename string) error {
+	if r.sync {
+		if err := syncFile(r.file); err != nil {
+			r.file.Close()
+			return err
+		}
+	}
+	// the file is closed first as an open file can't be renamed on every platform
+	if err := r.file.Close(); err != nil {
+		return err
+	}
+	if r.preserveModTime {
+		if modTime, err := http.ParseTime(r.header.Get("Last-Modified")); err == nil {
+			if err := os.Chtimes(partFilename(filename), modTime, modTime); err != nil {
+				return err
+			}
+		}
+	}
+	if err := os.Rename(partFilename(filename), filename); err != nil {
+		return err
+	}
+	if r.sync {
+		if err := syncDir(filepath.Dir(filename)); err != nil {
+			return err
+		}
+	}
+	file, err := os.OpenFile(filename, os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	r.file = file
+	return nil
+}
+
+// wait waits for the jobs to finish, returning the errors they sent to errChan.
+// Each job sends at most one error, so errChan must be buffered for all of them.
+func (r *Request) wait(errChan chan error) []error {
+	r.wg.Wait()
+	close(errChan)
+
+	r.mu.Lock()
+	r.finished = time.Now()
+	r.mu.Unlock()
+	r.update(true)
+
+	var errs []error
+	for err := range errChan {
+		errs = append(errs, err)
+	}
+	return errs
+}
+
+// jobsError combines the errors from the jobs of a download started with the parent context.
+// All of the errors can be inspected with errors.Is and errors.As.
+func jobsError(parent context.Context, errs []error) error {
+	// the caller cancelling or timing out is reported in preference to the job errors it caused
+	if err := parent.Err(); err != nil {
+		return fmt.Errorf("download interrupted: %w", errors.Join(append([]error{err}, errs...)...))
+	}
+	return errors.Join(errs...)
+}
+
+// checkLength returns ErrShortDownload if the jobs didn't fetch length bytes between them, see SetVerifyLength.
+func (r *Request) checkLength(length int64) error {
+	if !r.verifyLength {
+		return nil
+	}
+	if read := r.Stats().ReadBytes; read != length {
+		return fmt.Errorf("%w: fetched %d of %d bytes, %d missing", ErrShortDownload, read, length, length-read)
+	}
+	return nil
+}
+
+// layout divides bytes offset to length-1 of the resource into the chunks fetched by jobs. With a chunk
+// size the resource is divided into as many chunks as it takes, otherwise there is a chunk for each job.
+func (r *Request) layout(offset, length int64, jobs int, chunkSize int64, rangesSupported bool) ([]ManifestChunk, error) {
+	n := jobs
+	if chunkSize > 0 && rangesSupported {
+		n = int((length - offset + chunkSize - 1) / chunkSize)
+		if n < 1 {
+			n = 1
+		}
+	}
+	return r.split(offset, length, n)
+}
+
+// split divides bytes offset to length-1 of the resource into chunks for jobs using the split function.
+func (r *Request) split(offset, length int64, jobs int) ([]ManifestChunk, error) {
+	splitFunc := r.splitFunc
+	if splitFunc == nil || jobs == 1 {
+		splitFunc = EvenSplit
+	}
+
+	ranges := splitFunc(length-offset, jobs)
+	chunks := make([]ManifestChunk, 0, len(ranges))
+	var end int64
+	for i, rng := range ranges {
+		if rng[0] != end || rng[1] < rng[0] {
+			return nil, fmt.Errorf("invalid split: range %d %v doesn't follow on from %d", i, rng, end)
+		}
+		end = rng[1]
+		// an empty range would make an invalid Range header, so gets no job
+		if rng[0] == rng[1] {
+			continue
+		}
+		chunks = append(chunks, ManifestChunk{Start: offset + rng[0], End: offset + rng[1]})
+	}
+	if end != length-offset {
+		return nil, fmt.Errorf("invalid split: ranges cover %d bytes, expected %d", end, length-offset)
+	}
+
+	return chunks, nil
+}
+
+// EvenSplit divides length bytes into jobs equal [start,end) ranges, with the last range taking
+// any remainder. It is the default split function.
+func EvenSplit(length int64, jobs int) [][2]int64 {
+	chunkSize := length / int64(jobs)
+	chunkSizeLast := length % int64(jobs)
+
+	ranges := make([][2]int64, jobs)
+	for i := range ranges {
+		min := chunkSize * int64(i)
+		max := chunkSize * int64(i+1)
+
+		if i == jobs-1 {
+			max += chunkSizeLast
+		}
+		ranges[i] = [2]int64{min, max}
+	}
+	return ranges
+}
+
+// addJob adds stats for a job fetching bytes min to max-1, returning its job ID.
+// If max is negative the job's total grows as it reads.
+func (r *Request) addJob(min, max int64) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.addJobLocked(min, max)
+}
+
+// addJobLocked is addJob for a caller holding mu.
+func (r *Request) addJobLocked(min, max int64) int {
+	var total int64
+	if max >= 0 {
+		total = max - min
+	}
+	s := &jobStat{}
+	s.total.Store(total)
+	r.stats = append(r.stats, s)
+	r.ranges = append(r.ranges, [2]int64{min, max})
+	return len(r.stats) - 1
+}
+
+// jobStat returns the stats of a job and the offset its range starts at.
+func (r *Request) jobStat(jobID int) (*jobStat, int64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.stats[jobID], r.ranges[jobID][0]
+}
+
+// jobCount returns the number of jobs to use for a resource of the given length.
+func (r *Request) jobCount(length int64) int {
+	jobs := r.jobs
+	if jobs <= 0 {
+		jobs = 1
+	}
+	if r.minChunkSize > 0 && int64(jobs) > length/r.minChunkSize {
+		jobs = int(length / r.minChunkSize)
+		if jobs < 1 {
+			jobs = 1
+		}
+	}
+	if jobs < r.minJobs {
+		jobs = r.minJobs
+	}
+	// every job needs at least a byte to fetch
+	if int64(jobs) > length {
+		jobs = int(length)
+		if jobs < 1 {
+			jobs = 1
+		}
+	}
+	return jobs
+}
+
+// Download fetches the resource like FetchFile, returning the file along with details of the download.
+// The caller is responsible for closing the returned file. No result is returned if the download fails.
+func (r *Request) Download(ctx context.Context, url, filename string) (*FetchResult, error) {
+	start := time.Now()
+	file, err := r.FetchFile(ctx, url, filename)
+	if err != nil {
+		return nil, err
+	}
+
+	r.mu.Lock()
+	size := r.length
+	r.mu.Unlock()
+	result := &FetchResult{
+		File:    file,
+		Stat:    r.Stats(),
+		Size:    size,
+		URL:     r.resolvedURL,
+		Header:  r.header,
+		Elapsed: time.Since(start),
+		Jobs:    r.workers,
+		Retries: int(atomic.LoadInt32(&r.retries)),
+		Conns:   r.ConnStats(),
+	}
+	if r.header != nil {
+		result.ContentType = r.header.Get("Content-Type")
+		result.ETag = r.header.Get("ETag")
+	}
+
+	return result, nil
+}
+
+// discover learns the length of the resource and whether it supports ranges from a HEAD request,
+// falling back to a ranged GET if the HEAD request is refused or its response is lacking. Any other
+// error status of the HEAD request is returned straight away as an *HTTPStatusError wrapping its
+// *StatusError. If the GET is answered with the whole resource, the response is returned for job 0 to
+// read. If the resource is unchanged according to cond, ErrNotModified is returned. The URL any
+// redirects lead to is recorded, so that the rest of the download is made from where the resource was
+// probed.
+func (r *Request) discover(ctx context.Context, cond condition) (*http.Response, int64, bool, error) {
+	var first *http.Response
+	var length int64
+	rangesSupported := true
+
+	req, err := r.newHTTPRequest(ctx, "HEAD", r.url)
+	if err != nil {
+		return nil, 0, false, err
+	}
+	cond.set(req.Header)
+	res, err := r.do(req)
+	if err != nil {
+		return nil, 0, false, fmt.Errorf("error fetching HEAD: %w\n", err)
+	}
+	res.Body.Close()
+	if res.StatusCode == http.StatusNotModified {
+		return nil, 0, false, ErrNotModified
+	}
+	if res.StatusCode/100 != 2 && res.StatusCode != http.StatusMethodNotAllowed && res.StatusCode != http.StatusNotImplemented {
+		// a resource the HEAD finds missing or forbidden won't be fetched by a GET either
+		statusErr := newStatusError(res.Request.URL.String(), res)
+		return nil, 0, false, fmt.Errorf("error fetching HEAD: %w", &HTTPStatusError{Code: res.StatusCode, Status: res.Status, Err: statusErr})
+	}
+	// some servers refuse HEAD, in which case everything is learnt from a ranged GET
+	headOK := res.StatusCode == http.StatusOK
+	var cl, acceptRanges string
+	if headOK {
+		r.resolvedURL = res.Request.URL.String()
+		r.header = res.Header
+
+		cl = res.Header.Get("Content-Length")
+		if cl != "" {
+			length, err = strconv.ParseInt(cl, 10, 64)
+			if err != nil {
+				return nil, 0, false, err
+			}
+		}
+
+		acceptRanges = res.Header.Get("Accept-Ranges")
+		if acceptRanges == "none" {
+			rangesSupported = false
+		}
+		if gzipEncoded(res.Header) {
+			// the Content-Length and any ranges are of the encoded bytes
+			r.gzipped = true
+			return nil, -1, false, nil
+		}
+	}
+	// some servers only send Content-Length on GET, and a server that doesn't advertise
+	// ranges may ignore them, which is only found out from the status of a ranged GET
+	if !headOK || cl == "" || (acceptRanges != "bytes" && acceptRanges != "none") {
+		if headOK {
+			r.log(slog.LevelInfo, "HEAD response has no Content-Length or Accept-Ranges, probing with GET")
+		} else {
+			r.log(slog.LevelInfo, fmt.Sprintf("HEAD returned %s, probing with GET", res.Status))
+		}
+		first, length, err = r.probeGet(ctx, "bytes=0-0", cond)
+		if err != nil {
+			return nil, 0, false, err
+		}
+		// the GET may be redirected further than the HEAD, and jobs follow the GET
+		r.resolvedURL = first.Request.URL.String()
+		if !headOK {
+			r.header = first.Header
+		}
+		if gzipEncoded(first.Header) {
+			first.Body.Close()
+			r.gzipped = true
+			return nil, -1, false, nil
+		}
+		if first.StatusCode == http.StatusPartialContent {
+			first.Body.Close()
+			first = nil
+		}
+	}
+	return first, length, rangesSupported, nil
+}
+
+// probeGet issues a GET for byteRange of the resource, learning the total length from the
+// Content-Range header. This lets a download start eagerly without waiting on a separate HEAD request,
+// and finds the length when a HEAD response doesn't include it.
+// If the server doesn't support ranges the response is the whole resource.
+// If the resource is unchanged according to cond, ErrNotModified is returned.
+// The length is -1 if the whole resource is returned without a Content-Length.
+func (r *Request) probeGet(ctx context.Context, byteRange string, cond condition) (*http.Response, int64, error) {
+	// a URL already resolved by the HEAD request is probed directly
+	url := r.url
+	if r.resolvedURL != "" {
+		url = r.resolvedURL
+	}
+	req, err := r.newHTTPRequest(ctx, "GET", url)
+	if err != nil {
+		return nil, 0, err
+	}
+	req.Header.Set("Range", byteRange)
+	cond.set(req.Header)
+
+	res, err := r.do(req)
+	if err != nil {
+		return nil, 0, err
+	}
+	if res.StatusCode == http.StatusNotModified {
+		res.Body.Close()
+		return nil, 0, ErrNotModified
+	}
+
+	var length int64
+	switch res.StatusCode {
+	case http.StatusPartialContent:
+		// -1 if the length is unknown
+		_, _, length, err = parseContentRange(res.Header.Get("Content-Range"))
+	case http.StatusOK:
+		// -1 if the length is unknown
+		length = res.ContentLength
+	case http.StatusRequestedRangeNotSatisfiable:
+		// no range of an empty resource can be satisfied
+		if res.Header.Get("Content-Range") != "bytes */0" {
+			err = &StatusError{URL: url, StatusCode: res.StatusCode, Status: res.Status}
+		}
+	default:
+		err = &StatusError{URL: url, StatusCode: res.StatusCode, Status: res.Status}
+	}
+	if err != nil {
+		res.Body.Close()
+		return nil, 0, err
+	}
+
+	return res, length, nil
+}
+
+// ifRangeValidator returns the value of an If-Range header that only matches the resource described
+// by header, or "" if it has no suitable validator. Weak ETags can't be used with If-Range, and nor can
+// a Last-Modified date less than a second before the response's Date, as the resource may have changed
+// again within the second.
+func ifRangeValidator(header http.Header) string {
+	if etag := header.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+		return etag
+	}
+	lm := header.Get("Last-Modified")
+	modified, err := http.ParseTime(lm)
+	if err != nil {
+		return ""
+	}
+	date, err := http.ParseTime(header.Get("Date"))
+	if err != nil || date.Sub(modified) < time.Second {
+		return ""
+	}
+	return lm
+}
+
+// parseContentRange returns the first and last byte positions and the complete length from a
+// Content-Range header e.g. 'bytes 0-99/1234'. The length is -1 if it's unknown e.g. 'bytes 0-99/*'
+func parseContentRange(contentRange string) (first, last, length int64, err error) {
+	invalid := fmt.Errorf("invalid Content-Range header '%s'", contentRange)
+	rng, size, ok := strings.Cut(strings.TrimPrefix(contentRange, "bytes "), "/")
+	if !ok || !strings.HasPrefix(contentRange, "bytes ") {
+		return 0, 0, 0, invalid
+	}
+	firstStr, lastStr, ok := strings.Cut(rng, "-")
+	if !ok {
+		return 0, 0, 0, invalid
+	}
+	if first, err = strconv.ParseInt(firstStr, 10, 64); err != nil {
+		return 0, 0, 0, invalid
+	}
+	if last, err = strconv.ParseInt(lastStr, 10, 64); err != nil || last < first {
+		return 0, 0, 0, invalid
+	}
+	length = -1
+	if size != "*" {
+		if length, err = strconv.ParseInt(size, 10, 64); err != nil {
+			return 0, 0, 0, invalid
+		}
+	}
+	return first, last, length, nil
+}
+
+// checkContentRange returns an error if the Content-Range of a response doesn't match the range
+// requested, bytes min to max-1, or to the end if max is negative.
+func (r *Request) checkContentRange(contentRange string, min, max int64) error {
+	first, last, length, err := parseContentRange(contentRange)
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrContentRangeMismatch, err)
+	}
+	// a response ending early is caught when it is read, and retried
+	if first != min || (max >= 0 && last > max-1) {
+		return fmt.Errorf("%w: requested bytes %d-%d, got %s", ErrContentRangeMismatch, min, max-1, contentRange)
+	}
+	r.mu.Lock()
+	expected := r.length
+	r.mu.Unlock()
+	if length >= 0 && expected > 0 && length != expected {
+		return fmt.Errorf("%w: expected length %d, got %s", ErrContentRangeMismatch, expected, contentRange)
+	}
+	return nil
+}
+
+// getBuffer returns a read buffer from the pool, or a new one if the pool is empty. Buffers of a size
+// set before the last call to SetBufferSize are dropped.
+func (r *Request) getBuffer() *[]byte {
+	if bufp, ok := r.bufPool.Get().(*[]byte); ok && len(*bufp) == r.bufferSize {
+		return bufp
+	}
+	buf := make([]byte, r.bufferSize)
+	return &buf
+}
+
+// newHTTPRequest returns a request for url with the configured headers set.
+func (r *Request) newHTTPRequest(ctx context.Context, method, url string) (*http.Request, error) {
+	req, err := http.NewRequest(method, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(httptrace.WithClientTrace(ctx, r.connTrace()))
+	for key, values := range r.requestHeader {
+		// ranges are managed by braid
+		if key == "Range" {
+			continue
+		}
+		req.Header[key] = append([]string(nil), values...)
+	}
+	if r.userAgent != "" {
+		req.Header.Set("User-Agent", r.userAgent)
+	}
+	if r.authorization != "" {
+		req.Header.Set("Authorization", r.authorization)
+	}
+	return req, nil
+}
+
+// do sends req, first passing it to the request modifier if one is set. If a token provider is set, the
+// request carries its token, and a request rejected with 401 is retried once with a fresh token.
+func (r *Request) do(req *http.Request) (*http.Response, error) {
+	var token string
+	if r.tokenProvider != nil {
+		var err error
+		if token, err = r.bearerToken(req.Context(), ""); err != nil {
+			return nil, err
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	r.modifyRequest(req)
+	resp, err := r.client.Do(req)
+	if err != nil || resp.StatusCode != http.StatusUnauthorized || r.tokenProvider == nil {
+		return resp, err
+	}
+
+	resp.Body.Close()
+	r.log(slog.LevelInfo, "token rejected, retrying with a fresh token")
+	if token, err = r.bearerToken(req.Context(), token); err != nil {
+		return nil, err
+	}
+	req = req.Clone(req.Context())
+	req.Header.Set("Authorization", "Bearer "+token)
+	r.modifyRequest(req)
+	return r.client.Do(req)
+}
+
+// modifyRequest adds the headers of any trace to req, then passes it to the request modifier if one is set.
+func (r *Request) modifyRequest(req *http.Request) {
+	if r.tracer != nil {
+		r.tracer.Inject(req.Context(), req.Header)
+	}
+	if r.requestModifier == nil {
+		return
+	}
+	// ranges are managed by braid
+	byteRange := req.Header.Get("Range")
+	r.requestModifier(req)
+	req.Header.Del("Range")
+	if byteRange != "" {
+		req.Header.Set("Range", byteRange)
+	}
+}
+
+// bearerToken returns the token from the token provider, which is cached until it's rejected. If stale is
+// the cached token, a fresh one is fetched; jobs that find their token rejected at once share one refresh.
+func (r *Request) bearerToken(ctx context.Context, stale string) (string, error) {
+	r.tokenMu.Lock()
+	defer r.tokenMu.Unlock()
+	if r.token != "" && r.token != stale {
+		return r.token, nil
+	}
+	token, err := r.tokenProvider(ctx)
+	if err != nil {
+		return "", fmt.Errorf("error fetching token: %w", err)
+	}
+	r.token = token
+	return token, nil
+}
+
+// getRange requests bytes min to max-1 of the resource from one of the mirrors, or to the end if max is negative.
+// If the mirror refuses the request, it is quarantined and the request is sent to another. The mirror
+// last used is returned, for the request to be reported to the mirror set once it's complete.
+func (r *Request) getRange(ctx context.Context, min, max int64, jobID int) (*http.Response, string, error) {
+	range_header := "bytes=" + strconv.FormatInt(min, 10) + "-" + strconv.FormatInt(max-1, 10)
+	if max < 0 {
+		range_header = "bytes=" + strconv.FormatInt(min, 10) + "-"
+	}
+
+	for attempt := 0; ; attempt++ {
+		url, err := r.mirrorSet.get(ctx, jobID)
+		if err != nil {
+			return nil, "", err
+		}
+		req, err := r.newHTTPRequest(ctx, "GET", url)
+		if err != nil {
+			return nil, url, err
+		}
+		if r.gzipped {
+			// an encoded resource is fetched whole, as its ranges can't be decoded apart
+			r.setAcceptEncoding(req.Header)
+		} else {
+			req.Header.Add("Range", range_header)
+		}
+		if r.ifMatch != "" {
+			req.Header.Set("If-Match", r.ifMatch)
+		}
+		// mirrors may not share the validators of the primary URL
+		ifRange := r.ifRange != "" && url == r.resolvedURL
+		if ifRange {
+			req.Header.Set("If-Range", r.ifRange)
+		}
+
+		resp, err := r.do(req)
+		if err != nil {
+			return nil, url, err
+		}
+		if resp.StatusCode == http.StatusPreconditionFailed {
+			resp.Body.Close()
+			return nil, url, fmt.Errorf("%w: %s returned %s for range %s", ErrResourceChanged, url, resp.Status, range_header)
+		}
+		if ifRange && resp.StatusCode == http.StatusOK {
+			// If-Range is answered with the full resource when the validator no longer matches
+			resp.Body.Close()
+			return nil, url, fmt.Errorf("%w: %s returned %s for range %s with If-Range %s", ErrResourceChanged, url, resp.Status, range_header, r.ifRange)
+		}
+		if resp.StatusCode != http.StatusForbidden && resp.StatusCode != http.StatusTooManyRequests {
+			return resp, url, nil
+		}
+		resp.Body.Close()
+
+		if len(r.mirrorSet.urls) == 1 || attempt >= maxMirrorAttempts*len(r.mirrorSet.urls) {
+			return nil, url, fmt.Errorf("error fetching range %s: %w", range_header, newStatusError(url, resp))
+		}
+		r.log(slog.LevelWarn, fmt.Sprintf("job %d: %s returned %s, quarantining for %s", jobID, url, resp.Status, r.mirrorSet.cooldown),
+			append(r.jobAttrs(jobID), slog.String("mirror", url))...)
+		r.mirrorSet.block(url)
+		r.mirrorSet.done(jobID, url, 0, 0, true)
+		if err = r.takeRetry(); err != nil {
+			return nil, "", err
+		}
+	}
+}
+
+// queuedChunk is a chunk waiting to be fetched by the job with jobID. See fetchFile.
+type queuedChunk struct {
+	jobID    int
+	min, max int64
+	resp     *http.Response
+}
+
+// worker fetches chunks from queue until it is empty or the download is cancelled.
+func (r *Request) worker(ctx context.Context, queue <-chan queuedChunk, errChan chan error) {
+	defer r.wg.Done()
+	for q := range queue {
+		if ctx.Err() != nil {
+			// the job that cancelled the download has reported why
+			if q.resp != nil {
+				q.resp.Body.Close()
+			}
+			continue
+		}
+		r.wg.Add(1)
+		r.fetchFile(ctx, q.min, q.max, q.jobID, errChan, q.resp)
+	}
+}
+
+// fetchFile fetches bytes min to max-1 of the resource, or until the response ends if max is negative.
+// If resp is not nil, it is an already open response starting at min from which the bytes are read
+// instead of making a new request. Transient failures are retried from where they left off, see SetMaxRetries.
+func (r *Request) fetchFile(ctx context.Context, min, max int64, jobID int, errChan chan error, resp *http.Response) {
+	defer r.wg.Done()
+
+	ctx, span := r.startSpan(ctx, "braid.chunk")
+	var failed error
+	if span != nil {
+		span.SetAttribute("braid.job", jobID)
+		span.SetAttribute("braid.start", min)
+		span.SetAttribute("braid.end", max)
+		stat, _ := r.jobStat(jobID)
+		before := stat.read.Load()
+		defer func() {
+			span.SetAttribute("braid.bytes", stat.read.Load()-before)
+			endSpan(span, failed)
+		}()
+	}
+
+	// the requests of a job that may be hedged stop once either completes the range
+	var h *hedge
+	if r.hedging && max >= 0 {
+		h = r.addHedge(ctx, jobID, min, max)
+		defer h.finish()
+		ctx = h.ctx
+	}
+
+	r.emit(JobStarted{ID: jobID, Range: [2]int64{min, max}})
+	attempt := 0
+	for {
+		read, retry, err := r.fetchRange(ctx, min, max, jobID, resp, h)
+		if err == nil || h.complete() {
+			r.log(slog.LevelDebug, fmt.Sprintf("job %d: finished", jobID), r.jobAttrs(jobID)...)
+			r.emit(JobDone{ID: jobID})
+			return
+		}
+		min += read
+		if h != nil {
+			min = h.offset()
+		}
+		resp = nil
+
+		// bytes already read can't be requested again without a range
+		retry = retry && ctx.Err() == nil && (max >= 0 || min == 0)
+		var limit int
+		var lowered bool
+		if retry && r.concurrency != nil && !errors.Is(err, ErrTooSlow) {
+			// a struggling server is given fewer connections before the job counts as failing
+			limit, lowered = r.concurrency.reduce()
+		}
+		if limit > 0 {
+			if budgetErr := r.takeRetry(); budgetErr != nil {
+				err = budgetErr
+			} else {
+				if r.metrics != nil {
+					r.metrics.IncRetry(jobID)
+				}
+				action := fmt.Sprintf("retrying at concurrency %d", limit)
+				if lowered {
+					action = fmt.Sprintf("reducing concurrency to %d", limit)
+				}
+				r.log(slog.LevelWarn, fmt.Sprintf("job %d: %s, %s", jobID, strings.TrimSpace(err.Error()), action),
+					r.jobAttrs(jobID)...)
+				continue
+			}
+		} else if retry && errors.Is(err, ErrTooSlow) {
+			// a slow connection is replaced straight away, without counting as a failed attempt
+			if budgetErr := r.takeRetry(); budgetErr != nil {
+				err = budgetErr
+			} else {
+				if r.metrics != nil {
+					r.metrics.IncRetry(jobID)
+				}
+				r.log(slog.LevelWarn, strings.TrimSpace(err.Error())+", reissuing", r.jobAttrs(jobID)...)
+				continue
+			}
+		} else if retry && attempt < r.maxRetries {
+			if budgetErr := r.takeRetry(); budgetErr != nil {
+				err = budgetErr
+			} else {
+				if r.metrics != nil {
+					r.metrics.IncRetry(jobID)
+				}
+				delay := r.backoff(attempt)
+				attempt++
+				var statusErr *StatusError
+				if errors.As(err, &statusErr) && statusErr.RetryAfter > 0 {
+					// the server knows best when to come back
+					delay = statusErr.RetryAfter
+					if delay > maxRetryAfter {
+						delay = maxRetryAfter
+					}
+				}
+				r.log(slog.LevelWarn, fmt.Sprintf("job %d: %s, retrying in %s", jobID, strings.TrimSpace(err.Error()), delay),
+					r.jobAttrs(jobID)...)
+				if err = sleepContext(ctx, delay); err == nil {
+					continue
+				}
+			}
+		}
+
+		failed = &RangeError{Job: jobID, Start: min, End: max, Err: err}
+		errChan <- failed
+		r.emit(JobError{ID: jobID, Err: failed})
+		// a stream can't be read past the failed range
+		_, stream := r.w.(*streamBuffer)
+		if stream || errors.Is(err, ErrResourceChanged) || errors.Is(err, ErrRetryBudgetExhausted) ||
+			errors.Is(err, ErrRangesNotSupported) || errors.Is(err, ErrSegmentMismatch) ||
+			errors.Is(err, ErrRangeNotSatisfiable) {
+			// no point fetching the rest of a download that can't succeed
+			r.cancel()
+		}
+		return
+	}
+}
+
+// fetchRange makes a single attempt at fetching bytes min to max-1 of the resource, returning the
+// number of bytes read and, on failure, whether the failure is transient so worth retrying. If h is
+// not nil the job may be hedged, and bytes already written by its other request are skipped.
+func (r *Request) fetchRange(ctx context.Context, min, max int64, jobID int, resp *http.Response, h *hedge) (read int64, retry bool, err error) {
+	if r.concurrency != nil {
+		if err := r.concurrency.acquire(ctx); err != nil {
+			if resp != nil {
+				resp.Body.Close()
+			}
+			return 0, false, err
+		}
+		defer r.concurrency.release()
+	}
+
+	// abort drops the connection of this attempt, should it be too slow
+	ctx, abort := context.WithCancel(ctx)
+	defer abort()
+
+	if resp != nil {
+		// an eagerly started response wasn't made with the attempt's context
+		body := resp.Body
+		abort = func() { body.Close() }
+	}
+
+	var timedOut atomic.Bool
+	if r.jobTimeout > 0 {
+		timer := time.AfterFunc(r.jobTimeout, func() {
+			timedOut.Store(true)
+			abort()
+		})
+		defer timer.Stop()
+	}
+
+	if resp == nil {
+		sent := time.Now()
+		var mirror string
+		resp, mirror, err = r.getRange(ctx, min, max, jobID)
+		if mirror != "" {
+			// the mirror's speed is measured over the whole attempt
+			defer func() {
+				r.mirrorSet.done(jobID, mirror, read, time.Since(sent), err != nil)
+			}()
+		}
+		if err != nil {
+			if timedOut.Load() {
+				return 0, true, fmt.Errorf("job %d: %w after %s", jobID, ErrJobTimeout, r.jobTimeout)
+			}
+			retry := !errors.Is(err, ErrResourceChanged) && !errors.Is(err, ErrRetryBudgetExhausted)
+			var statusErr *StatusError
+			if errors.As(err, &statusErr) {
+				retry = retryableStatus(statusErr.StatusCode)
+			}
+			return 0, retry, err
+		}
+		if span := spanFromContext(ctx); span != nil {
+			span.SetAttribute("http.status_code", resp.StatusCode)
+		}
+		// a full response can only be used for a range starting at the beginning
+		if resp.StatusCode == http.StatusOK && (r.requireRanges || min > 0) {
+			resp.Body.Close()
+			return 0, false, ErrRangesNotSupported
+		}
+		if resp.StatusCode == http.StatusRequestedRangeNotSatisfiable {
+			resp.Body.Close()
+			return 0, false, fmt.Errorf("job %d: %w: bytes %d-%d from %s", jobID, ErrRangeNotSatisfiable, min, max-1, resp.Request.URL)
+		}
+		// the parts of a multipart/byteranges response are checked as they are read
+		if resp.StatusCode == http.StatusPartialContent && byteRangesBoundary(resp) == "" {
+			if err = r.checkContentRange(resp.Header.Get("Content-Range"), min, max); err != nil {
+				resp.Body.Close()
+				return 0, false, fmt.Errorf("job %d: %w", jobID, err)
+			}
+		}
+		if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusPartialContent {
+			resp.Body.Close()
+			err = newStatusError(resp.Request.URL.String(), resp)
+			return 0, retryableStatus(resp.StatusCode), fmt.Errorf("job %d: %w", jobID, err)
+		}
+		if r.metrics != nil {
+			r.metrics.ObserveTTFB(jobID, time.Since(sent))
+		}
+	}
+	defer resp.Body.Close()
+
+	stat, start := r.jobStat(jobID)
+
+	var watch *speedWatch
+	if r.minSpeed > 0 && r.minSpeedWindow > 0 {
+		watch = r.watchSpeed(stat, abort)
+		defer watch.stop()
+	}
+
+	// an eagerly started response runs to the end of the resource
+	var body io.Reader = resp.Body
+	if boundary := byteRangesBoundary(resp); boundary != "" && resp.StatusCode == http.StatusPartialContent {
+		body = r.newByteRangesReader(resp.Body, boundary, min, max)
+	}
+	if r.gzipped && gzipEncoded(resp.Header) {
+		zr, err := gzip.NewReader(body)
+		if err != nil {
+			return 0, true, fmt.Errorf("job %d: error decoding response: %w", jobID, err)
+		}
+		defer zr.Close()
+		body = zr
+	}
+	if max >= 0 {
+		body = io.LimitReader(body, max-min)
+	}
+	bufp := r.getBuffer()
+	defer r.bufPool.Put(bufp)
+	buf := *bufp
+
+	for {
+		if r.faultFunc != nil {
+			if err := r.faultFunc(jobID, min+read); err != nil {
+				return read, true, err
+			}
+		}
+		p := buf
+		if r.limiter != nil {
+			if int64(len(p)) > r.limiter.burst {
+				p = buf[:r.limiter.burst]
+			}
+			if err := r.limiter.wait(ctx, int64(len(p))); err != nil {
+				return read, true, fmt.Errorf("job %d: %w", jobID, err)
+			}
+		}
+		// bytes read along with an error are written before the error is reported
+		n, readErr := body.Read(p)
+		if r.limiter != nil {
+			r.limiter.refund(int64(len(p) - n))
+		}
+		if n > 0 {
+			off, p := min+read, buf[:n]
+			if h != nil {
+				// the bytes of a hedged job already written by its other request are skipped
+				h.mu.Lock()
+				if skip := h.written - off; skip > 0 {
+					if skip > int64(n) {
+						skip = int64(n)
+					}
+					off += skip
+					p = p[skip:]
+				}
+			}
+			var count int
+			var err error
+			if len(p) > 0 {
+				count, err = r.w.WriteAt(p, off)
+			}
+			end := off + int64(count)
+			if h != nil {
+				if end > h.written {
+					h.written = end
+				}
+				end = h.written
+				h.mu.Unlock()
+			}
+			// stats cover the job's whole range, including anything fetched by a previous session
+			stat.read.Store(end - start)
+			if max < 0 {
+				stat.total.Store(end - start)
+			}
+			r.emit(JobProgress{ID: jobID, Bytes: end - start})
+			r.update(false)
+			if err != nil {
+				return read, false, err
+			}
+
+			if r.metrics != nil {
+				r.metrics.IncBytes(jobID, int64(count))
+			}
+
+			if r.digester != nil {
+				r.digester.wrote(off, int64(count))
+			}
+
+			if r.verifier != nil {
+				err = r.verifier.wrote(off, int64(count))
+				if err != nil {
+					r.log(slog.LevelError, err.Error(), r.jobAttrs(jobID)...)
+					return read, false, err
+				}
+			}
+			read = off + int64(count) - min
+
+			if count != len(p) {
+				err = fmt.Errorf("%w: expected %d bytes, got %d bytes", ErrShortWrite, len(p), count)
+				r.log(slog.LevelError, err.Error(), r.jobAttrs(jobID)...)
+				return read, false, err
+			}
+			// the length of a resource that runs until the response ends is only known as it's read
+			if max < 0 && r.maxSize > 0 && min+read > r.maxSize {
+				err = fmt.Errorf("%w: read more than the maximum of %d bytes", ErrTooLarge, r.maxSize)
+				r.log(slog.LevelError, err.Error(), r.jobAttrs(jobID)...)
+				return read, false, err
+			}
+		}
+		if readErr == io.EOF {
+			break
+		}
+		if readErr != nil {
+			if watch != nil && watch.stop() {
+				return read, true, fmt.Errorf("job %d: %w: less than %d bytes/s over %s", jobID, ErrTooSlow, r.minSpeed, r.minSpeedWindow)
+			}
+			if timedOut.Load() {
+				return read, true, fmt.Errorf("job %d: %w after %s", jobID, ErrJobTimeout, r.jobTimeout)
+			}
+			return read, true, fmt.Errorf("job %d: error reading response: %w", jobID, readErr)
+		}
+	}
+
+	if max >= 0 && read != max-min {
+		return read, true, fmt.Errorf("job %d: %w after %d of %d bytes", jobID, ErrShortResponse, read, max-min)
+	}
+	return read, false, nil
 }
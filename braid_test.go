@@ -15,13 +15,25 @@ limitations under the License.
 package braid
 
 import (
+	"bytes"
 	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
+	"math/rand"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -32,6 +44,8 @@ func TestFetchFile(t *testing.T) {
 	var filename string = "data.bin"
 	var userAgent string = "braid test"
 
+	// the resource must look unchanged between requests
+	modtime := time.Now()
 	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 
 		if r.UserAgent() != userAgent {
@@ -40,7 +54,7 @@ func TestFetchFile(t *testing.T) {
 			return
 		}
 		b := &data{size: fileSize} // 5MiB data
-		http.ServeContent(w, r, filename, time.Now(), b)
+		http.ServeContent(w, r, filename, modtime, b)
 	}))
 	defer ts.Close()
 
@@ -53,12 +67,15 @@ func TestFetchFile(t *testing.T) {
 	br.SetJobs(jobs)
 	br.SetUserAgent(userAgent)
 
+	var logMu sync.Mutex
 	logOut := ""
 	logger := func(a string, b ...interface{}) {
-		logOut += fmt.Sprintf(a, b)
+		logMu.Lock()
+		defer logMu.Unlock()
+		logOut += fmt.Sprintf(a, b...)
 	}
 
-	SetLogger(logger)
+	br.SetLogger(logger)
 	ctx := context.Background()
 	file, err = br.FetchFile(ctx, ts.URL, filename)
 	if err != nil {
@@ -99,9 +116,10 @@ func TestFetchFile(t *testing.T) {
 func TestFetchFileFail(t *testing.T) {
 	var fileSize int64 = 5 << 20 // 5 MiB
 	var filename string = "data.bin"
+	modtime := time.Now()
 	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		b := &data{fail: true, size: fileSize} // 5MiB data
-		http.ServeContent(w, r, filename, time.Now(), b)
+		http.ServeContent(w, r, filename, modtime, b)
 	}))
 	defer ts.Close()
 
@@ -112,23 +130,2077 @@ func TestFetchFileFail(t *testing.T) {
 		t.Fatal(err)
 	}
 
+	var logMu sync.Mutex
 	logOut := ""
 	logger := func(a string, b ...interface{}) {
-		logOut += fmt.Sprintf(a, b)
+		logMu.Lock()
+		defer logMu.Unlock()
+		logOut += fmt.Sprintf(a, b...)
 	}
 
-	SetLogger(logger)
+	br.SetLogger(logger)
 	ctx := context.Background()
 	file, err = br.FetchFile(ctx, ts.URL, filename)
+	if !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Fatalf("Expecting io.ErrUnexpectedEOF from FetchFile but got %v", err)
+	}
+	if file != nil {
+		t.Fatalf("expected no file to be returned")
+	}
+	// the failed download mustn't be left at filename, or as a partial download that can't be resumed
+	if _, err = os.Stat(filename); !os.IsNotExist(err) {
+		t.Fatalf("expected %s not to exist", filename)
+	}
+	if _, err = os.Stat(partFilename(filename)); !os.IsNotExist(err) {
+		t.Fatalf("expected partial download to be removed")
+	}
+}
+
+// openFiles returns the paths of the files the process has open, skipping the test if they can't be listed
+func openFiles(t *testing.T) []string {
+	fds, err := os.ReadDir("/proc/self/fd")
+	if err != nil {
+		t.Skip("open files can't be listed on this platform")
+	}
+	var paths []string
+	for _, fd := range fds {
+		if path, err := os.Readlink(filepath.Join("/proc/self/fd", fd.Name())); err == nil {
+			paths = append(paths, path)
+		}
+	}
+	return paths
+}
+
+func TestFetchFileErrorCloses(t *testing.T) {
+	var filename string = "closes.bin"
+	content := randomContent(1 << 20)
+	defer os.Remove(filename)
+	defer os.Remove(partFilename(filename))
+	defer removeResumeState(filename)
+
+	tests := []struct {
+		name   string
+		fail   func(r *http.Request) bool
+		resume bool
+		kept   bool
+	}{
+		{"HEAD error", func(r *http.Request) bool { return true }, true, false},
+		{"job error", func(r *http.Request) bool { return r.Header.Get("Range") == "bytes=524288-1048575" }, false, false},
+		{"job error resumable", func(r *http.Request) bool { return r.Header.Get("Range") == "bytes=524288-1048575" }, true, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if tt.fail(r) {
+					http.Error(w, "not found", http.StatusNotFound)
+					return
+				}
+				http.ServeContent(w, r, filename, time.Time{}, bytes.NewReader(content))
+			}))
+			defer ts.Close()
+
+			br, err := NewRequest()
+			if err != nil {
+				t.Fatal(err)
+			}
+			br.SetJobs(2)
+			br.SetResume(tt.resume)
+			file, err := br.FetchFile(context.Background(), ts.URL, filename)
+			if err == nil {
+				t.Fatalf("expected error")
+			}
+			if file != nil {
+				t.Fatalf("expected no file to be returned")
+			}
+			for _, path := range openFiles(t) {
+				if strings.HasSuffix(path, string(filepath.Separator)+partFilename(filename)) {
+					t.Fatalf("expected %s to be closed", path)
+				}
+			}
+			// the partial download is kept only if it can be resumed
+			if _, err = os.Stat(partFilename(filename)); os.IsNotExist(err) == tt.kept {
+				t.Fatalf("expected partial download to be kept: %t", tt.kept)
+			}
+		})
+	}
+}
+
+func TestFileMode(t *testing.T) {
+	var filename string = "mode.bin"
+	content := randomContent(1 << 16)
+	ts := newContentServer(content)
+	defer ts.Close()
+	defer os.Remove(filename)
+
+	for _, mode := range []os.FileMode{DefaultFileMode, 0600, 0640} {
+		os.Remove(filename)
+		br, err := NewRequest()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if mode != DefaultFileMode {
+			br.SetFileMode(mode)
+		}
+		file, err := br.FetchFile(context.Background(), ts.URL, filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		file.Close()
+
+		fi, err := os.Stat(filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fi.Mode().Perm() != mode {
+			t.Fatalf("expected mode %s, got %s", mode, fi.Mode().Perm())
+		}
+	}
+}
+
+func TestCreateDirs(t *testing.T) {
+	var filename string = "nested/dirs/data.bin"
+	content := randomContent(1 << 16)
+	ts := newContentServer(content)
+	defer ts.Close()
+	defer os.RemoveAll("nested")
+
+	br, err := NewRequest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = br.FetchFile(context.Background(), ts.URL, filename); !os.IsNotExist(err) {
+		t.Fatalf("expected missing directories to be an error, got %v", err)
+	}
+
+	br.SetCreateDirs(true)
+	file, err := br.FetchFile(context.Background(), ts.URL, filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+
+	got, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, content) {
+		t.Fatalf("downloaded content doesn't match")
+	}
+}
+
+func TestSync(t *testing.T) {
+	var filename string = "sync.bin"
+	content := randomContent(1 << 16)
+	ts := newContentServer(content)
+	defer ts.Close()
+	defer os.Remove(filename)
+
+	var synced []string
+	defer func(f func(*os.File) error) { syncFile = f }(syncFile)
+	syncFile = func(f *os.File) error {
+		synced = append(synced, filepath.Base(f.Name()))
+		return f.Sync()
+	}
+
+	br, err := NewRequest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	file, err := br.FetchFile(context.Background(), ts.URL, filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+	if len(synced) != 0 {
+		t.Fatalf("expected no syncs by default, got %v", synced)
+	}
+
+	br.SetSync(true)
+	file, err = br.FetchFile(context.Background(), ts.URL, filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+
+	expected := []string{partFilename(filename)}
+	if runtime.GOOS != "windows" {
+		expected = append(expected, ".")
+	}
+	if !reflect.DeepEqual(synced, expected) {
+		t.Fatalf("expected syncs of %v, got %v", expected, synced)
+	}
+}
+
+func TestPreserveModTime(t *testing.T) {
+	var filename string = "modtime.bin"
+	content := randomContent(1 << 16)
+	modTime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	defer os.Remove(filename)
+
+	for _, lastModified := range []bool{true, false} {
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !lastModified {
+				// a zero time isn't sent
+				http.ServeContent(w, r, filename, time.Time{}, bytes.NewReader(content))
+				return
+			}
+			http.ServeContent(w, r, filename, modTime, bytes.NewReader(content))
+		}))
+
+		br, err := NewRequest()
+		if err != nil {
+			t.Fatal(err)
+		}
+		br.SetPreserveModTime(true)
+		start := time.Now().Add(-time.Minute)
+		file, err := br.FetchFile(context.Background(), ts.URL, filename)
+		ts.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		fi, err := file.Stat()
+		file.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if lastModified && !fi.ModTime().Equal(modTime) {
+			t.Fatalf("expected modification time %s, got %s", modTime, fi.ModTime())
+		}
+		if !lastModified && fi.ModTime().Before(start) {
+			t.Fatalf("expected modification time to be left alone, got %s", fi.ModTime())
+		}
+	}
+}
+
+func TestFetchFileContent(t *testing.T) {
+	var filename string = "content.bin"
+
+	// 4096 is the default bufio buffer size
+	boundary := bytes.Repeat([]byte("a"), 4096)
+	lines := bytes.Repeat([]byte("line\n"), 1000)
+
+	// binary content with newlines scattered through it
+	newlines := randomContent(3*DefaultBufferSize + 17)
+	rnd := rand.New(rand.NewSource(1))
+	for i := 0; i < len(newlines)/50; i++ {
+		newlines[rnd.Intn(len(newlines))] = '\n'
+	}
+
+	tests := []struct {
+		name    string
+		content []byte
+		jobs    int
+	}{
+		{"no trailing newline", []byte("first line\nsecond line"), 1},
+		{"no trailing newline multiple jobs", append(lines, "last"...), 3},
+		{"trailing newline", lines, 3},
+		{"buffer boundary", boundary, 1},
+		{"buffer boundary newline terminated", append(boundary[1:], '\n'), 1},
+		{"double buffer boundary", append(boundary, boundary...), 2},
+		{"buffer boundary no newlines", bytes.Repeat([]byte{0xff}, 4096*3), 3},
+		{"binary with newlines", newlines, 1},
+		{"binary with newlines multiple jobs", newlines, 4},
+		{"read buffer boundary", newlines[:2*DefaultBufferSize], 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := newContentServer(tt.content)
+			defer ts.Close()
+			defer os.Remove(filename)
+
+			br, err := NewRequest()
+			if err != nil {
+				t.Fatal(err)
+			}
+			br.SetJobs(tt.jobs)
+			file, err := br.FetchFile(context.Background(), ts.URL, filename)
+			if err != nil {
+				t.Fatal(err)
+			}
+			file.Close()
+
+			got, err := os.ReadFile(filename)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, tt.content) {
+				t.Fatalf("downloaded content doesn't match: expected %d bytes, got %d bytes", len(tt.content), len(got))
+			}
+		})
+	}
+}
+
+func TestEagerStart(t *testing.T) {
+	var filename string = "eager.bin"
+	content := randomContent(1 << 20)
+
+	var heads int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "HEAD" {
+			atomic.AddInt32(&heads, 1)
+		}
+		http.ServeContent(w, r, filename, time.Time{}, bytes.NewReader(content))
+	}))
+	defer ts.Close()
+
+	// server ignoring Range
+	tsNoRange := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", strconv.Itoa(len(content)))
+		w.Write(content)
+	}))
+	defer tsNoRange.Close()
+
+	for _, url := range []string{ts.URL, tsNoRange.URL} {
+		br, err := NewRequest()
+		if err != nil {
+			t.Fatal(err)
+		}
+		br.SetJobs(4)
+		br.SetEagerStart(true)
+		file, err := br.FetchFile(context.Background(), url, filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		file.Close()
+
+		got, err := os.ReadFile(filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		os.Remove(filename)
+		if !bytes.Equal(got, content) {
+			t.Fatalf("downloaded content doesn't match: expected %d bytes, got %d bytes", len(content), len(got))
+		}
+		if br.Stats().ReadBytes != int64(len(content)) {
+			t.Fatalf("stats ReadBytes doesn't match: expected %d, got %d", len(content), br.Stats().ReadBytes)
+		}
+	}
+
+	if heads != 0 {
+		t.Fatalf("expected no HEAD requests with eager start, got %d", heads)
+	}
+}
+
+func TestDownload(t *testing.T) {
+	var filename string = "download.bin"
+	var etag string = `"abc123"`
+	content := randomContent(100 << 10)
+
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", etag)
+		w.Header().Set("Content-Type", "application/x-test")
+		http.ServeContent(w, r, filename, time.Time{}, bytes.NewReader(content))
+	}))
+	defer backend.Close()
+	ts := httptest.NewServer(http.RedirectHandler(backend.URL+"/file", http.StatusFound))
+	defer ts.Close()
+	defer os.Remove(filename)
+
+	br, err := NewRequest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := br.Download(context.Background(), ts.URL, filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result.File.Close()
+
+	if result.URL != backend.URL+"/file" {
+		t.Fatalf("expected resolved URL %s, got %s", backend.URL+"/file", result.URL)
+	}
+	if result.ETag != etag {
+		t.Fatalf("expected ETag %s, got %s", etag, result.ETag)
+	}
+	if ct := result.Header.Get("Content-Type"); ct != "application/x-test" {
+		t.Fatalf("expected Content-Type header application/x-test, got %s", ct)
+	}
+	if result.Stat.ReadBytes != int64(len(content)) {
+		t.Fatalf("expected %d bytes read, got %d", len(content), result.Stat.ReadBytes)
+	}
+	if result.Elapsed <= 0 {
+		t.Fatalf("expected positive elapsed time, got %s", result.Elapsed)
+	}
+	if result.Size != int64(len(content)) {
+		t.Fatalf("expected size %d, got %d", len(content), result.Size)
+	}
+	if result.ContentType != "application/x-test" {
+		t.Fatalf("expected content type application/x-test, got %s", result.ContentType)
+	}
+	if result.Jobs != DefaultJobs {
+		t.Fatalf("expected %d jobs, got %d", DefaultJobs, result.Jobs)
+	}
+	if result.Retries != 0 {
+		t.Fatalf("expected no retries, got %d", result.Retries)
+	}
+}
+
+func TestResponseHeaders(t *testing.T) {
+	var filename string = "headers.bin"
+	content := randomContent(100 << 10)
+	modTime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	defer os.Remove(filename)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/x-test")
+		w.Header().Set("Content-Disposition", `attachment; filename="real.bin"`)
+		http.ServeContent(w, r, filename, modTime, bytes.NewReader(content))
+	}))
+	defer ts.Close()
+
+	br, err := NewRequest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if header := br.ResponseHeaders(); header != nil {
+		t.Fatalf("expected no headers before a download, got %v", header)
+	}
+	file, err := br.FetchFile(context.Background(), ts.URL, filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+
+	header := br.ResponseHeaders()
+	if ct := header.Get("Content-Type"); ct != "application/x-test" {
+		t.Fatalf("expected Content-Type application/x-test, got %s", ct)
+	}
+	if cd := header.Get("Content-Disposition"); cd != `attachment; filename="real.bin"` {
+		t.Fatalf("expected Content-Disposition to be kept, got %s", cd)
+	}
+	lastModified, err := http.ParseTime(header.Get("Last-Modified"))
+	if err != nil || !lastModified.Equal(modTime) {
+		t.Fatalf("expected Last-Modified %s, got %s", modTime, header.Get("Last-Modified"))
+	}
+
+	// the headers returned are a copy
+	header.Set("Content-Type", "text/plain")
+	if ct := br.ResponseHeaders().Get("Content-Type"); ct != "application/x-test" {
+		t.Fatalf("expected changes to the copy not to be kept, got %s", ct)
+	}
+}
+
+func TestReset(t *testing.T) {
+	var filenames = []string{"reset1.bin", "reset2.bin"}
+	content := randomContent(1 << 20)
+
+	var mu sync.Mutex
+	var agents []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		agents = append(agents, r.UserAgent())
+		mu.Unlock()
+		w.Header().Set("Content-Type", "application/x-test")
+		http.ServeContent(w, r, "reset.bin", time.Time{}, bytes.NewReader(content))
+	}))
+	defer ts.Close()
+
+	br, err := NewRequest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	br.SetJobs(4)
+	br.SetUserAgent("braid-test")
+	for _, filename := range filenames {
+		defer os.Remove(filename)
+		file, err := br.FetchFile(context.Background(), ts.URL, filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		file.Close()
+
+		got, err := os.ReadFile(filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, content) {
+			t.Fatalf("%s: downloaded content doesn't match", filename)
+		}
+		if stat := br.Stats(); stat.ReadBytes != int64(len(content)) {
+			t.Fatalf("%s: expected %d bytes read, got %d", filename, len(content), stat.ReadBytes)
+		}
+
+		br.Reset()
+		if stat := br.Stats(); stat != (Stat{}) {
+			t.Fatalf("expected stats to be cleared, got %+v", stat)
+		}
+		if header := br.ResponseHeaders(); header != nil {
+			t.Fatalf("expected response headers to be cleared, got %v", header)
+		}
+	}
+
+	// the configuration is kept
+	mu.Lock()
+	defer mu.Unlock()
+	for _, agent := range agents {
+		if agent != "braid-test" {
+			t.Fatalf("expected every request to have the configured User-Agent, got %q", agent)
+		}
+	}
+	if len(agents) < 2*4 {
+		t.Fatalf("expected both downloads to use 4 jobs, got %d requests", len(agents))
+	}
+}
+
+func TestJobCount(t *testing.T) {
+	tests := []struct {
+		length       int64
+		jobs         int
+		minChunkSize int64
+		minJobs      int
+		expected     int
+	}{
+		{1 << 20, 5, 0, 0, 5},
+		{1 << 20, 0, 0, 0, 1},
+		{100 << 10, 5, 64 << 10, 0, 1},
+		{1 << 20, 5, 256 << 10, 0, 4},
+		{10 << 20, 5, 256 << 10, 0, 5},
+		{100 << 10, 5, 64 << 10, 3, 3},
+		{1 << 20, 2, 0, 4, 4},
+		{3, 5, 0, 0, 3},
+		{0, 5, 0, 0, 1},
+	}
+
+	for _, tt := range tests {
+		br, err := NewRequest()
+		if err != nil {
+			t.Fatal(err)
+		}
+		br.SetJobs(tt.jobs)
+		br.SetMinChunkSize(tt.minChunkSize)
+		br.SetMinJobs(tt.minJobs)
+		if jobs := br.jobCount(tt.length); jobs != tt.expected {
+			t.Errorf("length %d, jobs %d, min chunk size %d, min jobs %d: expected %d jobs, got %d",
+				tt.length, tt.jobs, tt.minChunkSize, tt.minJobs, tt.expected, jobs)
+		}
+	}
+}
+
+func TestTinyResources(t *testing.T) {
+	var filename string = "tiny.bin"
+	defer os.Remove(filename)
+
+	tests := []struct {
+		name    string
+		content []byte
+		eager   bool
+		jobs    int
+	}{
+		{"empty", []byte{}, false, 0},
+		{"empty eager", []byte{}, true, 0},
+		{"3 bytes", []byte("abc"), false, 3},
+		{"3 bytes eager", []byte("abc"), true, 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var mu sync.Mutex
+			var bad []string
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if rng := r.Header.Get("Range"); strings.Contains(rng, "--") {
+					mu.Lock()
+					bad = append(bad, rng)
+					mu.Unlock()
+				}
+				http.ServeContent(w, r, filename, time.Time{}, bytes.NewReader(tt.content))
+			}))
+			defer ts.Close()
+
+			br, err := NewRequest()
+			if err != nil {
+				t.Fatal(err)
+			}
+			br.SetJobs(5)
+			br.SetEagerStart(tt.eager)
+			file, err := br.FetchFile(context.Background(), ts.URL, filename)
+			if err != nil {
+				t.Fatal(err)
+			}
+			file.Close()
+
+			got, err := os.ReadFile(filename)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, tt.content) {
+				t.Fatalf("expected content %q, got %q", tt.content, got)
+			}
+			if len(br.JobStats()) != tt.jobs {
+				t.Fatalf("expected %d jobs, got %d", tt.jobs, len(br.JobStats()))
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			if len(bad) > 0 {
+				t.Fatalf("expected valid Range headers, got %v", bad)
+			}
+		})
+	}
+}
+
+func TestAutoJobs(t *testing.T) {
+	var filename string = "auto.bin"
+	defer os.Remove(filename)
+
+	tests := []struct {
+		size     int
+		expected int
+	}{
+		{4 << 10, 1},
+		{1 << 20, 4},
+		{8 << 20, 8},
+	}
+
+	for _, tt := range tests {
+		content := randomContent(tt.size)
+		ts := newContentServer(content)
+
+		br, err := NewRequest()
+		if err != nil {
+			t.Fatal(err)
+		}
+		br.SetAutoJobs(256<<10, 8)
+		file, err := br.FetchFile(context.Background(), ts.URL, filename)
+		ts.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		file.Close()
+
+		got, err := os.ReadFile(filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, content) {
+			t.Fatalf("size %d: downloaded content doesn't match", tt.size)
+		}
+		if jobs := len(br.JobStats()); jobs != tt.expected {
+			t.Fatalf("size %d: expected %d jobs, got %d", tt.size, tt.expected, jobs)
+		}
+	}
+}
+
+func TestMinJobs(t *testing.T) {
+	var filename string = "minjobs.bin"
+	content := randomContent(100 << 10)
+	ts := newContentServer(content)
+	defer ts.Close()
+	defer os.Remove(filename)
+
+	br, err := NewRequest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	br.SetMinChunkSize(1 << 20)
+	br.SetMinJobs(3)
+	file, err := br.FetchFile(context.Background(), ts.URL, filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+
+	if len(br.JobStats()) != 3 {
+		t.Fatalf("expected 3 jobs, got %d", len(br.JobStats()))
+	}
+	got, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, content) {
+		t.Fatalf("downloaded content doesn't match")
+	}
+}
+
+func TestNoContentLengthOnHead(t *testing.T) {
+	var filename string = "nolength.bin"
+	content := randomContent(1 << 20)
+
+	var probes int32
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "HEAD" {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		if r.Header.Get("Range") == "bytes=0-0" {
+			atomic.AddInt32(&probes, 1)
+		}
+		http.ServeContent(w, r, filename, time.Time{}, bytes.NewReader(content))
+	}
+	ts := httptest.NewServer(http.HandlerFunc(handler))
+	defer ts.Close()
+
+	// server ignoring Range on GET
+	tsNoRange := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "HEAD" {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		w.Header().Set("Content-Length", strconv.Itoa(len(content)))
+		w.Write(content)
+	}))
+	defer tsNoRange.Close()
+
+	for _, url := range []string{ts.URL, tsNoRange.URL} {
+		br, err := NewRequest()
+		if err != nil {
+			t.Fatal(err)
+		}
+		file, err := br.FetchFile(context.Background(), url, filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		file.Close()
+
+		got, err := os.ReadFile(filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		os.Remove(filename)
+		if !bytes.Equal(got, content) {
+			t.Fatalf("downloaded content doesn't match: expected %d bytes, got %d bytes", len(content), len(got))
+		}
+	}
+
+	if probes != 1 {
+		t.Fatalf("expected 1 ranged GET probe, got %d", probes)
+	}
+}
+
+func TestHeadNotAllowed(t *testing.T) {
+	var filename string = "nohead.bin"
+	content := randomContent(1 << 20)
+	defer os.Remove(filename)
+
+	// only ranged GETs are answered
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" || r.Header.Get("Range") == "" {
+			http.Error(w, "", http.StatusMethodNotAllowed)
+			return
+		}
+		http.ServeContent(w, r, filename, time.Time{}, bytes.NewReader(content))
+	}))
+	defer ts.Close()
+
+	// the length isn't in the Content-Range, so is only found by reading to the end
+	tsNoLength := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" {
+			http.Error(w, "", http.StatusMethodNotAllowed)
+			return
+		}
+		if r.Header.Get("Range") == "bytes=0-0" {
+			w.Header().Set("Content-Range", "bytes 0-0/*")
+			w.WriteHeader(http.StatusPartialContent)
+			w.Write(content[:1])
+			return
+		}
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes 0-%d/*", len(content)-1))
+		w.WriteHeader(http.StatusPartialContent)
+		w.Write(content)
+	}))
+	defer tsNoLength.Close()
+
+	tests := []struct {
+		url  string
+		jobs int
+	}{
+		{ts.URL, DefaultJobs},
+		{tsNoLength.URL, 1},
+	}
+
+	for _, tt := range tests {
+		br, err := NewRequest()
+		if err != nil {
+			t.Fatal(err)
+		}
+		file, err := br.FetchFile(context.Background(), tt.url, filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		file.Close()
+
+		got, err := os.ReadFile(filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, content) {
+			t.Fatalf("downloaded content doesn't match: expected %d bytes, got %d bytes", len(content), len(got))
+		}
+		if len(br.JobStats()) != tt.jobs {
+			t.Fatalf("expected %d jobs, got %d", tt.jobs, len(br.JobStats()))
+		}
+	}
+}
+
+func TestHeadStatusError(t *testing.T) {
+	var filename string = "headstatus.bin"
+	defer os.Remove(filename)
+
+	for _, status := range []int{http.StatusNotFound, http.StatusForbidden, http.StatusServiceUnavailable} {
+		var gets int32
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method == "GET" {
+				atomic.AddInt32(&gets, 1)
+			}
+			w.Header().Set("Retry-After", "7")
+			http.Error(w, "", status)
+		}))
+
+		br, err := NewRequest()
+		if err != nil {
+			t.Fatal(err)
+		}
+		file, err := br.FetchFile(context.Background(), ts.URL, filename)
+		ts.Close()
+		var headErr *HTTPStatusError
+		if !errors.As(err, &headErr) || headErr.Code != status {
+			t.Fatalf("expected a %d HTTPStatusError, got %v", status, err)
+		}
+		// the status is found as a *StatusError too, as it would be for a GET
+		var statusErr *StatusError
+		if !errors.As(err, &statusErr) || statusErr.StatusCode != status || statusErr.URL != ts.URL ||
+			statusErr.RetryAfter != 7*time.Second {
+			t.Fatalf("expected a %d StatusError from %s with its Retry-After, got %+v", status, ts.URL, statusErr)
+		}
+		file.Close()
+		if n := atomic.LoadInt32(&gets); n != 0 {
+			t.Fatalf("%d: expected no GET after the HEAD failed, got %d", status, n)
+		}
+	}
+}
+
+func TestChunkedResponse(t *testing.T) {
+	var filename string = "chunked.bin"
+	content := randomContent(1 << 20)
+	defer os.Remove(filename)
+
+	// server streaming the resource with chunked encoding, so without a Content-Length
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Transfer-Encoding", "chunked")
+		w.WriteHeader(http.StatusOK)
+		if r.Method == "HEAD" {
+			return
+		}
+		for b := content; len(b) > 0; b = b[1000:] {
+			if len(b) < 1000 {
+				w.Write(b)
+				break
+			}
+			w.Write(b[:1000])
+			w.(http.Flusher).Flush()
+		}
+	}))
+	defer ts.Close()
+
+	expected := sha256.Sum256(content)
+	for _, eager := range []bool{false, true} {
+		br, err := NewRequest()
+		if err != nil {
+			t.Fatal(err)
+		}
+		br.SetJobs(4)
+		br.SetEagerStart(eager)
+		br.SetDigest(sha256.New)
+		file, err := br.FetchFile(context.Background(), ts.URL, filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		file.Close()
+
+		got, err := os.ReadFile(filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, content) {
+			t.Fatalf("eager %t: downloaded content doesn't match: expected %d bytes, got %d bytes", eager, len(content), len(got))
+		}
+		stat := br.Stats()
+		if stat.TotalBytes != int64(len(content)) || stat.ReadBytes != int64(len(content)) {
+			t.Fatalf("eager %t: unexpected stats %+v", eager, stat)
+		}
+		if !bytes.Equal(br.Digest(), expected[:]) {
+			t.Fatalf("eager %t: digest doesn't match", eager)
+		}
+
+		br.SetRequireRanges(true)
+		_, err = br.FetchFile(context.Background(), ts.URL, filename)
+		if !errors.Is(err, ErrNoContentLength) {
+			t.Fatalf("eager %t: expected ErrNoContentLength, got %v", eager, err)
+		}
+	}
+}
+
+func TestMaxSize(t *testing.T) {
+	var filename string = "maxsize.bin"
+	content := randomContent(1 << 20)
+	defer os.Remove(filename)
+
+	var mu sync.Mutex
+	var gets int
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "GET" {
+			mu.Lock()
+			gets++
+			mu.Unlock()
+		}
+		if r.URL.Path != "/chunked" {
+			http.ServeContent(w, r, filename, time.Time{}, bytes.NewReader(content))
+			return
+		}
+		// without a Content-Length, the size is only found out by reading
+		w.Header().Set("Transfer-Encoding", "chunked")
+		w.WriteHeader(http.StatusOK)
+		if r.Method == "GET" {
+			w.Write(content)
+		}
+	}))
+	defer ts.Close()
+
+	br, err := NewRequest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	br.SetJobs(4)
+	br.SetMaxSize(1 << 19)
+	file, err := br.FetchFile(context.Background(), ts.URL, filename)
+	if !errors.Is(err, ErrTooLarge) {
+		t.Fatalf("expected ErrTooLarge, got %v", err)
+	}
+	file.Close()
+	if gets != 0 {
+		t.Fatalf("expected nothing to be fetched, got %d requests", gets)
+	}
+	if _, err = os.Stat(partFilename(filename)); !os.IsNotExist(err) {
+		t.Fatalf("expected no file to be written")
+	}
+
+	file, err = br.FetchFile(context.Background(), ts.URL+"/chunked", filename)
+	if !errors.Is(err, ErrTooLarge) {
+		t.Fatalf("expected ErrTooLarge reading without a length, got %v", err)
+	}
+	file.Close()
+	if _, err = os.Stat(partFilename(filename)); !os.IsNotExist(err) {
+		t.Fatalf("expected the partial download to be removed")
+	}
+
+	// a resource within the maximum is fetched as normal
+	br.SetMaxSize(1 << 20)
+	file, err = br.FetchFile(context.Background(), ts.URL+"/chunked", filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+}
+
+func TestVerifyLength(t *testing.T) {
+	br, err := NewRequest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, rng := range [][2]int64{{0, 100}, {100, 200}} {
+		br.addJob(rng[0], rng[1])
+	}
+	if err = br.checkLength(200); !errors.Is(err, ErrShortDownload) {
+		t.Fatalf("expected ErrShortDownload with nothing fetched, got %v", err)
+	}
+
+	// the second job falls short of its range
+	br.stats[0].read.Store(100)
+	br.stats[1].read.Store(60)
+	err = br.checkLength(200)
+	if !errors.Is(err, ErrShortDownload) || !strings.Contains(err.Error(), "40 missing") {
+		t.Fatalf("expected ErrShortDownload with 40 bytes missing, got %v", err)
+	}
+
+	br.SetVerifyLength(false)
+	if err = br.checkLength(200); err != nil {
+		t.Fatalf("expected no check once disabled, got %v", err)
+	}
+
+	br.SetVerifyLength(true)
+	br.stats[1].read.Store(100)
+	if err = br.checkLength(200); err != nil {
+		t.Fatalf("expected a complete download to pass, got %v", err)
+	}
+}
+
+func TestRequireRanges(t *testing.T) {
+	var filename string = "ranges.bin"
+	content := randomContent(1 << 20)
+
+	// server ignoring Range
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", strconv.Itoa(len(content)))
+		w.Write(content)
+	}))
+	defer ts.Close()
+	defer os.Remove(filename)
+
+	for _, eager := range []bool{false, true} {
+		br, err := NewRequest()
+		if err != nil {
+			t.Fatal(err)
+		}
+		br.SetRequireRanges(true)
+		br.SetEagerStart(eager)
+		file, err := br.FetchFile(context.Background(), ts.URL, filename)
+		if !errors.Is(err, ErrRangesNotSupported) {
+			t.Fatalf("eager %t: expected ErrRangesNotSupported, got %v", eager, err)
+		}
+		file.Close()
+	}
+}
+
+func TestRangesIgnored(t *testing.T) {
+	var filename string = "ignored.bin"
+	content := randomContent(1 << 20)
+	defer os.Remove(filename)
+
+	for _, acceptRanges := range []string{"", "none"} {
+		var gets int32
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method == "GET" {
+				atomic.AddInt32(&gets, 1)
+			}
+			if acceptRanges != "" {
+				w.Header().Set("Accept-Ranges", acceptRanges)
+			}
+			w.Header().Set("Content-Length", strconv.Itoa(len(content)))
+			w.Write(content)
+		}))
+
+		br, err := NewRequest()
+		if err != nil {
+			t.Fatal(err)
+		}
+		br.SetJobs(4)
+		file, err := br.FetchFile(context.Background(), ts.URL, filename)
+		ts.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		file.Close()
+
+		got, err := os.ReadFile(filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, content) {
+			t.Fatalf("Accept-Ranges %q: downloaded content doesn't match", acceptRanges)
+		}
+		if gets != 1 {
+			t.Fatalf("Accept-Ranges %q: expected a single GET, got %d", acceptRanges, gets)
+		}
+	}
+}
+
+func TestLargeResourceRanges(t *testing.T) {
+	var filename string = "large.bin"
+	var length int64 = 5 << 30 // 5 GiB
+	defer os.Remove(filename)
+
+	var mu sync.Mutex
+	var ranges []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "HEAD" {
+			w.Header().Set("Accept-Ranges", "bytes")
+			w.Header().Set("Content-Length", strconv.FormatInt(length, 10))
+			return
+		}
+		// the ranges are only recorded, nothing is transferred
+		mu.Lock()
+		ranges = append(ranges, r.Header.Get("Range"))
+		mu.Unlock()
+		http.NotFound(w, r)
+	}))
+	defer ts.Close()
+
+	br, err := NewRequest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	br.SetJobs(4)
+	file, err := br.FetchFile(context.Background(), ts.URL, filename)
+	var statusErr *StatusError
+	if !errors.As(err, &statusErr) || statusErr.StatusCode != http.StatusNotFound {
+		t.Fatalf("expected 404 StatusError, got %v", err)
+	}
+	file.Close()
+
+	expected := map[string]bool{
+		"bytes=0-1342177279":          true,
+		"bytes=1342177280-2684354559": true,
+		"bytes=2684354560-4026531839": true,
+		"bytes=4026531840-5368709119": true,
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(ranges) != len(expected) {
+		t.Fatalf("expected %d ranges, got %v", len(expected), ranges)
+	}
+	for _, rng := range ranges {
+		if !expected[rng] {
+			t.Fatalf("unexpected range %s", rng)
+		}
+	}
+	if br.Stats().TotalBytes != length {
+		t.Fatalf("stats TotalBytes doesn't match: expected %d, got %d", length, br.Stats().TotalBytes)
+	}
+}
+
+func TestAllJobsFail(t *testing.T) {
+	var filename string = "fail.bin"
+	var jobs int = 8
+	content := randomContent(1 << 20)
+	defer os.Remove(filename)
+
+	// every job's request is held until all have arrived, then they all fail together
+	var arrived sync.WaitGroup
+	arrived.Add(jobs)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "HEAD" {
+			http.ServeContent(w, r, filename, time.Time{}, bytes.NewReader(content))
+			return
+		}
+		arrived.Done()
+		arrived.Wait()
+		http.Error(w, "failed", http.StatusInternalServerError)
+	}))
+
+	before := runtime.NumGoroutine()
+
+	br, err := NewRequest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	br.SetJobs(jobs)
+	file, err := br.FetchFile(context.Background(), ts.URL, filename)
+	if err == nil {
+		t.Fatalf("expected error from FetchFile")
+	}
+	file.Close()
+
+	joined, ok := err.(interface{ Unwrap() []error })
+	if !ok || len(joined.Unwrap()) != jobs {
+		t.Fatalf("expected %d errors, got %v", jobs, err)
+	}
+
+	ts.Close()
+	http.DefaultTransport.(*http.Transport).CloseIdleConnections()
+	// goroutines take a moment to exit once their connections close
+	deadline := time.Now().Add(5 * time.Second)
+	for runtime.NumGoroutine() > before && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if after := runtime.NumGoroutine(); after > before {
+		t.Fatalf("goroutines leaked: %d before, %d after", before, after)
+	}
+}
+
+func TestRangeError(t *testing.T) {
+	var filename string = "rangeerror.bin"
+	content := randomContent(1 << 20)
+	defer os.Remove(filename)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Range") == "bytes=524288-786431" {
+			// the connection drops part way through the range
+			w = &truncatingWriter{ResponseWriter: w, limit: 100000}
+		}
+		http.ServeContent(w, r, filename, time.Time{}, bytes.NewReader(content))
+	}))
+	defer ts.Close()
+
+	br, err := NewRequest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	br.SetJobs(4)
+	file, err := br.FetchFile(context.Background(), ts.URL, filename)
+	if err == nil {
+		t.Fatalf("expected error from FetchFile")
+	}
+	file.Close()
+
+	var rangeErr *RangeError
+	if !errors.As(err, &rangeErr) {
+		t.Fatalf("expected a RangeError, got %v", err)
+	}
+	// only the bytes after those written are missing
+	if rangeErr.Job != 2 || rangeErr.Start <= 524288 || rangeErr.Start > 524288+100000 || rangeErr.End != 786432 {
+		t.Fatalf("expected the rest of job 2's range to be missing, got job %d bytes %d-%d", rangeErr.Job, rangeErr.Start, rangeErr.End)
+	}
+	if rangeErr.Err == nil || errors.Unwrap(rangeErr) != rangeErr.Err {
+		t.Fatalf("expected the job's error to be wrapped, got %v", rangeErr.Err)
+	}
+}
+
+func TestFetchWriterAt(t *testing.T) {
+	content := randomContent(1 << 20)
+	ts := newContentServer(content)
+	defer ts.Close()
+
+	br, err := NewRequest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	br.SetJobs(4)
+	w := &bufferAt{b: make([]byte, len(content))}
+	n, err := br.FetchWriterAt(context.Background(), ts.URL, w)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != int64(len(content)) {
+		t.Fatalf("expected %d bytes written, got %d", len(content), n)
+	}
+	if !bytes.Equal(w.b, content) {
+		t.Fatalf("written content doesn't match")
+	}
+
+	// the digest can't be computed without reading the download back
+	br.SetDigest(sha256.New)
+	if _, err = br.FetchWriterAt(context.Background(), ts.URL, w); err == nil {
+		t.Fatalf("expected digest to require an io.ReaderAt")
+	}
+}
+
+func TestFetchToFile(t *testing.T) {
+	content := randomContent(1<<20 + 11)
+	ts := newContentServer(content)
+	defer ts.Close()
+
+	f, err := os.CreateTemp(t.TempDir(), "braid")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	// stale contents longer than the resource are replaced
+	if _, err = f.Write(randomContent(2 << 20)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = f.Seek(0, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+
+	br, err := NewRequest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	br.SetJobs(4)
+	n, err := br.FetchToFile(context.Background(), ts.URL, f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != int64(len(content)) {
+		t.Fatalf("expected %d bytes written, got %d", len(content), n)
+	}
+
+	// the file is left open for the caller
+	got, err := io.ReadAll(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, content) {
+		t.Fatalf("downloaded content doesn't match")
+	}
+}
+
+// maxWriteAt is an in-memory io.WriterAt that records the largest write made to it
+type maxWriteAt struct {
+	bufferAt
+	max int
+}
+
+func (w *maxWriteAt) WriteAt(p []byte, off int64) (int, error) {
+	w.Lock()
+	if len(p) > w.max {
+		w.max = len(p)
+	}
+	w.Unlock()
+	return w.bufferAt.WriteAt(p, off)
+}
+
+func TestBufferSize(t *testing.T) {
+	var bufferSize int = 1000
+	content := randomContent(1 << 20)
+	ts := newContentServer(content)
+	defer ts.Close()
+
+	br, err := NewRequest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	br.SetJobs(32)
+	br.SetBufferSize(bufferSize)
+	w := &maxWriteAt{bufferAt: bufferAt{b: make([]byte, len(content))}}
+	if _, err = br.FetchWriterAt(context.Background(), ts.URL, w); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(w.b, content) {
+		t.Fatalf("written content doesn't match")
+	}
+	if w.max != bufferSize {
+		t.Fatalf("expected writes of up to %d bytes, got %d", bufferSize, w.max)
+	}
+
+	// buffers of the old size aren't reused
+	br.SetBufferSize(0)
+	w = &maxWriteAt{bufferAt: bufferAt{b: make([]byte, len(content))}}
+	if _, err = br.FetchWriterAt(context.Background(), ts.URL, w); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(w.b, content) {
+		t.Fatalf("written content doesn't match")
+	}
+	if w.max <= bufferSize || w.max > DefaultBufferSize {
+		t.Fatalf("expected writes of up to %d bytes, got %d", DefaultBufferSize, w.max)
+	}
+}
+
+func TestProgressFunc(t *testing.T) {
+	var filename string = "progress.bin"
+	content := randomContent(4 << 20)
+	ts := newContentServer(content)
+	defer ts.Close()
+	defer os.Remove(filename)
+
+	var mu sync.Mutex
+	var calls []Stat
+	var returned int32
+	br, err := NewRequest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	br.SetJobs(4)
+	br.SetProgressFunc(func(s Stat) {
+		if atomic.LoadInt32(&returned) == 1 {
+			t.Errorf("progress function called after FetchFile returned")
+		}
+		mu.Lock()
+		calls = append(calls, s)
+		mu.Unlock()
+	})
+	file, err := br.FetchFile(context.Background(), ts.URL, filename)
+	atomic.StoreInt32(&returned, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+	// give any stray calls a chance to show up
+	time.Sleep(200 * time.Millisecond)
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(calls) == 0 {
+		t.Fatalf("expected progress function to be called")
+	}
+	for i := 1; i < len(calls); i++ {
+		if calls[i].ReadBytes < calls[i-1].ReadBytes {
+			t.Fatalf("progress went backwards: %v", calls)
+		}
+	}
+	last := calls[len(calls)-1]
+	if last.ReadBytes != int64(len(content)) || last.TotalBytes != int64(len(content)) {
+		t.Fatalf("expected final progress of %d bytes, got %+v", len(content), last)
+	}
+}
+
+func TestJobStats(t *testing.T) {
+	var filename string = "jobstats.bin"
+	content := randomContent(1<<20 + 3)
+	ts := newContentServer(content)
+	defer ts.Close()
+	defer os.Remove(filename)
+
+	br, err := NewRequest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	br.SetJobs(4)
+	file, err := br.FetchFile(context.Background(), ts.URL, filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+
+	stats := br.JobStats()
+	if len(stats) != 4 {
+		t.Fatalf("expected 4 jobs, got %d", len(stats))
+	}
+	expected := []int64{262144, 262144, 262144, 262147}
+	for i, s := range stats {
+		if s.TotalBytes != expected[i] || s.ReadBytes != expected[i] {
+			t.Fatalf("job %d: expected %d bytes, got %+v", i, expected[i], s)
+		}
+	}
+
+	// the stats are a copy
+	stats[0].ReadBytes = 0
+	if br.JobStats()[0].ReadBytes != expected[0] {
+		t.Fatalf("modifying the returned stats changed the request's stats")
+	}
+}
+
+func TestPercent(t *testing.T) {
+	br, err := NewRequest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p := br.Percent(); p != 0 {
+		t.Fatalf("expected 0%% before a download, got %v", p)
+	}
+
+	br.length = 400
+	for _, rng := range [][2]int64{{0, 100}, {100, 200}, {200, 400}} {
+		br.addJob(rng[0], rng[1])
+	}
+	tests := []struct {
+		read     [3]int64
+		expected float64
+	}{
+		{[3]int64{0, 0, 0}, 0},
+		{[3]int64{100, 0, 0}, 25},
+		{[3]int64{50, 50, 50}, 37.5},
+		{[3]int64{100, 100, 200}, 100},
+		// more than expected is still 100%
+		{[3]int64{100, 100, 300}, 100},
+	}
+	for _, tt := range tests {
+		for i, read := range tt.read {
+			br.stats[i].read.Store(read)
+		}
+		if p := br.Percent(); p != tt.expected {
+			t.Fatalf("%v read: expected %v%%, got %v", tt.read, tt.expected, p)
+		}
+	}
+
+	// the job reading a resource of unknown length grows its total as it goes
+	br.length = -1
+	br.stats = nil
+	jobID := br.addJob(0, -1)
+	br.stats[jobID].read.Store(1000)
+	br.stats[jobID].total.Store(1000)
+	if p := br.Percent(); p != -1 {
+		t.Fatalf("expected -1 for an unknown length, got %v", p)
+	}
+}
+
+func TestAuthorization(t *testing.T) {
+	var filename string = "auth.bin"
+	content := randomContent(1 << 20)
+	defer os.Remove(filename)
+
+	var expected string
+	var mu sync.Mutex
+	var requests, unauthorized int
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requests++
+		ok := r.Header.Get("Authorization") == expected
+		if !ok {
+			unauthorized++
+		}
+		mu.Unlock()
+		if !ok {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		http.ServeContent(w, r, filename, time.Time{}, bytes.NewReader(content))
+	}))
+	defer ts.Close()
+
+	tests := []struct {
+		name     string
+		set      func(br *Request)
+		expected string
+	}{
+		{"basic", func(br *Request) { br.SetBasicAuth("user", "secret") }, "Basic dXNlcjpzZWNyZXQ="},
+		{"bearer", func(br *Request) { br.SetBearerToken("token123") }, "Bearer token123"},
+	}
+	for _, tt := range tests {
+		expected = tt.expected
+		requests, unauthorized = 0, 0
+
+		var logMu sync.Mutex
+		logOut := ""
+		SetLogger(func(a string, b ...interface{}) {
+			logMu.Lock()
+			defer logMu.Unlock()
+			logOut += fmt.Sprintf(a, b...)
+		})
+
+		br, err := NewRequest()
+		if err != nil {
+			t.Fatal(err)
+		}
+		br.SetJobs(4)
+		tt.set(br)
+		file, err := br.FetchFile(context.Background(), ts.URL, filename)
+		if err != nil {
+			t.Fatalf("%s: %s", tt.name, err)
+		}
+		file.Close()
+
+		if requests != 5 || unauthorized != 0 {
+			t.Fatalf("%s: expected 5 authorized requests, got %d with %d unauthorized", tt.name, requests, unauthorized)
+		}
+		if strings.Contains(logOut, "secret") || strings.Contains(logOut, "token123") || strings.Contains(logOut, tt.expected) {
+			t.Fatalf("%s: credentials leaked into log: %s", tt.name, logOut)
+		}
+	}
+	SetLogger(func(string, ...interface{}) {})
+}
+
+func TestSetHeader(t *testing.T) {
+	var filename string = "header.bin"
+	content := randomContent(1 << 20)
+	defer os.Remove(filename)
+
+	var mu sync.Mutex
+	var requests int
+	var bad []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requests++
+		if r.Referer() != "https://example.com/" {
+			bad = append(bad, "Referer: "+r.Referer())
+		}
+		if v := r.Header.Values("X-Api-Key"); len(v) != 2 || v[0] != "a" || v[1] != "b" {
+			bad = append(bad, fmt.Sprintf("X-Api-Key: %v", v))
+		}
+		if r.UserAgent() != "braid test" {
+			bad = append(bad, "User-Agent: "+r.UserAgent())
+		}
+		if r.Method == "GET" && !strings.HasPrefix(r.Header.Get("Range"), "bytes=") {
+			bad = append(bad, "Range: "+r.Header.Get("Range"))
+		}
+		mu.Unlock()
+		http.ServeContent(w, r, filename, time.Time{}, bytes.NewReader(content))
+	}))
+	defer ts.Close()
+
+	br, err := NewRequest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	br.SetJobs(4)
+	br.SetHeader("referer", "https://example.com/")
+	br.SetHeader("Range", "bytes=0-0")
+	br.SetHeaders(http.Header{"X-Api-Key": {"a", "b"}})
+	// SetUserAgent takes precedence
+	br.SetHeader("User-Agent", "other")
+	br.SetUserAgent("braid test")
+	file, err := br.FetchFile(context.Background(), ts.URL, filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+
+	got, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, content) {
+		t.Fatalf("downloaded content doesn't match")
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if requests != 5 || len(bad) > 0 {
+		t.Fatalf("expected 5 requests with custom headers, got %d with bad headers %v", requests, bad)
+	}
+}
+
+func TestRequestModifier(t *testing.T) {
+	var filename string = "modifier.bin"
+	content := randomContent(1 << 20)
+	defer os.Remove(filename)
+
+	key := []byte("secret")
+	sign := func(r *http.Request) string {
+		mac := hmac.New(sha256.New, key)
+		fmt.Fprintf(mac, "%s %s %s", r.Method, r.URL.RequestURI(), r.Header.Get("Range"))
+		return hex.EncodeToString(mac.Sum(nil))
+	}
+
+	var mu sync.Mutex
+	var requests int
+	var bad []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requests++
+		if sig := r.Header.Get("X-Signature"); sig != sign(r) {
+			bad = append(bad, r.Method+" "+r.Header.Get("Range"))
+		}
+		mu.Unlock()
+		http.ServeContent(w, r, filename, time.Time{}, bytes.NewReader(content))
+	}))
+	defer ts.Close()
+
+	br, err := NewRequest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	br.SetJobs(4)
+	br.SetRequestModifier(func(req *http.Request) {
+		req.Header.Set("X-Signature", sign(req))
+		// braid's Range header is restored
+		req.Header.Set("Range", "bytes=0-0")
+	})
+	file, err := br.FetchFile(context.Background(), ts.URL, filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+
+	got, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, content) {
+		t.Fatalf("downloaded content doesn't match")
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if requests != 5 || len(bad) > 0 {
+		t.Fatalf("expected 5 signed requests, got %d with bad signatures %v", requests, bad)
+	}
+}
+
+func TestTokenProvider(t *testing.T) {
+	var filename string = "token.bin"
+	content := randomContent(1 << 20)
+	defer os.Remove(filename)
+
+	var mu sync.Mutex
+	var rejected int
+	valid := "token-1"
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		ok := r.Header.Get("Authorization") == "Bearer "+valid
+		// the first token expires after the HEAD request
+		valid = "token-2"
+		if !ok {
+			rejected++
+		}
+		mu.Unlock()
+		if !ok {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		http.ServeContent(w, r, filename, time.Time{}, bytes.NewReader(content))
+	}))
+	defer ts.Close()
+
+	var tokens int32
+	br, err := NewRequest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	br.SetJobs(4)
+	br.SetTokenProvider(func(ctx context.Context) (string, error) {
+		return fmt.Sprintf("token-%d", atomic.AddInt32(&tokens, 1)), nil
+	})
+	file, err := br.FetchFile(context.Background(), ts.URL, filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+
+	got, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, content) {
+		t.Fatalf("downloaded content doesn't match")
+	}
+	if tokens != 2 || rejected == 0 {
+		t.Fatalf("expected the rejected token to be refreshed once, got %d tokens and %d rejections", tokens, rejected)
+	}
+
+	// a token that keeps being rejected fails the download
+	br.SetTokenProvider(func(ctx context.Context) (string, error) {
+		return "bad", nil
+	})
+	_, err = br.FetchFile(context.Background(), ts.URL, filename)
 	if err == nil {
-		t.Fatalf("Expecting error from FetchFile but got nil")
+		t.Fatalf("expected rejected token to fail the download")
+	}
+}
+
+func TestRangeNotSatisfiable(t *testing.T) {
+	var filename string = "unsatisfiable.bin"
+	content := randomContent(1 << 20)
+	defer os.Remove(filename)
+	defer removeResumeState(filename)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Range") == "bytes=524288-786431" {
+			http.Error(w, "", http.StatusRequestedRangeNotSatisfiable)
+			return
+		}
+		http.ServeContent(w, r, filename, time.Time{}, bytes.NewReader(content))
+	}))
+	defer ts.Close()
+
+	br, err := NewRequest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	br.SetJobs(4)
+	br.SetResume(true)
+	br.SetMaxRetries(2)
+	file, err := br.FetchFile(context.Background(), ts.URL, filename)
+	if !errors.Is(err, ErrRangeNotSatisfiable) {
+		t.Fatalf("expected ErrRangeNotSatisfiable, got %v", err)
+	}
+	if !strings.Contains(err.Error(), "bytes 524288-786431") {
+		t.Fatalf("expected error to include the range, got %v", err)
+	}
+	if file != nil {
+		t.Fatalf("expected no file to be returned")
+	}
+	if _, err = os.Stat(partFilename(filename)); !os.IsNotExist(err) {
+		t.Fatalf("expected partial file to be removed")
+	}
+	if _, err = os.Stat(resumeFilename(filename)); !os.IsNotExist(err) {
+		t.Fatalf("expected resume state to be removed")
+	}
+}
+
+func TestChunkResponseMismatch(t *testing.T) {
+	var filename string = "mismatch.bin"
+	content := randomContent(1 << 20)
+	defer os.Remove(filename)
+
+	tests := []struct {
+		name     string
+		respond  func(w http.ResponseWriter, r *http.Request)
+		expected error
+	}{
+		{"full content", func(w http.ResponseWriter, r *http.Request) {
+			w.Write(content)
+		}, ErrRangesNotSupported},
+		{"wrong range", func(w http.ResponseWriter, r *http.Request) {
+			r.Header.Set("Range", "bytes=0-262143")
+			http.ServeContent(w, r, filename, time.Time{}, bytes.NewReader(content))
+		}, ErrContentRangeMismatch},
+		{"wrong length", func(w http.ResponseWriter, r *http.Request) {
+			http.ServeContent(w, r, filename, time.Time{}, bytes.NewReader(append(content, 0)))
+		}, ErrContentRangeMismatch},
+	}
+
+	for _, tt := range tests {
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("Range") == "bytes=524288-786431" {
+				tt.respond(w, r)
+				return
+			}
+			http.ServeContent(w, r, filename, time.Time{}, bytes.NewReader(content))
+		}))
+
+		br, err := NewRequest()
+		if err != nil {
+			t.Fatal(err)
+		}
+		br.SetJobs(4)
+		file, err := br.FetchFile(context.Background(), ts.URL, filename)
+		ts.Close()
+		if !errors.Is(err, tt.expected) {
+			t.Fatalf("%s: expected %v, got %v", tt.name, tt.expected, err)
+		}
+		file.Close()
+	}
+}
+
+func TestResourceChangedMidDownload(t *testing.T) {
+	var filename string = "changed.bin"
+	content := randomContent(1 << 20)
+	changed := randomContent(1<<20 + 1)[1:]
+	modtime := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	defer os.Remove(filename)
+
+	tests := []struct {
+		name    string
+		etag    string
+		modtime time.Time
+	}{
+		{"etag", `"v1"`, time.Time{}},
+		{"weak etag", `W/"v1"`, modtime},
+		{"last modified", "", modtime},
+	}
+
+	for _, tt := range tests {
+		v := &versionedServer{content: content, etag: tt.etag, modtime: tt.modtime}
+		var once sync.Once
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method == "GET" {
+				// a new version is deployed once the download has started
+				once.Do(func() {
+					v.Lock()
+					v.content, v.etag, v.modtime = changed, tt.etag+"2", tt.modtime.Add(time.Hour)
+					v.Unlock()
+				})
+			}
+			v.ServeHTTP(w, r)
+		}))
+
+		br, err := NewRequest()
+		if err != nil {
+			t.Fatal(err)
+		}
+		br.SetJobs(4)
+		file, err := br.FetchFile(context.Background(), ts.URL, filename)
+		ts.Close()
+		if !errors.Is(err, ErrResourceChanged) {
+			t.Fatalf("%s: expected %v, got %v", tt.name, ErrResourceChanged, err)
+		}
+		file.Close()
+	}
+}
+
+func TestIfRangeValidator(t *testing.T) {
+	lm := "Wed, 01 Jan 2020 00:00:00 GMT"
+	tests := []struct {
+		etag, lastModified, date string
+		expected                 string
+	}{
+		{`"v1"`, lm, "", `"v1"`},
+		{`W/"v1"`, lm, "Wed, 01 Jan 2020 00:00:01 GMT", lm},
+		{"", lm, "Thu, 02 Jan 2020 00:00:00 GMT", lm},
+		// modified within the second the response was sent
+		{"", lm, lm, ""},
+		{"", lm, "", ""},
+		{`W/"v1"`, "", "", ""},
+		{"", "", "", ""},
+	}
+	for _, tt := range tests {
+		header := http.Header{}
+		if tt.etag != "" {
+			header.Set("ETag", tt.etag)
+		}
+		if tt.lastModified != "" {
+			header.Set("Last-Modified", tt.lastModified)
+		}
+		if tt.date != "" {
+			header.Set("Date", tt.date)
+		}
+		if got := ifRangeValidator(header); got != tt.expected {
+			t.Errorf("ETag %q Last-Modified %q Date %q: expected %q, got %q", tt.etag, tt.lastModified, tt.date, tt.expected, got)
+		}
+	}
+}
+
+func TestParseContentRange(t *testing.T) {
+	tests := []struct {
+		header              string
+		first, last, length int64
+		valid               bool
+	}{
+		{"bytes 0-99/1234", 0, 99, 1234, true},
+		{"bytes 100-199/*", 100, 199, -1, true},
+		{"bytes 5368709120-5368709129/10737418240", 5368709120, 5368709129, 10737418240, true},
+		{"bytes */1234", 0, 0, 0, false},
+		{"bytes 99-0/1234", 0, 0, 0, false},
+		{"items 0-99/1234", 0, 0, 0, false},
+		{"bytes 0-99", 0, 0, 0, false},
+		{"", 0, 0, 0, false},
+	}
+	for _, tt := range tests {
+		first, last, length, err := parseContentRange(tt.header)
+		if (err == nil) != tt.valid {
+			t.Errorf("%q: expected valid %t, got error %v", tt.header, tt.valid, err)
+			continue
+		}
+		if first != tt.first || last != tt.last || length != tt.length {
+			t.Errorf("%q: expected %d-%d/%d, got %d-%d/%d", tt.header, tt.first, tt.last, tt.length, first, last, length)
+		}
+	}
+}
+
+func TestFetchFileContextErrors(t *testing.T) {
+	var filename string = "context.bin"
+	content := randomContent(1 << 20)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tw := &throttledWriter{ResponseWriter: w, mu: &sync.Mutex{}, bytesPerSec: 256 << 10}
+		http.ServeContent(tw, r, filename, time.Time{}, bytes.NewReader(content))
+	}))
+	defer ts.Close()
+	defer os.Remove(filename)
+
+	deadline, cancelDeadline := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancelDeadline()
+	cancelled, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(200*time.Millisecond, cancel)
+
+	tests := []struct {
+		name     string
+		ctx      context.Context
+		expected error
+	}{
+		{"deadline", deadline, context.DeadlineExceeded},
+		{"cancel", cancelled, context.Canceled},
+	}
+
+	for _, tt := range tests {
+		br, err := NewRequest()
+		if err != nil {
+			t.Fatal(err)
+		}
+		file, err := br.FetchFile(tt.ctx, ts.URL, filename)
+		if !errors.Is(err, tt.expected) {
+			t.Fatalf("%s: expected error wrapping %v, got %v", tt.name, tt.expected, err)
+		}
+		file.Close()
+	}
+}
+
+func TestSplitFunc(t *testing.T) {
+	var filename string = "split.bin"
+	content := randomContent(1 << 20)
+	ts := newContentServer(content)
+	defer ts.Close()
+	defer os.Remove(filename)
+
+	// geometric split, each range twice the size of the last
+	geometric := func(length int64, jobs int) [][2]int64 {
+		var ranges [][2]int64
+		var start, size int64 = 0, length >> uint(jobs)
+		for i := 0; i < jobs; i++ {
+			end := start + size
+			if i == jobs-1 {
+				end = length
+			}
+			ranges = append(ranges, [2]int64{start, end})
+			start, size = end, size*2
+		}
+		return ranges
+	}
+	gap := func(length int64, jobs int) [][2]int64 {
+		return [][2]int64{{0, 10}, {11, length}}
+	}
+	short := func(length int64, jobs int) [][2]int64 {
+		return [][2]int64{{0, length - 1}}
+	}
+
+	br, err := NewRequest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	br.SetJobs(4)
+	br.SetSplitFunc(geometric)
+	file, err := br.FetchFile(context.Background(), ts.URL, filename)
+	if err != nil {
+		t.Fatal(err)
 	}
-	t.Logf("FetchFile err %s\n", err)
 	file.Close()
-	err = os.Remove(filename)
+
+	got, err := os.ReadFile(filename)
 	if err != nil {
 		t.Fatal(err)
 	}
+	if !bytes.Equal(got, content) {
+		t.Fatalf("downloaded content doesn't match")
+	}
+	if first := br.Manifest().Chunks[0]; first.End != 1<<16 {
+		t.Fatalf("expected first chunk to end at %d, got %d", 1<<16, first.End)
+	}
+
+	for _, f := range []func(int64, int) [][2]int64{gap, short} {
+		br.SetSplitFunc(f)
+		file, err = br.FetchFile(context.Background(), ts.URL, filename)
+		if err == nil {
+			t.Fatalf("expected error for invalid split")
+		}
+		file.Close()
+	}
+}
+
+func TestChunkSize(t *testing.T) {
+	var filename string = "chunks.bin"
+	content := randomContent(1 << 20)
+	defer os.Remove(filename)
+
+	// the first connection to make a GET is slow and any other is fast
+	var mu sync.Mutex
+	slowAddr := ""
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		if slowAddr == "" && r.Method == "GET" {
+			slowAddr = r.RemoteAddr
+		}
+		slow := r.RemoteAddr == slowAddr
+		mu.Unlock()
+		if slow {
+			w = &throttledWriter{ResponseWriter: w, mu: &sync.Mutex{}, bytesPerSec: 1 << 20}
+		}
+		http.ServeContent(w, r, filename, time.Time{}, bytes.NewReader(content))
+	}))
+	defer ts.Close()
+
+	fetch := func(chunkSize int64) time.Duration {
+		mu.Lock()
+		slowAddr = ""
+		mu.Unlock()
+
+		br, err := NewRequest()
+		if err != nil {
+			t.Fatal(err)
+		}
+		br.SetJobs(2)
+		br.SetChunkSize(chunkSize)
+		start := time.Now()
+		file, err := br.FetchFile(context.Background(), ts.URL, filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		elapsed := time.Since(start)
+		file.Close()
+
+		got, err := os.ReadFile(filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, content) {
+			t.Fatalf("chunk size %d: downloaded content doesn't match", chunkSize)
+		}
+		return elapsed
+	}
+
+	static := fetch(0)
+	stealing := fetch(64 << 10)
+	// the slow connection holds up half the download when it is split statically
+	if stealing >= static {
+		t.Fatalf("expected queued chunks to finish faster than a static split: %s vs %s", stealing, static)
+	}
+}
+
+func TestEvenSplit(t *testing.T) {
+	ranges := EvenSplit(10, 3)
+	expected := [][2]int64{{0, 3}, {3, 6}, {6, 10}}
+	if len(ranges) != len(expected) {
+		t.Fatalf("expected %v, got %v", expected, ranges)
+	}
+	for i := range ranges {
+		if ranges[i] != expected[i] {
+			t.Fatalf("expected %v, got %v", expected, ranges)
+		}
+	}
+}
+
+// newContentServer returns a test HTTP server serving content, honoring Range requests
+func newContentServer(content []byte) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "data.bin", time.Time{}, bytes.NewReader(content))
+	}))
+}
+
+// randomContent returns n bytes of pseudo-random data
+func randomContent(n int) []byte {
+	b := make([]byte, n)
+	rand.New(rand.NewSource(int64(n))).Read(b)
+	return b
 }
 
 // data provides a way to generate a file of any size to be served by the test HTTP server
@@ -182,3 +2254,30 @@ func (b *data) Seek(o int64, w int) (int64, error) {
 
 	return b.count, nil
 }
+
+func BenchmarkFetchFile(b *testing.B) {
+	var filename string = "bench.bin"
+	content := randomContent(32 << 20)
+	ts := newContentServer(content)
+	defer ts.Close()
+	defer os.Remove(filename)
+	SetLogger(func(string, ...interface{}) {})
+
+	for _, jobs := range []int{1, 4, 16} {
+		b.Run(fmt.Sprintf("jobs=%d", jobs), func(b *testing.B) {
+			b.SetBytes(int64(len(content)))
+			for i := 0; i < b.N; i++ {
+				br, err := NewRequest()
+				if err != nil {
+					b.Fatal(err)
+				}
+				br.SetJobs(jobs)
+				file, err := br.FetchFile(context.Background(), ts.URL, filename)
+				if err != nil {
+					b.Fatal(err)
+				}
+				file.Close()
+			}
+		})
+	}
+}
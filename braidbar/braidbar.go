@@ -0,0 +1,196 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package braidbar renders the progress of a braid download as a terminal progress bar.
+package braidbar
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/porjo/braid"
+)
+
+// DefaultInterval is how often the bar is redrawn on a terminal by default.
+const DefaultInterval = 250 * time.Millisecond
+
+// DefaultLogInterval is how often a progress line is written when not on a terminal by default.
+const DefaultLogInterval = 5 * time.Second
+
+// DefaultWidth is the line width used when the terminal width can't be determined.
+const DefaultWidth = 80
+
+// smoothing is the weight given to the latest sample when averaging the rate.
+const smoothing = 0.3
+
+// Stater is implemented by anything reporting download statistics, such as *braid.Request.
+type Stater interface {
+	Stats() braid.Stat
+}
+
+// Bar renders download progress to a writer. On a terminal, it is redrawn in place on a single line
+// with a bar, rate and ETA. Otherwise a progress line is written periodically, suitable for logs.
+type Bar struct {
+	w        io.Writer
+	s        Stater
+	tty      bool
+	width    int
+	interval time.Duration
+
+	quit     chan struct{}
+	done     chan struct{}
+	stopOnce sync.Once
+
+	// these are only used by the rendering goroutine
+	last     braid.Stat
+	lastTime time.Time
+	rate     float64
+}
+
+// New returns a bar rendering the progress of s to w.
+func New(w io.Writer, s Stater) *Bar {
+	b := &Bar{
+		w:        w,
+		s:        s,
+		width:    DefaultWidth,
+		interval: DefaultLogInterval,
+	}
+	if f, ok := w.(*os.File); ok && isTerminal(f) {
+		b.tty = true
+		b.interval = DefaultInterval
+		b.width = terminalWidth(f)
+	}
+	return b
+}
+
+// SetInterval sets how often progress is rendered.
+func (b *Bar) SetInterval(interval time.Duration) {
+	b.interval = interval
+}
+
+// Start starts rendering progress in a goroutine.
+func (b *Bar) Start() {
+	b.quit = make(chan struct{})
+	b.done = make(chan struct{})
+	b.lastTime = time.Now()
+	go b.run()
+}
+
+// Stop stops rendering, writing the final progress.
+func (b *Bar) Stop() {
+	b.stopOnce.Do(func() {
+		close(b.quit)
+	})
+	<-b.done
+}
+
+func (b *Bar) run() {
+	defer close(b.done)
+	ticker := time.NewTicker(b.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			b.render(false)
+		case <-b.quit:
+			b.render(true)
+			return
+		}
+	}
+}
+
+// render samples the stats and writes the progress line.
+func (b *Bar) render(final bool) {
+	stat := b.s.Stats()
+	now := time.Now()
+	if elapsed := now.Sub(b.lastTime).Seconds(); elapsed > 0 {
+		rate := float64(stat.ReadBytes-b.last.ReadBytes) / elapsed
+		if b.rate == 0 {
+			b.rate = rate
+		} else {
+			b.rate = smoothing*rate + (1-smoothing)*b.rate
+		}
+	}
+	b.last = stat
+	b.lastTime = now
+
+	if b.tty {
+		line := b.line(stat)
+		if final {
+			line += "\n"
+		}
+		fmt.Fprint(b.w, "\r"+line)
+	} else {
+		fmt.Fprintln(b.w, b.summary(stat))
+	}
+}
+
+// line returns a progress bar filling the line width, followed by the summary.
+func (b *Bar) line(stat braid.Stat) string {
+	summary := b.summary(stat)
+	barWidth := b.width - len(summary) - 3
+	if barWidth < 10 {
+		return summary
+	}
+
+	filled := 0
+	if stat.TotalBytes > 0 {
+		filled = int(int64(barWidth) * stat.ReadBytes / stat.TotalBytes)
+	}
+	if filled > barWidth {
+		filled = barWidth
+	}
+	return "[" + strings.Repeat("=", filled) + strings.Repeat(" ", barWidth-filled) + "] " + summary
+}
+
+// summary returns the percentage, bytes, rate and ETA.
+func (b *Bar) summary(stat braid.Stat) string {
+	percent := 0.0
+	if stat.TotalBytes > 0 {
+		percent = 100 * float64(stat.ReadBytes) / float64(stat.TotalBytes)
+	}
+
+	eta := "--"
+	if b.rate > 0 && stat.TotalBytes >= stat.ReadBytes {
+		remaining := time.Duration(float64(stat.TotalBytes-stat.ReadBytes) / b.rate * float64(time.Second))
+		eta = remaining.Round(time.Second).String()
+	}
+
+	return fmt.Sprintf("%5.1f%% %s/%s %s/s ETA %s",
+		percent, braid.FormatBytes(float64(stat.ReadBytes)), braid.FormatBytes(float64(stat.TotalBytes)), braid.FormatBytes(b.rate), eta)
+}
+
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return fi.Mode()&os.ModeCharDevice != 0
+}
+
+// terminalWidth returns the width of the terminal f, falling back to $COLUMNS then DefaultWidth.
+func terminalWidth(f *os.File) int {
+	if width := ioctlWidth(f); width > 0 {
+		return width
+	}
+	if width, err := strconv.Atoi(os.Getenv("COLUMNS")); err == nil && width > 0 {
+		return width
+	}
+	return DefaultWidth
+}
@@ -0,0 +1,83 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package braidbar
+
+import (
+	"bytes"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/porjo/braid"
+)
+
+type fakeStater struct {
+	sync.Mutex
+	stat braid.Stat
+}
+
+func (f *fakeStater) Stats() braid.Stat {
+	f.Lock()
+	defer f.Unlock()
+	return f.stat
+}
+
+func (f *fakeStater) set(read int64) {
+	f.Lock()
+	defer f.Unlock()
+	f.stat.ReadBytes = read
+}
+
+func TestBarLog(t *testing.T) {
+	s := &fakeStater{stat: braid.Stat{TotalBytes: 4 << 20}}
+	var buf bytes.Buffer
+
+	b := New(&buf, s)
+	b.SetInterval(10 * time.Millisecond)
+	b.Start()
+	for i := int64(1); i <= 4; i++ {
+		time.Sleep(20 * time.Millisecond)
+		s.set(i << 20)
+	}
+	b.Stop()
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) < 2 {
+		t.Fatalf("expected several progress lines, got %q", buf.String())
+	}
+	last := lines[len(lines)-1]
+	if !strings.Contains(last, "100.0%") || !strings.Contains(last, "4.0MiB/4.0MiB") {
+		t.Fatalf("unexpected final progress line %q", last)
+	}
+	if strings.Contains(buf.String(), "\r") {
+		t.Fatalf("expected no carriage returns when not writing to a terminal")
+	}
+}
+
+func TestBarLine(t *testing.T) {
+	s := &fakeStater{stat: braid.Stat{TotalBytes: 1000, ReadBytes: 500}}
+	b := New(&bytes.Buffer{}, s)
+	b.width = 70
+	b.rate = 100
+
+	line := b.line(s.Stats())
+	if len(line) > b.width {
+		t.Fatalf("line length %d exceeds width %d: %q", len(line), b.width, line)
+	}
+	if !strings.HasPrefix(line, "[") || !strings.Contains(line, " 50.0%") || !strings.Contains(line, "ETA 5s") {
+		t.Fatalf("unexpected progress line %q", line)
+	}
+}
@@ -0,0 +1,68 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package braid
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+)
+
+// CacheEntry describes a previously downloaded resource.
+type CacheEntry struct {
+	ETag string
+	Size int64
+	// Path is where the resource was downloaded to
+	Path string
+}
+
+// Cache stores details of downloaded resources by URL. Implementations must be safe for concurrent use.
+type Cache interface {
+	Get(url string) (CacheEntry, bool)
+	Put(url string, entry CacheEntry)
+}
+
+// SetCache sets a cache of previously downloaded resources. Before downloading, the resource's
+// ETag is checked against the cache with a conditional request. If it is unchanged the cached file
+// is returned instead of downloading it again, unless the file has gone missing or changed size, in
+// which case its entry is dropped and the resource downloaded. Successful downloads with an ETag are
+// added to the cache.
+func (r *Request) SetCache(c Cache) {
+	r.cache = c
+}
+
+// openCached opens the cached file for an unmodified resource, positioned at its start as FetchFile
+// returns it. If the file is missing or its size doesn't match, the entry for url is dropped and nil is
+// returned, for the resource to be downloaded again.
+func (r *Request) openCached(url string, entry CacheEntry) *os.File {
+	file, err := os.OpenFile(entry.Path, os.O_RDWR, 0)
+	if err == nil {
+		var fi os.FileInfo
+		if fi, err = file.Stat(); err == nil && fi.Size() != entry.Size {
+			err = fmt.Errorf("size %d doesn't match %d", fi.Size(), entry.Size)
+		}
+		if err != nil {
+			file.Close()
+		}
+	}
+	if err != nil {
+		r.log(slog.LevelWarn, fmt.Sprintf("not using cached %s: %s, downloading again", entry.Path, err))
+		// a cache is only a shortcut, whose stale entry is replaced by an empty one
+		r.cache.Put(url, CacheEntry{})
+		return nil
+	}
+	r.log(slog.LevelInfo, "resource not modified, using cached "+entry.Path)
+	return file
+}
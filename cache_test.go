@@ -0,0 +1,187 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package braid
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+)
+
+type mapCache struct {
+	sync.Mutex
+	entries map[string]CacheEntry
+}
+
+func (m *mapCache) Get(url string) (CacheEntry, bool) {
+	m.Lock()
+	defer m.Unlock()
+	e, ok := m.entries[url]
+	return e, ok
+}
+
+func (m *mapCache) Put(url string, entry CacheEntry) {
+	m.Lock()
+	defer m.Unlock()
+	m.entries[url] = entry
+}
+
+func TestCache(t *testing.T) {
+	var filename string = "cache.bin"
+	defer os.Remove(filename)
+
+	content := randomContent(100000)
+	v := &versionedServer{content: content, etag: `"v1"`}
+	ts := httptest.NewServer(v)
+	defer ts.Close()
+
+	cache := &mapCache{entries: map[string]CacheEntry{}}
+
+	for _, eager := range []bool{false, true} {
+		for i := 0; i < 2; i++ {
+			v.Lock()
+			v.ranges = nil
+			v.Unlock()
+
+			r, err := NewRequest()
+			if err != nil {
+				t.Fatal(err)
+			}
+			r.SetCache(cache)
+			r.SetEagerStart(eager)
+			file, err := r.FetchFile(context.Background(), ts.URL, filename)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := io.ReadAll(io.NewSectionReader(file, 0, int64(len(content))+1))
+			file.Close()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, content) {
+				t.Fatalf("eager %t, fetch %d: content mismatch", eager, i)
+			}
+
+			v.Lock()
+			gets := len(v.ranges)
+			v.Unlock()
+			if eager {
+				// the eager probe is answered with 304 rather than content
+				gets--
+			}
+			if i == 0 && !eager && gets == 0 {
+				t.Fatalf("expected first fetch to download")
+			}
+			if i == 1 && gets != 0 {
+				t.Fatalf("eager %t: expected cached file to be used, got %d GETs", eager, gets)
+			}
+		}
+	}
+
+	e, ok := cache.Get(ts.URL)
+	if !ok || e.ETag != `"v1"` || e.Size != int64(len(content)) || e.Path != filename {
+		t.Fatalf("unexpected cache entry %+v", e)
+	}
+
+	// a changed resource is downloaded again
+	content = randomContent(50000)
+	v.set(content, `"v2"`)
+	r, err := NewRequest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.SetCache(cache)
+	file, err := r.FetchFile(context.Background(), ts.URL, filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	got, err := io.ReadAll(io.NewSectionReader(file, 0, int64(len(content))+1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, content) {
+		t.Fatalf("content mismatch after change")
+	}
+	if e, _ := cache.Get(ts.URL); e.ETag != `"v2"` {
+		t.Fatalf("expected cache to be updated, got %+v", e)
+	}
+}
+
+func TestCacheStale(t *testing.T) {
+	var filename string = "cache.bin"
+	defer os.Remove(filename)
+
+	content := randomContent(100000)
+	v := &versionedServer{content: content, etag: `"v1"`}
+	ts := httptest.NewServer(v)
+	defer ts.Close()
+
+	cache := &mapCache{entries: map[string]CacheEntry{}}
+	fetch := func() *os.File {
+		r, err := NewRequest()
+		if err != nil {
+			t.Fatal(err)
+		}
+		r.SetCache(cache)
+		file, err := r.FetchFile(context.Background(), ts.URL, filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := io.ReadAll(io.NewSectionReader(file, 0, int64(len(content))+1))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, content) {
+			t.Fatalf("content mismatch")
+		}
+		return file
+	}
+	fetch().Close()
+
+	// the cached file is returned writable, as a downloaded one is
+	file := fetch()
+	if _, err := file.WriteAt([]byte("x"), 0); err != nil {
+		t.Fatalf("expected the cached file to be writable, got %v", err)
+	}
+	file.Close()
+
+	// a cached file that's changed size or gone missing is downloaded again
+	for _, spoil := range []func() error{
+		func() error { return os.Truncate(filename, 10) },
+		func() error { return os.Remove(filename) },
+	} {
+		if err := spoil(); err != nil {
+			t.Fatal(err)
+		}
+		v.Lock()
+		v.ranges = nil
+		v.Unlock()
+		fetch().Close()
+		v.Lock()
+		gets := len(v.ranges)
+		v.Unlock()
+		if gets == 0 {
+			t.Fatalf("expected the resource to be downloaded again")
+		}
+		if e, _ := cache.Get(ts.URL); e.ETag != `"v1"` || e.Size != int64(len(content)) {
+			t.Fatalf("expected the cache entry to be restored, got %+v", e)
+		}
+	}
+}
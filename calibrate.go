@@ -0,0 +1,99 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package braid
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+// calibrationSize is the size of each chunk fetched while calibrating the number of jobs.
+const calibrationSize = 128 << 10
+
+// calibrationConns are the numbers of connections throughput is measured with, in order.
+var calibrationConns = []int{1, 2, 4}
+
+// calibrationLength is the total number of bytes fetched while calibrating.
+const calibrationLength int64 = (1 + 2 + 4) * calibrationSize
+
+// calibrationGain is the improvement in throughput required before more connections are used.
+const calibrationGain = 1.25
+
+// Calibration holds the throughput measured with different numbers of connections.
+type Calibration struct {
+	// BytesPerSec maps a number of connections to the throughput measured using them.
+	BytesPerSec map[int]float64
+	// Jobs is the number of jobs chosen for the rest of the download.
+	Jobs int
+}
+
+// SetAdaptiveJobs sets whether the number of jobs should be chosen by measuring throughput at the
+// start of the download. Some servers throttle each connection, so more jobs means more speed,
+// while others throttle each client, so more jobs only adds overhead.
+// When enabled, the chosen number of jobs replaces the one set by SetJobs.
+// Resources too small to calibrate are fetched as normal.
+func (r *Request) SetAdaptiveJobs(adaptive bool) {
+	r.adaptiveJobs = adaptive
+}
+
+// SetCalibrationFunc sets a function to be called with the results once calibration completes.
+func (r *Request) SetCalibrationFunc(f func(Calibration)) {
+	r.calibrationFunc = f
+}
+
+// calibrate fetches the start of the resource using 1, 2 and then 4 connections, measuring the throughput
+// of each. It returns the offset reached and the number of jobs to use for the rest of the resource.
+func (r *Request) calibrate(ctx context.Context) (int64, int, error) {
+	cal := Calibration{
+		BytesPerSec: make(map[int]float64),
+		Jobs:        1,
+	}
+
+	var offset int64
+	for _, conns := range calibrationConns {
+		errChan := make(chan error, conns)
+		start := time.Now()
+
+		r.wg.Add(conns)
+		for i := 0; i < conns; i++ {
+			min := offset
+			max := offset + calibrationSize
+			go r.fetchFile(ctx, min, max, r.addJob(min, max), errChan, nil)
+			offset = max
+		}
+		r.wg.Wait()
+
+		close(errChan)
+		if err := <-errChan; err != nil {
+			return 0, 0, err
+		}
+
+		rate := float64(conns*calibrationSize) / time.Since(start).Seconds()
+		cal.BytesPerSec[conns] = rate
+		// only use more connections if they are worth it
+		if rate > cal.BytesPerSec[cal.Jobs]*calibrationGain {
+			cal.Jobs = conns
+		}
+	}
+
+	r.log(slog.LevelInfo, fmt.Sprintf("calibrated to %d jobs, throughput by connections: %v", cal.Jobs, cal.BytesPerSec))
+	if r.calibrationFunc != nil {
+		r.calibrationFunc(cal)
+	}
+
+	return offset, cal.Jobs, nil
+}
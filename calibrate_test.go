@@ -0,0 +1,99 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package braid
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+// throttledWriter limits the rate at which a response is written. If mu is shared
+// between responses, the limit applies to all of them together.
+type throttledWriter struct {
+	http.ResponseWriter
+	mu          *sync.Mutex
+	bytesPerSec int
+}
+
+func (w *throttledWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	time.Sleep(time.Duration(len(p)) * time.Second / time.Duration(w.bytesPerSec))
+	w.mu.Unlock()
+	return w.ResponseWriter.Write(p)
+}
+
+func TestAdaptiveJobs(t *testing.T) {
+	var filename string = "adaptive.bin"
+	var bytesPerSec int = 4 << 20
+	content := randomContent(3 << 20)
+
+	var global sync.Mutex
+	tests := []struct {
+		name     string
+		perConn  bool
+		expected int
+	}{
+		{"per connection throttling", true, 4},
+		{"per client throttling", false, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				mu := &global
+				if tt.perConn {
+					mu = &sync.Mutex{}
+				}
+				tw := &throttledWriter{ResponseWriter: w, mu: mu, bytesPerSec: bytesPerSec}
+				http.ServeContent(tw, r, filename, time.Time{}, bytes.NewReader(content))
+			}))
+			defer ts.Close()
+			defer os.Remove(filename)
+
+			br, err := NewRequest()
+			if err != nil {
+				t.Fatal(err)
+			}
+			br.SetAdaptiveJobs(true)
+			var cal Calibration
+			br.SetCalibrationFunc(func(c Calibration) {
+				cal = c
+			})
+
+			file, err := br.FetchFile(context.Background(), ts.URL, filename)
+			if err != nil {
+				t.Fatal(err)
+			}
+			file.Close()
+
+			if cal.Jobs != tt.expected {
+				t.Fatalf("expected calibration to choose %d jobs, got %d (%v)", tt.expected, cal.Jobs, cal.BytesPerSec)
+			}
+			got, err := os.ReadFile(filename)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, content) {
+				t.Fatalf("downloaded content doesn't match")
+			}
+		})
+	}
+}
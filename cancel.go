@@ -0,0 +1,86 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package braid
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// CancelledError is returned by a download stopped by Cancel. It matches context.Canceled with errors.Is,
+// and the errors of the jobs it stopped can be inspected with errors.Is and errors.As.
+type CancelledError struct {
+	// Bytes is the number of bytes fetched before the download stopped
+	Bytes int64
+	// Err is the error the download stopped with
+	Err error
+}
+
+func (e *CancelledError) Error() string {
+	return fmt.Sprintf("cancelled after fetching %d bytes: %s", e.Bytes, e.Err)
+}
+
+func (e *CancelledError) Unwrap() []error {
+	return []error{context.Canceled, e.Err}
+}
+
+// errCancelled is the cause of a download's context being cancelled by Cancel.
+var errCancelled = errors.New("download cancelled")
+
+// Cancel stops the download in progress, whichever method started it. Its jobs are stopped and waited
+// for, then the download returns a *CancelledError giving the number of bytes fetched, which matches
+// context.Canceled with errors.Is. Unlike other failures, FetchFile and FetchRange return the partial
+// download along with the error, positioned at its start, and leave it with its '.part' suffix for the
+// caller to keep or remove. Cancel does nothing if no download is in progress. It is thread safe and can
+// be called from a goroutine.
+func (r *Request) Cancel() {
+	r.mu.Lock()
+	stop := r.stop
+	r.mu.Unlock()
+	if stop != nil {
+		stop(errCancelled)
+	}
+}
+
+// cancellable returns a context derived from ctx that Cancel cancels, and the function that releases it.
+func (r *Request) cancellable(ctx context.Context) (context.Context, func()) {
+	ctx, stop := context.WithCancelCause(ctx)
+	r.mu.Lock()
+	r.stop = stop
+	r.mu.Unlock()
+	return ctx, func() { stop(nil) }
+}
+
+// cancelledError returns err as a *CancelledError if the download using ctx was stopped by Cancel.
+func (r *Request) cancelledError(ctx context.Context, err error) error {
+	if err == nil || context.Cause(ctx) != errCancelled {
+		return err
+	}
+	return &CancelledError{Bytes: r.Stats().ReadBytes, Err: err}
+}
+
+// keepPartial reports whether file, a partial download, is returned to the caller along with err, which it
+// is if err is a *CancelledError, as the caller stopped the download. The file is rewound to its start.
+func keepPartial(file *os.File, err error) bool {
+	var cancelled *CancelledError
+	if file == nil || !errors.As(err, &cancelled) {
+		return false
+	}
+	_, seekErr := file.Seek(0, io.SeekStart)
+	return seekErr == nil
+}
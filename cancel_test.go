@@ -0,0 +1,127 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package braid
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestCancel(t *testing.T) {
+	var filename string = "cancel.bin"
+	content := randomContent(4 << 20)
+	defer os.Remove(filename)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tw := &throttledWriter{ResponseWriter: w, mu: &sync.Mutex{}, bytesPerSec: 256 << 10}
+		http.ServeContent(tw, r, filename, time.Time{}, bytes.NewReader(content))
+	}))
+
+	before := runtime.NumGoroutine()
+
+	br, err := NewRequest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	br.SetJobs(4)
+
+	var cancelled time.Time
+	go func() {
+		for br.Stats().ReadBytes < 256<<10 {
+			time.Sleep(10 * time.Millisecond)
+		}
+		cancelled = time.Now()
+		br.Cancel()
+	}()
+
+	file, err := br.FetchFile(context.Background(), ts.URL, filename)
+	var cancelledErr *CancelledError
+	if !errors.As(err, &cancelledErr) || !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected a *CancelledError matching context.Canceled, got %v", err)
+	}
+	if took := time.Since(cancelled); took > time.Second {
+		t.Fatalf("expected FetchFile to return promptly once cancelled, took %s", took)
+	}
+	if cancelledErr.Bytes < 256<<10 || cancelledErr.Bytes >= int64(len(content)) {
+		t.Fatalf("expected a partial download, got %d of %d bytes", cancelledErr.Bytes, len(content))
+	}
+	if file == nil {
+		t.Fatalf("expected the partial download to be returned")
+	}
+	defer os.Remove(partFilename(filename))
+	got, err := io.ReadAll(file)
+	file.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if file.Name() != partFilename(filename) {
+		t.Fatalf("expected the partial download %s, got %s", partFilename(filename), file.Name())
+	}
+	// each job's range is split evenly, and holds what the job fetched of it
+	var fetched int64
+	for i, stat := range br.JobStats() {
+		start := int64(i) * (1 << 20)
+		if !bytes.Equal(got[start:start+stat.ReadBytes], content[start:start+stat.ReadBytes]) {
+			t.Fatalf("job %d: the %d bytes fetched don't match", i, stat.ReadBytes)
+		}
+		fetched += stat.ReadBytes
+	}
+	if fetched != cancelledErr.Bytes {
+		t.Fatalf("expected the jobs to have fetched %d bytes, got %d", cancelledErr.Bytes, fetched)
+	}
+
+	ts.Close()
+	http.DefaultTransport.(*http.Transport).CloseIdleConnections()
+	deadline := time.Now().Add(5 * time.Second)
+	for runtime.NumGoroutine() > before && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if after := runtime.NumGoroutine(); after > before {
+		t.Fatalf("goroutines leaked: %d before, %d after", before, after)
+	}
+}
+
+func TestCancelReset(t *testing.T) {
+	br, err := NewRequest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	br.cancellable(context.Background())
+
+	// Cancel may be called from a signal handler while the request is being reset
+	started := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		close(started)
+		for i := 0; i < 1000; i++ {
+			br.Cancel()
+		}
+		close(done)
+	}()
+	<-started
+	for i := 0; i < 1000; i++ {
+		br.Reset()
+	}
+	<-done
+}
@@ -22,13 +22,11 @@ import (
 	"fmt"
 	"log"
 	"os"
-	"time"
 
 	"github.com/porjo/braid"
+	"github.com/porjo/braid/braidbar"
 )
 
-type chanChan chan chan struct{}
-
 func main() {
 	var url, filename string
 	var jobs int
@@ -54,33 +52,14 @@ func main() {
 		os.Exit(1)
 	}
 	r.SetJobs(jobs)
-	braid.SetLogger(log.Printf)
-	var quitChan chanChan
-	quitChan = make(chanChan)
-	go Progress(quitChan, r)
+	r.SetLogger(log.Printf)
+	bar := braidbar.New(os.Stdout, r)
+	bar.Start()
 	file, err = r.FetchFile(ctx, url, filename)
+	bar.Stop()
 	if err != nil {
 		fmt.Println(err)
 		os.Exit(1)
 	}
 	file.Close()
-	quit := make(chan struct{})
-	quitChan <- quit
-	<-quit
-}
-
-func Progress(quitChan chanChan, r *braid.Request) {
-	ticker := time.Tick(time.Second)
-	for {
-		select {
-		case <-ticker:
-			stats := r.Stats()
-			fmt.Printf("%+v\n", stats)
-		case ch := <-quitChan:
-			// final newline
-			fmt.Println()
-			close(ch)
-			return
-		}
-	}
 }
@@ -0,0 +1,141 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package braid
+
+import (
+	"context"
+	"sync"
+)
+
+// SetAdaptiveConcurrency sets the download to be split between max jobs, which back off to as few as min
+// connections at once should a server struggle with them. Each time the number of requests set by
+// SetConcurrencyFailureThreshold fail in a way worth retrying, one fewer job may fetch at a time, and the
+// request is retried once a connection is free without counting as a failed attempt, see SetMaxRetries.
+// Other failures, and those once min is reached, are retried as usual. It replaces the number of jobs
+// set by SetJobs.
+func (r *Request) SetAdaptiveConcurrency(min, max int) {
+	if min < 1 {
+		min = 1
+	}
+	r.SetJobs(max)
+	r.adaptiveConcurrency = true
+	r.minConcurrency = min
+}
+
+// SetConcurrencyFailureThreshold sets how many failed requests it takes for adaptive concurrency to
+// let one fewer job fetch at a time, see SetAdaptiveConcurrency. Failures short of the threshold are
+// retried in the same way, without counting as failed attempts. The count starts again after each
+// reduction. The default is 1, a reduction on every failure.
+func (r *Request) SetConcurrencyFailureThreshold(n int) {
+	if n < 1 {
+		n = 1
+	}
+	r.concurrencyThreshold = n
+}
+
+// SetMaxConnsPerHost sets the most requests the jobs may have in flight at once, for servers that refuse
+// connections beyond a limit. The download is still split between the number of jobs set by SetJobs,
+// which take turns to fetch. Requests to mirrors count towards the same limit. Zero (the default) means
+// no limit.
+func (r *Request) SetMaxConnsPerHost(n int) {
+	r.maxConnsPerHost = n
+}
+
+// newConcurrency returns the limit on how many of a download's workers fetch at once, or nil if
+// there is none.
+func (r *Request) newConcurrency(workers int) *concurrencyLimit {
+	if !r.adaptiveConcurrency && r.maxConnsPerHost <= 0 {
+		return nil
+	}
+	limit := workers
+	if r.maxConnsPerHost > 0 && limit > r.maxConnsPerHost {
+		limit = r.maxConnsPerHost
+	}
+	min := limit
+	if r.adaptiveConcurrency {
+		min = r.minConcurrency
+	}
+	return newConcurrencyLimit(min, limit, r.concurrencyThreshold)
+}
+
+// concurrencyLimit limits how many jobs fetch at once, a limit that may be reduced during the download.
+type concurrencyLimit struct {
+	mu     sync.Mutex
+	limit  int
+	min    int
+	active int
+	// threshold is how many failures reduce the limit, counted in failures
+	threshold int
+	failures  int
+	// released is closed, and replaced, when a job stops fetching or the limit changes
+	released chan struct{}
+}
+
+func newConcurrencyLimit(min, limit, threshold int) *concurrencyLimit {
+	if min > limit {
+		min = limit
+	}
+	if threshold < 1 {
+		threshold = 1
+	}
+	return &concurrencyLimit{limit: limit, min: min, threshold: threshold, released: make(chan struct{})}
+}
+
+// acquire waits until the job may fetch, unless ctx is cancelled first. Each successful call must be
+// followed by a call to release.
+func (l *concurrencyLimit) acquire(ctx context.Context) error {
+	for {
+		l.mu.Lock()
+		if l.active < l.limit {
+			l.active++
+			l.mu.Unlock()
+			return nil
+		}
+		released := l.released
+		l.mu.Unlock()
+
+		select {
+		case <-released:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// release lets another job fetch.
+func (l *concurrencyLimit) release() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.active--
+	close(l.released)
+	l.released = make(chan struct{})
+}
+
+// reduce counts a failure, lowering the limit by one once the threshold is reached. It returns the limit
+// and whether it was lowered, or 0 if the limit is already at the minimum, when failures aren't counted.
+func (l *concurrencyLimit) reduce() (int, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.limit <= l.min {
+		return 0, false
+	}
+	l.failures++
+	if l.failures < l.threshold {
+		return l.limit, false
+	}
+	l.failures = 0
+	l.limit--
+	return l.limit, true
+}
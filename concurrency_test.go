@@ -0,0 +1,275 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package braid
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestAdaptiveConcurrency(t *testing.T) {
+	var filename string = "concurrency.bin"
+	content := randomContent(1 << 20)
+	defer os.Remove(filename)
+
+	tests := []struct {
+		name      string
+		threshold int
+		retries   int
+	}{
+		{"every failure", 1, 1},
+		// failures short of the threshold are retried without any retries being allowed
+		{"every third failure", 3, 0},
+	}
+
+	for _, tt := range tests {
+		// the server refuses more than 2 connections at once
+		var mu sync.Mutex
+		var active, most, refused int
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method == "GET" {
+				mu.Lock()
+				if active >= 2 {
+					refused++
+					mu.Unlock()
+					http.Error(w, "too busy", http.StatusServiceUnavailable)
+					return
+				}
+				active++
+				if active > most {
+					most = active
+				}
+				mu.Unlock()
+				defer func() {
+					mu.Lock()
+					active--
+					mu.Unlock()
+				}()
+				w = &throttledWriter{ResponseWriter: w, mu: &sync.Mutex{}, bytesPerSec: 4 << 20}
+			}
+			http.ServeContent(w, r, filename, time.Time{}, bytes.NewReader(content))
+		}))
+
+		br, err := NewRequest()
+		if err != nil {
+			t.Fatal(err)
+		}
+		br.SetAdaptiveConcurrency(1, 5)
+		br.SetConcurrencyFailureThreshold(tt.threshold)
+		br.SetMaxRetries(tt.retries)
+		br.SetRetryBackoff(10 * time.Millisecond)
+		file, err := br.FetchFile(context.Background(), ts.URL, filename)
+		ts.Close()
+		if err != nil {
+			t.Fatalf("%s: %s", tt.name, err)
+		}
+		file.Close()
+
+		got, err := os.ReadFile(filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, content) {
+			t.Fatalf("%s: downloaded content doesn't match", tt.name)
+		}
+		if refused == 0 {
+			t.Fatalf("%s: expected the server to refuse connections at first", tt.name)
+		}
+		if limit := br.concurrency.limit; limit > 2 {
+			t.Fatalf("%s: expected concurrency to back off to at most 2, got %d", tt.name, limit)
+		}
+		if stat := br.JobStats(); len(stat) != 5 {
+			t.Fatalf("%s: expected the download to be split between 5 jobs, got %d", tt.name, len(stat))
+		}
+	}
+}
+
+// releasingWriter calls release before writing the last byte of the response's Content-Length
+type releasingWriter struct {
+	http.ResponseWriter
+	written int64
+	release func()
+}
+
+func (w *releasingWriter) Write(p []byte) (int, error) {
+	length, _ := strconv.ParseInt(w.Header().Get("Content-Length"), 10, 64)
+	if w.written+int64(len(p)) >= length {
+		w.release()
+	}
+	n, err := w.ResponseWriter.Write(p)
+	w.written += int64(n)
+	return n, err
+}
+
+func TestMaxConnsPerHost(t *testing.T) {
+	var filename string = "maxconns.bin"
+	var maxConns int = 2
+	content := randomContent(1 << 20)
+	defer os.Remove(filename)
+
+	// the server refuses connections beyond its limit
+	var mu sync.Mutex
+	var active, most int
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "GET" {
+			mu.Lock()
+			if active >= maxConns {
+				mu.Unlock()
+				http.Error(w, "too many connections", http.StatusServiceUnavailable)
+				return
+			}
+			active++
+			if active > most {
+				most = active
+			}
+			mu.Unlock()
+			var once sync.Once
+			release := func() {
+				once.Do(func() {
+					mu.Lock()
+					active--
+					mu.Unlock()
+				})
+			}
+			defer release()
+			// the connection is released before the client can have read the last byte
+			w = &releasingWriter{ResponseWriter: w, release: release}
+			w = &throttledWriter{ResponseWriter: w, mu: &sync.Mutex{}, bytesPerSec: 4 << 20}
+		}
+		http.ServeContent(w, r, filename, time.Time{}, bytes.NewReader(content))
+	}))
+	defer ts.Close()
+
+	br, err := NewRequest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	br.SetJobs(6)
+	br.SetMaxConnsPerHost(maxConns)
+	file, err := br.FetchFile(context.Background(), ts.URL, filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+
+	got, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, content) {
+		t.Fatalf("downloaded content doesn't match")
+	}
+	mu.Lock()
+	fileMost := most
+	most = 0
+	mu.Unlock()
+	if fileMost != maxConns {
+		t.Fatalf("expected %d connections at once, got %d", maxConns, fileMost)
+	}
+	if stat := br.JobStats(); len(stat) != 6 {
+		t.Fatalf("expected the download to be split between 6 jobs, got %d", len(stat))
+	}
+
+	// ranges are fetched under the same limit
+	var ranges [][2]int64
+	for start := int64(0); start < int64(len(content)); start += 128 << 10 {
+		ranges = append(ranges, [2]int64{start, start + 128<<10})
+	}
+	buf := &bufferAt{b: make([]byte, len(content))}
+	if err = br.FetchRanges(context.Background(), ts.URL, ranges, buf); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf.b, content) {
+		t.Fatalf("fetched ranges don't match")
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if most != maxConns {
+		t.Fatalf("expected %d connections at once fetching ranges, got %d", maxConns, most)
+	}
+}
+
+func TestConcurrencyLimit(t *testing.T) {
+	l := newConcurrencyLimit(1, 2, 1)
+	ctx := context.Background()
+	if err := l.acquire(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.acquire(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// a third job waits for a slot
+	ctx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	if err := l.acquire(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("expected to wait for a slot, got %v", err)
+	}
+
+	if limit, lowered := l.reduce(); limit != 1 || !lowered {
+		t.Fatalf("expected limit to be reduced to 1, got %d", limit)
+	}
+	if limit, _ := l.reduce(); limit != 0 {
+		t.Fatalf("expected limit to stay at the minimum, got %d", limit)
+	}
+
+	// with the limit reduced, one release isn't enough to let another job fetch
+	done := make(chan error)
+	go func() { done <- l.acquire(context.Background()) }()
+	l.release()
+	select {
+	case <-done:
+		t.Fatalf("expected acquire to wait while at the limit")
+	case <-time.After(50 * time.Millisecond):
+	}
+	l.release()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestConcurrencyFailureThreshold(t *testing.T) {
+	br, err := NewRequest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	br.SetAdaptiveConcurrency(1, 4)
+	br.SetConcurrencyFailureThreshold(3)
+	l := br.newConcurrency(4)
+
+	// the limit is lowered by one on every third failure
+	for i, want := range []int{4, 4, 3, 3, 3, 2, 2, 2, 1, 0, 0} {
+		limit, lowered := l.reduce()
+		if limit != want || lowered != (i%3 == 2 && want > 0) {
+			t.Fatalf("failure %d: expected limit %d, got %d (lowered %t)", i+1, want, limit, lowered)
+		}
+	}
+
+	// by default, every failure lowers the limit
+	br.SetConcurrencyFailureThreshold(0)
+	l = br.newConcurrency(4)
+	for _, want := range []int{3, 2, 1, 0} {
+		if limit, lowered := l.reduce(); limit != want || lowered != (want > 0) {
+			t.Fatalf("expected limit %d, got %d (lowered %t)", want, limit, lowered)
+		}
+	}
+}
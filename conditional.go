@@ -0,0 +1,44 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package braid
+
+import (
+	"net/http"
+	"time"
+)
+
+// SetIfModified makes downloads conditional on the resource having changed from a copy already held,
+// with the given ETag and modification time. The request discovering the resource carries them in
+// If-None-Match and If-Modified-Since headers, and if the server responds 304 Not Modified the download
+// fails with ErrNotModified, leaving any existing file untouched. Either may be left empty to be omitted.
+func (r *Request) SetIfModified(etag string, modTime time.Time) {
+	r.ifModified = condition{etag: etag, modTime: modTime}
+}
+
+// condition holds the validators of a copy of the resource, for a request conditional on it having changed.
+type condition struct {
+	etag    string
+	modTime time.Time
+}
+
+// set sets the headers of a request conditional on the resource not matching c.
+func (c condition) set(header http.Header) {
+	if c.etag != "" {
+		header.Set("If-None-Match", c.etag)
+	}
+	if !c.modTime.IsZero() {
+		header.Set("If-Modified-Since", c.modTime.UTC().Format(http.TimeFormat))
+	}
+}
@@ -0,0 +1,99 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package braid
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestIfModified(t *testing.T) {
+	var filename string = "conditional.bin"
+	content := randomContent(1 << 20)
+	existing := []byte("existing")
+	modtime := time.Now().Add(-time.Hour)
+	defer os.Remove(filename)
+
+	var gets int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "GET" {
+			atomic.AddInt32(&gets, 1)
+		}
+		w.Header().Set("ETag", `"v1"`)
+		http.ServeContent(w, r, filename, modtime, bytes.NewReader(content))
+	}))
+	defer ts.Close()
+
+	tests := []struct {
+		name     string
+		etag     string
+		modTime  time.Time
+		eager    bool
+		modified bool
+	}{
+		{"same etag", `"v1"`, time.Time{}, false, false},
+		{"same etag eager", `"v1"`, time.Time{}, true, false},
+		{"same modtime", "", modtime, false, false},
+		{"other etag", `"v0"`, time.Time{}, false, true},
+		{"older modtime", "", modtime.Add(-time.Hour), false, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := os.WriteFile(filename, existing, 0644); err != nil {
+				t.Fatal(err)
+			}
+			atomic.StoreInt32(&gets, 0)
+
+			br, err := NewRequest()
+			if err != nil {
+				t.Fatal(err)
+			}
+			br.SetEagerStart(tt.eager)
+			br.SetIfModified(tt.etag, tt.modTime)
+			file, err := br.FetchFile(context.Background(), ts.URL, filename)
+			expected := content
+			if tt.modified {
+				if err != nil {
+					t.Fatal(err)
+				}
+				file.Close()
+			} else {
+				if !errors.Is(err, ErrNotModified) {
+					t.Fatalf("expected ErrNotModified, got %v", err)
+				}
+				if n := atomic.LoadInt32(&gets); n > 0 && !tt.eager {
+					t.Fatalf("expected no GET requests, got %d", n)
+				}
+				expected = existing
+			}
+
+			got, err := os.ReadFile(filename)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, expected) {
+				t.Fatalf("expected file to be modified: %t", tt.modified)
+			}
+		})
+	}
+}
@@ -0,0 +1,76 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package braid
+
+import (
+	"context"
+	"fmt"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// FetchToDir fetches the resource like FetchFile to a file in dir, named by the filename parameter of
+// the server's Content-Disposition header or, failing that, the last segment of the URL's path. Any
+// directories in the name are dropped, so the file is always created in dir. The name is found by the
+// same request a download starts with, which is made a second time by the download itself. The file's
+// name is given by its Name method.
+func (r *Request) FetchToDir(ctx context.Context, url, dir string) (*os.File, error) {
+	if _, err := r.Probe(ctx, url); err != nil {
+		return nil, err
+	}
+	resolved := url
+	if r.resolvedURL != "" {
+		resolved = r.resolvedURL
+	}
+	name, err := responseFilename(r.header, resolved)
+	if err != nil {
+		return nil, err
+	}
+	return r.FetchFile(ctx, url, filepath.Join(dir, name))
+}
+
+// responseFilename returns the filename given by header's Content-Disposition, falling back to the last
+// segment of the path of rawURL.
+func responseFilename(header http.Header, rawURL string) (string, error) {
+	if _, params, err := mime.ParseMediaType(header.Get("Content-Disposition")); err == nil {
+		if name := safeFilename(params["filename"]); name != "" {
+			return name, nil
+		}
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", err
+	}
+	if name := safeFilename(u.Path); name != "" {
+		return name, nil
+	}
+	return "", fmt.Errorf("no filename in Content-Disposition or URL %s", rawURL)
+}
+
+// safeFilename returns the last element of name, whichever path separator it uses, or "" if that isn't
+// a usable filename.
+func safeFilename(name string) string {
+	name = path.Base(strings.ReplaceAll(name, `\`, "/"))
+	switch name {
+	case ".", "..", "/":
+		return ""
+	}
+	return name
+}
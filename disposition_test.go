@@ -0,0 +1,104 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package braid
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFetchToDir(t *testing.T) {
+	content := randomContent(1 << 20)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/download" {
+			w.Header().Set("Content-Disposition", `attachment; filename="../real.bin"`)
+		}
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(content))
+	}))
+	defer ts.Close()
+
+	tests := []struct {
+		path     string
+		expected string
+	}{
+		{"/download", "real.bin"},
+		{"/files/data.bin", "data.bin"},
+	}
+	for _, tt := range tests {
+		dir := t.TempDir()
+		br, err := NewRequest()
+		if err != nil {
+			t.Fatal(err)
+		}
+		br.SetJobs(4)
+		file, err := br.FetchToDir(context.Background(), ts.URL+tt.path, dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		file.Close()
+
+		if file.Name() != filepath.Join(dir, tt.expected) {
+			t.Fatalf("%s: expected file %s, got %s", tt.path, filepath.Join(dir, tt.expected), file.Name())
+		}
+		got, err := os.ReadFile(file.Name())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, content) {
+			t.Fatalf("%s: downloaded content doesn't match", tt.path)
+		}
+	}
+}
+
+func TestResponseFilename(t *testing.T) {
+	tests := []struct {
+		disposition string
+		url         string
+		expected    string
+	}{
+		{`attachment; filename="report.pdf"`, "http://example.com/download", "report.pdf"},
+		{`attachment; filename*=UTF-8''r%C3%A9sum%C3%A9.txt`, "http://example.com/download", "résumé.txt"},
+		{`attachment; filename="/etc/passwd"`, "http://example.com/download", "passwd"},
+		{`attachment; filename="..\..\evil.exe"`, "http://example.com/download", "evil.exe"},
+		{`attachment; filename=".."`, "http://example.com/files/data.bin", "data.bin"},
+		{"", "http://example.com/files/my%20data.bin?x=1", "my data.bin"},
+		{"", "http://example.com/", ""},
+	}
+	for _, tt := range tests {
+		header := http.Header{}
+		if tt.disposition != "" {
+			header.Set("Content-Disposition", tt.disposition)
+		}
+		name, err := responseFilename(header, tt.url)
+		if tt.expected == "" {
+			if err == nil {
+				t.Fatalf("%q %s: expected an error, got %s", tt.disposition, tt.url, name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if name != tt.expected {
+			t.Fatalf("%q %s: expected %s, got %s", tt.disposition, tt.url, tt.expected, name)
+		}
+	}
+}
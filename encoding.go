@@ -0,0 +1,43 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package braid
+
+import (
+	"net/http"
+	"strings"
+)
+
+// SetAcceptEncoding sets the Accept-Encoding header sent when the server only serves the resource gzip
+// encoded, in which case its length and ranges are those of the encoded bytes, so it can't be split
+// between jobs. A single job then fetches the whole resource, decoding it as it's written. The response
+// is only decoded if it's gzip encoded. By default gzip is asked for.
+func (r *Request) SetAcceptEncoding(encoding string) {
+	r.acceptEncoding = encoding
+}
+
+// gzipEncoded reports whether header describes a gzip encoded response.
+func gzipEncoded(header http.Header) bool {
+	encoding := strings.ToLower(strings.TrimSpace(header.Get("Content-Encoding")))
+	return encoding == "gzip" || encoding == "x-gzip"
+}
+
+// setAcceptEncoding asks for the whole of a resource only served encoded, see SetAcceptEncoding.
+func (r *Request) setAcceptEncoding(header http.Header) {
+	encoding := r.acceptEncoding
+	if encoding == "" {
+		encoding = "gzip"
+	}
+	header.Set("Accept-Encoding", encoding)
+}
@@ -0,0 +1,87 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package braid
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestGzipEncoded(t *testing.T) {
+	var filename string = "gzip.bin"
+	content := bytes.Repeat(randomContent(1<<10), 1<<10)
+	defer os.Remove(filename)
+
+	var compressed bytes.Buffer
+	zw := gzip.NewWriter(&compressed)
+	zw.Write(content)
+	zw.Close()
+
+	for _, eager := range []bool{false, true} {
+		var mu sync.Mutex
+		var gets []string
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method == "GET" {
+				mu.Lock()
+				gets = append(gets, r.Header.Get("Accept-Encoding")+" "+r.Header.Get("Range"))
+				mu.Unlock()
+			}
+			// the resource is only served encoded, its ranges being of the encoded bytes
+			w.Header().Set("Content-Encoding", "gzip")
+			http.ServeContent(w, r, filename, time.Time{}, bytes.NewReader(compressed.Bytes()))
+		}))
+
+		br, err := NewRequest()
+		if err != nil {
+			t.Fatal(err)
+		}
+		br.SetJobs(4)
+		br.SetEagerStart(eager)
+		br.SetAcceptEncoding("gzip, deflate")
+		file, err := br.FetchFile(context.Background(), ts.URL, filename)
+		ts.Close()
+		if err != nil {
+			t.Fatalf("eager %t: %s", eager, err)
+		}
+		file.Close()
+
+		got, err := os.ReadFile(filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, content) {
+			t.Fatalf("eager %t: expected the decoded content", eager)
+		}
+		if stat := br.Stats(); stat.ReadBytes != int64(len(content)) {
+			t.Fatalf("eager %t: expected %d bytes read, got %d", eager, len(content), stat.ReadBytes)
+		}
+
+		// the whole resource is fetched by a single GET, after any eager GET finds it's encoded
+		expected := 1
+		if eager {
+			expected = 2
+		}
+		if len(gets) != expected || gets[len(gets)-1] != "gzip, deflate " {
+			t.Fatalf("eager %t: expected a GET for the whole encoded resource, got %q", eager, gets)
+		}
+	}
+}
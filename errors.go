@@ -0,0 +1,125 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package braid
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// ErrRangesNotSupported is returned when ranges are required but the server responds with the whole resource. See SetRequireRanges.
+var ErrRangesNotSupported = errors.New("server does not support ranges")
+
+// ErrResourceChanged is returned when the resource changes while it is being downloaded.
+var ErrResourceChanged = errors.New("resource changed since download started")
+
+// ErrRetryBudgetExhausted is returned when a download makes more retries than allowed by SetMaxTotalRetries.
+var ErrRetryBudgetExhausted = errors.New("retry budget exhausted")
+
+// ErrNoContentLength is returned when ranges are required but the length of the resource is unknown. See SetRequireRanges.
+var ErrNoContentLength = errors.New("resource has no Content-Length")
+
+// ErrRangeNotSatisfiable is returned when the server responds to a range with 416 Range Not Satisfiable,
+// such as when the resource shrinks during the download. The partial download is discarded.
+var ErrRangeNotSatisfiable = errors.New("range not satisfiable")
+
+// ErrContentRangeMismatch is returned when the Content-Range of a response doesn't match the range requested.
+var ErrContentRangeMismatch = errors.New("Content-Range doesn't match the range requested")
+
+// ErrShortWrite is returned when fewer bytes are written to the file than were read.
+var ErrShortWrite = errors.New("short write")
+
+// ErrShortResponse is returned when a response ends before the end of the range requested.
+var ErrShortResponse = errors.New("response ended early")
+
+// ErrTooSlow is returned when a job reads its response more slowly than the minimum speed set by SetMinSpeed.
+var ErrTooSlow = errors.New("response too slow")
+
+// ErrNotModified is returned when the resource is unchanged from the copy given to SetIfModified.
+var ErrNotModified = errors.New("resource not modified")
+
+// ErrJobTimeout is returned when a job's request takes longer than the timeout set by SetPerJobTimeout.
+var ErrJobTimeout = errors.New("job timed out")
+
+// ErrTooManyRedirects is returned when a request is redirected more times than allowed by SetMaxRedirects.
+var ErrTooManyRedirects = errors.New("too many redirects")
+
+// ErrTooLarge is returned when the resource is larger than allowed by SetMaxSize.
+var ErrTooLarge = errors.New("resource too large")
+
+// ErrShortDownload is returned when a download completes without fetching the whole resource. See SetVerifyLength.
+var ErrShortDownload = errors.New("download incomplete")
+
+// RangeError is returned for each job that fails, giving the range of the resource it left unfetched
+// so that the rest of the download need not be fetched again. End is -1 if the resource's length is
+// unknown. Err is the reason the job failed.
+type RangeError struct {
+	Job   int
+	Start int64
+	End   int64
+	Err   error
+}
+
+func (e *RangeError) Error() string {
+	if e.End < 0 {
+		return fmt.Sprintf("bytes %d- not fetched: %s", e.Start, e.Err)
+	}
+	return fmt.Sprintf("bytes %d-%d not fetched: %s", e.Start, e.End-1, e.Err)
+}
+
+func (e *RangeError) Unwrap() error {
+	return e.Err
+}
+
+// HTTPStatusError is returned when the HEAD request that starts a download is answered with an error
+// status, before any range is requested. It wraps the *StatusError that any other error status is
+// returned as, so either can be found with errors.As.
+type HTTPStatusError struct {
+	Code   int
+	Status string
+	Err    *StatusError
+}
+
+func (e *HTTPStatusError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *HTTPStatusError) Unwrap() error {
+	return e.Err
+}
+
+// StatusError is returned when the server responds with an unexpected status.
+type StatusError struct {
+	URL        string
+	StatusCode int
+	Status     string
+	// RetryAfter is how long the server asked for the request to be delayed by, or zero if it didn't
+	RetryAfter time.Duration
+}
+
+func newStatusError(url string, resp *http.Response) *StatusError {
+	return &StatusError{
+		URL:        url,
+		StatusCode: resp.StatusCode,
+		Status:     resp.Status,
+		RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After"), time.Now()),
+	}
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("%s returned %s", e.URL, e.Status)
+}
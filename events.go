@@ -0,0 +1,164 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package braid
+
+import "sync"
+
+// Event is a notification of a download's progress, sent on the channel returned by Events. It is one
+// of JobStarted, JobProgress, JobDone, JobError or Completed.
+type Event interface {
+	event()
+}
+
+// JobStarted is sent when a job starts fetching its range.
+type JobStarted struct {
+	ID int
+	// Range is the [start,end) range of the resource fetched by the job, with end -1 if the length
+	// of the resource is unknown
+	Range [2]int64
+}
+
+// JobProgress is sent as a job writes to the download.
+type JobProgress struct {
+	ID int
+	// Bytes is the number of bytes of its range the job has written so far
+	Bytes int64
+}
+
+// JobDone is sent when a job has fetched its range.
+type JobDone struct {
+	ID int
+}
+
+// JobError is sent when a job fails, with the *RangeError the download returns for it.
+type JobError struct {
+	ID  int
+	Err error
+}
+
+// Completed is sent when the download succeeds, as the last event before the channel closes.
+type Completed struct {
+	TotalBytes int64
+}
+
+func (JobStarted) event()  {}
+func (JobProgress) event() {}
+func (JobDone) event()     {}
+func (JobError) event()    {}
+func (Completed) event()   {}
+
+// Events returns a channel of events for the next download, which is closed once the download returns.
+// It must be called before the download starts. Jobs never wait for the channel to be read: events are
+// queued until they are received, except that a job's JobProgress is replaced by its next one if that
+// arrives first, so Bytes is always the latest count. The channel must be read until it's closed.
+func (r *Request) Events() <-chan Event {
+	q := &eventQueue{
+		progress: map[int]int{},
+		ready:    make(chan struct{}, 1),
+		out:      make(chan Event),
+	}
+	go q.run()
+	r.events = q
+	return q.out
+}
+
+// eventQueue passes events from the jobs to the channel returned by Events without blocking them.
+type eventQueue struct {
+	mu     sync.Mutex
+	events []Event
+	// progress holds the index in events of each job's JobProgress not yet received
+	progress map[int]int
+	closed   bool
+	ready    chan struct{}
+	out      chan Event
+}
+
+// push queues e to be sent.
+func (q *eventQueue) push(e Event) {
+	q.mu.Lock()
+	if p, ok := e.(JobProgress); ok {
+		if i, ok := q.progress[p.ID]; ok {
+			q.events[i] = p
+			q.mu.Unlock()
+			return
+		}
+		q.progress[p.ID] = len(q.events)
+	}
+	q.events = append(q.events, e)
+	q.mu.Unlock()
+	q.notify()
+}
+
+// close closes the channel once the events already queued have been sent.
+func (q *eventQueue) close() {
+	q.mu.Lock()
+	q.closed = true
+	q.mu.Unlock()
+	q.notify()
+}
+
+func (q *eventQueue) notify() {
+	select {
+	case q.ready <- struct{}{}:
+	default:
+	}
+}
+
+// run sends the queued events in order until the queue is closed.
+func (q *eventQueue) run() {
+	for {
+		q.mu.Lock()
+		if len(q.events) == 0 {
+			closed := q.closed
+			q.mu.Unlock()
+			if closed {
+				close(q.out)
+				return
+			}
+			<-q.ready
+			continue
+		}
+		e := q.events[0]
+		q.events = q.events[1:]
+		for id, i := range q.progress {
+			if i == 0 {
+				delete(q.progress, id)
+			} else {
+				q.progress[id] = i - 1
+			}
+		}
+		q.mu.Unlock()
+		q.out <- e
+	}
+}
+
+// emit sends e on the channel returned by Events, if there is one.
+func (r *Request) emit(e Event) {
+	if r.events != nil {
+		r.events.push(e)
+	}
+}
+
+// closeEvents sends Completed if the download succeeded, then closes the channel returned by Events.
+func (r *Request) closeEvents(err error) {
+	if r.events == nil {
+		return
+	}
+	if err == nil {
+		r.events.push(Completed{TotalBytes: r.Stats().ReadBytes})
+	}
+	r.events.close()
+	r.events = nil
+}
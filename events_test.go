@@ -0,0 +1,120 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package braid
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+func TestEvents(t *testing.T) {
+	var filename string = "events.bin"
+	var jobs int = 4
+	content := randomContent(1 << 20)
+	ts := newContentServer(content)
+	defer ts.Close()
+	defer os.Remove(filename)
+
+	br, err := NewRequest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	br.SetJobs(jobs)
+	events := br.Events()
+	received := make(chan []Event)
+	go func() {
+		var all []Event
+		for e := range events {
+			all = append(all, e)
+		}
+		received <- all
+	}()
+
+	file, err := br.FetchFile(context.Background(), ts.URL, filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+
+	started := map[int][2]int64{}
+	done := map[int]bool{}
+	progress := map[int]int64{}
+	var completed []Completed
+	for _, e := range <-received {
+		switch e := e.(type) {
+		case JobStarted:
+			started[e.ID] = e.Range
+		case JobProgress:
+			if done[e.ID] {
+				t.Fatalf("job %d: progress after it was done", e.ID)
+			}
+			progress[e.ID] = e.Bytes
+		case JobDone:
+			done[e.ID] = true
+		case JobError:
+			t.Fatalf("job %d: unexpected error %v", e.ID, e.Err)
+		case Completed:
+			completed = append(completed, e)
+		}
+	}
+	if len(started) != jobs || len(done) != jobs {
+		t.Fatalf("expected %d jobs started and done, got %d and %d", jobs, len(started), len(done))
+	}
+	for id, rng := range started {
+		if progress[id] != rng[1]-rng[0] {
+			t.Fatalf("job %d: expected progress of %d bytes, got %d", id, rng[1]-rng[0], progress[id])
+		}
+	}
+	if len(completed) != 1 || completed[0].TotalBytes != int64(len(content)) {
+		t.Fatalf("expected one Completed of %d bytes, got %v", len(content), completed)
+	}
+}
+
+func TestEventsError(t *testing.T) {
+	var filename string = "eventserror.bin"
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "failed", http.StatusInternalServerError)
+	}))
+	defer ts.Close()
+	defer os.Remove(filename)
+
+	br, err := NewRequest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	events := br.Events()
+	file, err := br.FetchFile(context.Background(), ts.URL, filename)
+	if err == nil {
+		t.Fatalf("expected error from FetchFile")
+	}
+	file.Close()
+
+	// the channel is closed without a Completed, whichever way the download fails
+	for e := range events {
+		if c, ok := e.(Completed); ok {
+			t.Fatalf("unexpected %v", c)
+		}
+		if e, ok := e.(JobError); ok {
+			var rangeErr *RangeError
+			if !errors.As(e.Err, &rangeErr) {
+				t.Fatalf("expected a *RangeError, got %v", e.Err)
+			}
+		}
+	}
+}
@@ -0,0 +1,57 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package braid_test
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"github.com/porjo/braid"
+)
+
+func ExampleRequest_FetchFile() {
+	b, err := braid.NewRequest()
+	if err != nil {
+		log.Fatal(err)
+	}
+	b.SetJobs(3) // set number of parallel requests. Defaults to 5
+
+	ctx := context.Background()
+	f, err := b.FetchFile(ctx, "https://example.com/file.iso", "file.iso")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer f.Close()
+
+	fmt.Printf("fetched %d bytes\n", b.Stats().ReadBytes)
+}
+
+func ExampleNewRequest() {
+	b, err := braid.NewRequest(
+		braid.WithJobs(3),
+		braid.WithUserAgent("my-downloader/1.0"),
+		braid.WithHeader("Accept", "application/octet-stream"),
+	)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	f, err := b.FetchFile(context.Background(), "https://example.com/file.iso", "file.iso")
+	if err != nil {
+		log.Fatal(err)
+	}
+	f.Close()
+}
@@ -0,0 +1,26 @@
+//go:build test
+// +build test
+
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package braid
+
+// SetFaultFunc sets a function that is called before each block of a job is written, with the
+// job's ID and the offset in the resource the block starts at. If it returns an error the job
+// fails with that error, as though reading the response had failed. This is for deterministic
+// tests of failure handling, and is only available when built with the 'test' tag.
+func (r *Request) SetFaultFunc(f func(jobID int, offset int64) error) {
+	r.faultFunc = f
+}
@@ -0,0 +1,95 @@
+//go:build test
+// +build test
+
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package braid
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+func TestFaultResume(t *testing.T) {
+	var filename string = "fault.bin"
+	content := randomContent(1 << 20)
+	half := len(content) / 2
+
+	v := &versionedServer{content: content, etag: `"v1"`}
+	ts := httptest.NewServer(v)
+	defer ts.Close()
+	defer os.Remove(filename)
+	defer removeResumeState(filename)
+
+	errFault := errors.New("injected fault")
+	br, err := NewRequest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	br.SetJobs(2)
+	br.SetResume(true)
+	br.SetFaultFunc(func(jobID int, offset int64) error {
+		if jobID == 1 && offset >= int64(half+half/2) {
+			return errFault
+		}
+		return nil
+	})
+	file, err := br.FetchFile(context.Background(), ts.URL, filename)
+	if !errors.Is(err, errFault) {
+		t.Fatalf("expected injected fault, got %v", err)
+	}
+	file.Close()
+
+	if _, err = os.Stat(filename); !os.IsNotExist(err) {
+		t.Fatalf("expected %s not to exist until the download completes", filename)
+	}
+	if _, err = os.Stat(partFilename(filename)); err != nil {
+		t.Fatalf("expected partial download to be kept for resuming: %s", err)
+	}
+
+	stats := br.JobStats()
+	if stats[0].ReadBytes != stats[0].TotalBytes {
+		t.Fatalf("expected job 0 to complete, got %+v", stats[0])
+	}
+	if stats[1].ReadBytes < int64(half/2) || stats[1].ReadBytes == stats[1].TotalBytes {
+		t.Fatalf("expected job 1 to stop at the fault, got %+v", stats[1])
+	}
+
+	v.Lock()
+	v.ranges = nil
+	v.Unlock()
+
+	br.SetFaultFunc(nil)
+	file, err = br.FetchFile(context.Background(), ts.URL, filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+
+	got, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, content) {
+		t.Fatalf("resumed content doesn't match")
+	}
+	if len(v.ranges) != 1 {
+		t.Fatalf("expected only the failed job to be resumed, got ranges %v", v.ranges)
+	}
+}
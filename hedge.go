@@ -0,0 +1,173 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package braid
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// hedgeInterval is how often jobs are checked for lagging, see SetHedging
+const hedgeInterval = 100 * time.Millisecond
+
+// SetHedging enables hedged requests for jobs that lag behind the rest of the download. Once every
+// chunk has been started, afterFraction (between 0 and 1) of the resource has been fetched and a job
+// has finished, a job whose progress is behind that of the download as a whole is raced by a second
+// request for the remainder of its range. Each byte is written by whichever request reaches it first,
+// so nothing is written twice, and the job finishes as soon as its range is complete. Each job is
+// hedged at most once, and no more jobs are hedged than have finished so the number of connections
+// doesn't grow. It's disabled by default.
+func (r *Request) SetHedging(enabled bool, afterFraction float64) {
+	r.hedging = enabled
+	r.hedgeAfter = afterFraction
+}
+
+// hedge tracks the range of a job that may be fetched by two requests at once. written is the offset
+// up to which the range has been written by either of them; the mutex is held while writing so that
+// the requests don't overlap. ctx is cancelled once the range is complete, stopping the other request.
+type hedge struct {
+	mu       sync.Mutex
+	start    int64
+	written  int64
+	max      int64
+	hedged   bool
+	finished bool
+
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// addHedge registers the range min to max-1 of the job with jobID, so that it may be hedged.
+func (r *Request) addHedge(ctx context.Context, jobID int, min, max int64) *hedge {
+	h := &hedge{start: min, written: min, max: max}
+	h.ctx, h.cancel = context.WithCancel(ctx)
+	r.mu.Lock()
+	if r.hedges == nil {
+		r.hedges = make(map[int]*hedge)
+	}
+	r.hedges[jobID] = h
+	r.mu.Unlock()
+	return h
+}
+
+// offset returns where the rest of the range starts.
+func (h *hedge) offset() int64 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.written
+}
+
+// complete reports whether the whole range has been written. It's false for a nil hedge.
+func (h *hedge) complete() bool {
+	if h == nil {
+		return false
+	}
+	return h.offset() == h.max
+}
+
+// finish marks the job as finished, so that it's no longer hedged, and stops any request still running.
+func (h *hedge) finish() {
+	h.mu.Lock()
+	h.finished = true
+	h.mu.Unlock()
+	h.cancel()
+}
+
+// fraction returns how much of the range has been written. The mutex must be held.
+func (h *hedge) fraction() float64 {
+	if h.max == h.start {
+		return 1
+	}
+	return float64(h.written-h.start) / float64(h.max-h.start)
+}
+
+// hedgeLagging periodically looks for jobs lagging behind the download and hedges them, until quit
+// is closed. See SetHedging.
+func (r *Request) hedgeLagging(ctx context.Context, queue <-chan queuedChunk, quit chan struct{}) {
+	ticker := time.NewTicker(hedgeInterval)
+	defer ticker.Stop()
+	hedged := 0
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-quit:
+			return
+		case <-ticker.C:
+		}
+		// chunks waiting to be fetched come before hedging those already started
+		if len(queue) > 0 {
+			continue
+		}
+		stat := r.Stats()
+		if stat.TotalBytes <= 0 {
+			continue
+		}
+		progress := float64(stat.ReadBytes) / float64(stat.TotalBytes)
+		if progress < r.hedgeAfter {
+			continue
+		}
+
+		r.mu.Lock()
+		hedges := make(map[int]*hedge, len(r.hedges))
+		for jobID, h := range r.hedges {
+			hedges[jobID] = h
+		}
+		r.mu.Unlock()
+
+		finished := 0
+		for _, h := range hedges {
+			h.mu.Lock()
+			if h.finished {
+				finished++
+			}
+			h.mu.Unlock()
+		}
+		for jobID, h := range hedges {
+			if hedged >= finished {
+				break
+			}
+			h.mu.Lock()
+			if !h.hedged && !h.finished && h.fraction() < progress {
+				// the job can't finish while its mutex is held, so the wait group is still in use
+				h.hedged = true
+				hedged++
+				r.wg.Add(1)
+				go r.hedgeJob(jobID, h)
+			}
+			h.mu.Unlock()
+		}
+	}
+}
+
+// hedgeJob makes a second request for the remainder of the range of the job with jobID. The job
+// reports any failure, so an error here is only logged.
+func (r *Request) hedgeJob(jobID int, h *hedge) {
+	defer r.wg.Done()
+	min := h.offset()
+	r.log(slog.LevelInfo, fmt.Sprintf("job %d: lagging, hedging bytes %d-%d", jobID, min, h.max-1), r.jobAttrs(jobID)...)
+	_, _, err := r.fetchRange(h.ctx, min, h.max, jobID, nil, h)
+	if err == nil || h.complete() {
+		r.log(slog.LevelDebug, fmt.Sprintf("job %d: hedge finished", jobID), r.jobAttrs(jobID)...)
+		h.cancel()
+		return
+	}
+	if h.ctx.Err() == nil {
+		r.log(slog.LevelWarn, fmt.Sprintf("job %d: hedge failed: %s", jobID, err), r.jobAttrs(jobID)...)
+	}
+}
@@ -0,0 +1,97 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package braid
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestHedging(t *testing.T) {
+	var filename string = "hedge.bin"
+	content := randomContent(1 << 20)
+	defer os.Remove(filename)
+
+	var mu sync.Mutex
+	var requests int
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var start, end int
+		fmt.Sscanf(r.Header.Get("Range"), "bytes=%d-%d", &start, &end)
+		if end == 1048575 {
+			mu.Lock()
+			requests++
+			if requests == 1 {
+				// the last range crawls, so would take several seconds without a hedge
+				w = &throttledWriter{ResponseWriter: w, mu: &sync.Mutex{}, bytesPerSec: 64 << 10}
+			}
+			mu.Unlock()
+		}
+		http.ServeContent(w, r, filename, time.Time{}, bytes.NewReader(content))
+	}))
+	defer ts.Close()
+
+	sink := &recordingSink{bytes: map[int]int64{}, retries: map[int]int{}}
+	br, err := NewRequest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	br.SetJobs(4)
+	br.SetHedging(true, 0.5)
+	br.SetMetricsSink(sink)
+	start := time.Now()
+	file, err := br.FetchFile(context.Background(), ts.URL, filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Fatalf("expected the hedge to finish the lagging range, took %s", elapsed)
+	}
+
+	got, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, content) {
+		t.Fatalf("downloaded content doesn't match")
+	}
+
+	mu.Lock()
+	if requests != 2 {
+		t.Fatalf("expected the lagging range to be hedged once, got %d requests", requests)
+	}
+	mu.Unlock()
+
+	// bytes fetched by both requests are written only once
+	sink.Lock()
+	defer sink.Unlock()
+	var written int64
+	for _, n := range sink.bytes {
+		written += n
+	}
+	if written != int64(len(content)) {
+		t.Fatalf("expected %d bytes written, got %d", len(content), written)
+	}
+	if stat := br.Stats(); stat.ReadBytes != int64(len(content)) {
+		t.Fatalf("expected stats to cover %d bytes, got %+v", len(content), stat)
+	}
+}
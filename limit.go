@@ -0,0 +1,95 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package braid
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// SetMaxBytesPerSec caps the rate at which the download is read, across all jobs together. The cap is
+// shared by every download made with the request, including any running concurrently. Zero (the default)
+// means no cap.
+func (r *Request) SetMaxBytesPerSec(n int64) {
+	r.limiter = nil
+	if n > 0 {
+		r.limiter = newRateLimiter(n)
+	}
+}
+
+// rateLimiter is a token bucket shared by jobs, where each token is a byte that may be read.
+type rateLimiter struct {
+	mu          sync.Mutex
+	bytesPerSec float64
+	// burst is the most tokens the bucket holds, and so the most that can be taken at once
+	burst  int64
+	tokens float64
+	last   time.Time
+}
+
+func newRateLimiter(bytesPerSec int64) *rateLimiter {
+	burst := int64(DefaultBufferSize)
+	if bytesPerSec < burst {
+		burst = bytesPerSec
+	}
+	return &rateLimiter{
+		bytesPerSec: float64(bytesPerSec),
+		burst:       burst,
+		tokens:      float64(burst),
+		last:        time.Now(),
+	}
+}
+
+// wait takes n tokens, which must be no more than burst, waiting until they are available or the
+// context is done.
+func (l *rateLimiter) wait(ctx context.Context, n int64) error {
+	l.mu.Lock()
+	l.refill()
+	// tokens are reserved straight away so that waiting jobs are served in turn
+	l.tokens -= float64(n)
+	var delay time.Duration
+	if l.tokens < 0 {
+		delay = time.Duration(-l.tokens / l.bytesPerSec * float64(time.Second))
+	}
+	l.mu.Unlock()
+
+	if err := sleepContext(ctx, delay); err != nil {
+		l.refund(n)
+		return err
+	}
+	return nil
+}
+
+// refund returns n tokens that were taken but not used.
+func (l *rateLimiter) refund(n int64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.refill()
+	l.tokens += float64(n)
+	if l.tokens > float64(l.burst) {
+		l.tokens = float64(l.burst)
+	}
+}
+
+// refill adds the tokens accrued since the last refill. l.mu must be held.
+func (l *rateLimiter) refill() {
+	now := time.Now()
+	l.tokens += now.Sub(l.last).Seconds() * l.bytesPerSec
+	if l.tokens > float64(l.burst) {
+		l.tokens = float64(l.burst)
+	}
+	l.last = now
+}
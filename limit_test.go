@@ -0,0 +1,79 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package braid
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestMaxBytesPerSec(t *testing.T) {
+	var filename string = "limited.bin"
+	var bytesPerSec int64 = 1 << 20
+	content := randomContent(512 << 10)
+	ts := newContentServer(content)
+	defer ts.Close()
+	defer os.Remove(filename)
+
+	br, err := NewRequest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	br.SetJobs(4)
+	br.SetMaxBytesPerSec(bytesPerSec)
+	start := time.Now()
+	file, err := br.FetchFile(context.Background(), ts.URL, filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	elapsed := time.Since(start)
+	file.Close()
+
+	got, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, content) {
+		t.Fatalf("downloaded content doesn't match")
+	}
+
+	// the cap applies to all of the jobs together, less the burst allowed at the start
+	expected := time.Duration(int64(len(content))-br.limiter.burst) * time.Second / time.Duration(bytesPerSec)
+	if elapsed < expected || elapsed > 2*expected+time.Second {
+		t.Fatalf("expected download to take about %s, took %s", expected, elapsed)
+	}
+}
+
+func TestRateLimiterContext(t *testing.T) {
+	l := newRateLimiter(1024)
+	if err := l.wait(context.Background(), l.burst); err != nil {
+		t.Fatal(err)
+	}
+
+	// the bucket is empty, so the next wait outlasts the context
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if err := l.wait(ctx, l.burst); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected context.DeadlineExceeded, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Fatalf("expected wait to end with the context, took %s", elapsed)
+	}
+}
@@ -0,0 +1,62 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package braid
+
+import (
+	"context"
+	"log/slog"
+	"strconv"
+)
+
+// SetLogger sets where the request's log should be sent. The logger set by the package level SetLogger
+// is used by default.
+func (r *Request) SetLogger(l Logger) {
+	if l == nil {
+		r.logger = func(a string, b ...interface{}) {}
+		return
+	}
+	r.logger = prefixLogger(l)
+}
+
+// SetSlogLogger sets a structured logger for the request's log, in addition to the Logger set by SetLogger.
+// Records have the URL being fetched as the attribute 'url' and, for those about a job, the job's ID,
+// byte range and the bytes it has read as 'job', 'range' and 'bytes'. Nil (the default) disables it.
+func (r *Request) SetSlogLogger(l *slog.Logger) {
+	r.slogger = l
+}
+
+// log sends msg to the Logger set by SetLogger and, with attrs, to the request's slog logger.
+func (r *Request) log(level slog.Level, msg string, attrs ...slog.Attr) {
+	r.logger("%s\n", msg)
+	if r.slogger == nil {
+		return
+	}
+	attrs = append([]slog.Attr{slog.String("url", r.url)}, attrs...)
+	r.slogger.LogAttrs(context.Background(), level, msg, attrs...)
+}
+
+// jobAttrs returns the attributes logged with records about the job with jobID.
+func (r *Request) jobAttrs(jobID int) []slog.Attr {
+	r.mu.Lock()
+	rng := r.ranges[jobID]
+	read := r.stats[jobID].read.Load()
+	r.mu.Unlock()
+
+	byteRange := strconv.FormatInt(rng[0], 10) + "-"
+	if rng[1] >= 0 {
+		byteRange += strconv.FormatInt(rng[1]-1, 10)
+	}
+	return []slog.Attr{slog.Int("job", jobID), slog.String("range", byteRange), slog.Int64("bytes", read)}
+}
@@ -0,0 +1,168 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package braid
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// recordHandler captures the attributes of each record logged at any level.
+type recordHandler struct {
+	mu      sync.Mutex
+	records []map[string]string
+}
+
+func (h *recordHandler) Enabled(context.Context, slog.Level) bool { return true }
+
+func (h *recordHandler) Handle(_ context.Context, r slog.Record) error {
+	attrs := map[string]string{"msg": r.Message}
+	r.Attrs(func(a slog.Attr) bool {
+		attrs[a.Key] = a.Value.String()
+		return true
+	})
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.records = append(h.records, attrs)
+	return nil
+}
+
+func (h *recordHandler) WithAttrs([]slog.Attr) slog.Handler { return h }
+
+func (h *recordHandler) WithGroup(string) slog.Handler { return h }
+
+func TestSlogLogger(t *testing.T) {
+	content := randomContent(1 << 20)
+	ts := newContentServer(content)
+	defer ts.Close()
+
+	// concurrent requests log to their own handlers
+	handlers := []*recordHandler{{}, {}}
+	var wg sync.WaitGroup
+	errs := make([]error, len(handlers))
+	for i, h := range handlers {
+		filename := fmt.Sprintf("slog%d.bin", i)
+		defer os.Remove(filename)
+
+		br, err := NewRequest()
+		if err != nil {
+			t.Fatal(err)
+		}
+		br.SetJobs(2)
+		br.SetSlogLogger(slog.New(h))
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			var file *os.File
+			file, errs[i] = br.FetchFile(context.Background(), fmt.Sprintf("%s/file%d", ts.URL, i), filename)
+			file.Close()
+		}(i)
+	}
+	wg.Wait()
+
+	for i, h := range handlers {
+		if errs[i] != nil {
+			t.Fatal(errs[i])
+		}
+		url := fmt.Sprintf("%s/file%d", ts.URL, i)
+		jobs := make(map[string]bool)
+		for _, rec := range h.records {
+			if rec["url"] != url {
+				t.Fatalf("expected url %s, got record %v", url, rec)
+			}
+			if rec["job"] == "" {
+				continue
+			}
+			if rec["range"] == "" || rec["bytes"] == "" {
+				t.Fatalf("expected range and bytes attributes, got record %v", rec)
+			}
+			jobs[rec["job"]] = true
+			if rec["msg"] == "job 1: finished" && (rec["range"] != "524288-1048575" || rec["bytes"] != "524288") {
+				t.Fatalf("unexpected attributes for finished job, got record %v", rec)
+			}
+		}
+		if !jobs["0"] || !jobs["1"] {
+			t.Fatalf("expected records for jobs 0 and 1, got %v", h.records)
+		}
+	}
+}
+
+func TestRequestLogger(t *testing.T) {
+	content := randomContent(1 << 20)
+	ts := newContentServer(content)
+	defer ts.Close()
+
+	// concurrent requests log to their own loggers
+	logs := make([]strings.Builder, 2)
+	var wg sync.WaitGroup
+	errs := make([]error, len(logs))
+	for i := range logs {
+		filename := fmt.Sprintf("logger%d.bin", i)
+		defer os.Remove(filename)
+
+		br, err := NewRequest()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var mu sync.Mutex
+		out := &logs[i]
+		br.SetLogger(func(a string, b ...interface{}) {
+			mu.Lock()
+			defer mu.Unlock()
+			fmt.Fprintf(out, a, b...)
+		})
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			var file *os.File
+			file, errs[i] = br.FetchFile(context.Background(), fmt.Sprintf("%s/file%d", ts.URL, i), filename)
+			file.Close()
+		}(i)
+	}
+	wg.Wait()
+
+	for i := range logs {
+		if errs[i] != nil {
+			t.Fatal(errs[i])
+		}
+		out := logs[i].String()
+		if !strings.Contains(out, fmt.Sprintf("braid: fetching %s/file%d\n", ts.URL, i)) {
+			t.Fatalf("expected request %d to log its own download, got %q", i, out)
+		}
+		if other := fmt.Sprintf("%s/file%d", ts.URL, 1-i); strings.Contains(out, other) {
+			t.Fatalf("expected request %d not to log the download of %s, got %q", i, other, out)
+		}
+	}
+
+	// the package level logger is the default for requests created after it is set
+	var defaultOut strings.Builder
+	SetLogger(func(a string, b ...interface{}) {
+		fmt.Fprintf(&defaultOut, a, b...)
+	})
+	defer SetLogger(func(a string, b ...interface{}) {})
+	br, err := NewRequest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	br.log(slog.LevelInfo, "test")
+	if defaultOut.String() != "braid: test\n" {
+		t.Fatalf("expected the default logger to be used, got %q", defaultOut.String())
+	}
+}
@@ -0,0 +1,104 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package braid
+
+import (
+	"context"
+	"sync"
+)
+
+// Manager runs several downloads, limiting how many run at once.
+type Manager struct {
+	mu        sync.Mutex
+	opts      []Option
+	downloads []download
+}
+
+// download is a download waiting to be run by a Manager.
+type download struct {
+	url      string
+	filename string
+}
+
+// Result is the outcome of a download run by a Manager.
+type Result struct {
+	URL      string
+	Filename string
+	// Bytes is the number of bytes fetched
+	Bytes int64
+	Err   error
+}
+
+// NewManager returns a new manager, which configures the Request of each download with opts.
+func NewManager(opts ...Option) *Manager {
+	return &Manager{opts: opts}
+}
+
+// Add queues a download of url to filename, to be run by the next call to Run.
+func (m *Manager) Add(url, filename string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.downloads = append(m.downloads, download{url: url, filename: filename})
+}
+
+// Run runs the queued downloads with at most maxConcurrent running at once, or all at once if
+// maxConcurrent is zero, returning the result of each in the order they were added. The queue is
+// emptied, so downloads added while Run is in progress are left for the next call.
+func (m *Manager) Run(ctx context.Context, maxConcurrent int) []Result {
+	m.mu.Lock()
+	downloads := m.downloads
+	m.downloads = nil
+	m.mu.Unlock()
+
+	if maxConcurrent <= 0 {
+		maxConcurrent = len(downloads)
+	}
+	sem := make(chan struct{}, maxConcurrent)
+
+	results := make([]Result, len(downloads))
+	var wg sync.WaitGroup
+	for i, d := range downloads {
+		results[i] = Result{URL: d.url, Filename: d.filename}
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+			results[i].Err = ctx.Err()
+			continue
+		}
+
+		wg.Add(1)
+		go func(result *Result) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			result.Bytes, result.Err = m.fetch(ctx, result.URL, result.Filename)
+		}(&results[i])
+	}
+	wg.Wait()
+
+	return results
+}
+
+// fetch downloads url to filename with a new Request, returning the number of bytes fetched.
+func (m *Manager) fetch(ctx context.Context, url, filename string) (int64, error) {
+	r, err := NewRequest(m.opts...)
+	if err != nil {
+		return 0, err
+	}
+	file, err := r.FetchFile(ctx, url, filename)
+	if file != nil {
+		file.Close()
+	}
+	return r.Stats().ReadBytes, err
+}
@@ -0,0 +1,91 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package braid
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestManager(t *testing.T) {
+	var downloads int = 6
+	var maxConcurrent int = 2
+	content := randomContent(64 << 10)
+
+	// a download is active from its first request until its last ends
+	var mu sync.Mutex
+	active := make(map[string]int)
+	var maxActive int
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		active[r.URL.Path]++
+		if len(active) > maxActive {
+			maxActive = len(active)
+		}
+		mu.Unlock()
+
+		time.Sleep(20 * time.Millisecond)
+		http.ServeContent(w, r, "data.bin", time.Time{}, bytes.NewReader(content))
+
+		mu.Lock()
+		if active[r.URL.Path]--; active[r.URL.Path] == 0 {
+			delete(active, r.URL.Path)
+		}
+		mu.Unlock()
+	}))
+	defer ts.Close()
+
+	m := NewManager(WithJobs(1))
+	for i := 0; i < downloads; i++ {
+		filename := fmt.Sprintf("manager%d.bin", i)
+		defer os.Remove(filename)
+		m.Add(fmt.Sprintf("%s/file%d", ts.URL, i), filename)
+	}
+
+	results := m.Run(context.Background(), maxConcurrent)
+	if len(results) != downloads {
+		t.Fatalf("expected %d results, got %d", downloads, len(results))
+	}
+	for i, res := range results {
+		if res.Err != nil {
+			t.Fatalf("%s: %s", res.URL, res.Err)
+		}
+		if res.Filename != fmt.Sprintf("manager%d.bin", i) || res.Bytes != int64(len(content)) {
+			t.Fatalf("unexpected result %+v", res)
+		}
+		got, err := os.ReadFile(res.Filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, content) {
+			t.Fatalf("%s: downloaded content doesn't match", res.Filename)
+		}
+	}
+	if maxActive > maxConcurrent {
+		t.Fatalf("expected at most %d downloads at once, got %d", maxConcurrent, maxActive)
+	}
+
+	// the queue is emptied by Run
+	if results = m.Run(context.Background(), maxConcurrent); len(results) != 0 {
+		t.Fatalf("expected no results from an empty queue, got %d", len(results))
+	}
+}
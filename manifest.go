@@ -0,0 +1,72 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package braid
+
+import (
+	"encoding/hex"
+	"time"
+)
+
+// Manifest is a record of what was downloaded, suitable for serializing to JSON for provenance.
+type Manifest struct {
+	URL string `json:"url"`
+	// ResolvedURL is the URL after following any redirects
+	ResolvedURL string          `json:"resolved_url"`
+	Size        int64           `json:"size"`
+	ETag        string          `json:"etag,omitempty"`
+	Started     time.Time       `json:"started"`
+	Finished    time.Time       `json:"finished"`
+	Chunks      []ManifestChunk `json:"chunks"`
+	// Digest is the hex encoded digest of the download, if one was computed. See SetDigest.
+	Digest string `json:"digest,omitempty"`
+}
+
+// ManifestChunk records the byte range [Start,End) fetched by one job and how many bytes it read.
+type ManifestChunk struct {
+	Start int64 `json:"start"`
+	End   int64 `json:"end"`
+	Bytes int64 `json:"bytes"`
+}
+
+// Manifest returns a record of the last download. It is thread safe and can be called from a goroutine,
+// though Finished and Digest are only set once the download completes.
+func (r *Request) Manifest() Manifest {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	m := Manifest{
+		URL:         r.url,
+		ResolvedURL: r.resolvedURL,
+		Size:        r.length,
+		Started:     r.started,
+		Finished:    r.finished,
+		Chunks:      make([]ManifestChunk, len(r.stats)),
+	}
+	if r.header != nil {
+		m.ETag = r.header.Get("ETag")
+	}
+	for i, s := range r.stats {
+		m.Chunks[i] = ManifestChunk{
+			Start: r.ranges[i][0],
+			End:   r.ranges[i][1],
+			Bytes: s.read.Load(),
+		}
+	}
+	if r.digest != nil {
+		m.Digest = hex.EncodeToString(r.digest)
+	}
+
+	return m
+}
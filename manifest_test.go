@@ -0,0 +1,90 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package braid
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestManifest(t *testing.T) {
+	var filename string = "manifest.bin"
+	var etag string = `"v1"`
+	var jobs int = 3
+	content := randomContent(1<<20 + 1)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", etag)
+		http.ServeContent(w, r, filename, time.Time{}, bytes.NewReader(content))
+	}))
+	defer ts.Close()
+	defer os.Remove(filename)
+
+	br, err := NewRequest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	br.SetJobs(jobs)
+	br.SetDigest(sha256.New)
+	file, err := br.FetchFile(context.Background(), ts.URL, filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+
+	m := br.Manifest()
+	if m.URL != ts.URL || m.ResolvedURL != ts.URL {
+		t.Fatalf("unexpected manifest URLs %s, %s", m.URL, m.ResolvedURL)
+	}
+	if m.Size != int64(len(content)) {
+		t.Fatalf("expected manifest size %d, got %d", len(content), m.Size)
+	}
+	if m.ETag != etag {
+		t.Fatalf("expected manifest ETag %s, got %s", etag, m.ETag)
+	}
+	if m.Finished.Before(m.Started) || m.Started.IsZero() {
+		t.Fatalf("unexpected manifest timestamps %s, %s", m.Started, m.Finished)
+	}
+	sum := sha256.Sum256(content)
+	if m.Digest != hex.EncodeToString(sum[:]) {
+		t.Fatalf("unexpected manifest digest %s", m.Digest)
+	}
+
+	if len(m.Chunks) != jobs {
+		t.Fatalf("expected %d chunks, got %d", jobs, len(m.Chunks))
+	}
+	var offset int64
+	for _, c := range m.Chunks {
+		if c.Start != offset || c.Bytes != c.End-c.Start {
+			t.Fatalf("unexpected chunk %+v", c)
+		}
+		offset = c.End
+	}
+	if offset != m.Size {
+		t.Fatalf("chunks cover %d bytes, expected %d", offset, m.Size)
+	}
+
+	if _, err := json.Marshal(m); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,37 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package braid
+
+import (
+	"time"
+)
+
+// MetricsSink receives counts of a download's activity as it happens, for feeding to a metrics system.
+// Its methods are called from the jobs' goroutines, so must be safe for concurrent use, and should
+// return quickly as the jobs wait on them.
+type MetricsSink interface {
+	// IncBytes is called with the number of bytes job has written to the download.
+	IncBytes(job int, n int64)
+	// IncRetry is called each time job retries a failed request.
+	IncRetry(job int)
+	// ObserveTTFB is called with the time job waited for the response headers to a request.
+	ObserveTTFB(job int, d time.Duration)
+}
+
+// SetMetricsSink sets the sink that receives counts of the download's activity. Nil, the default,
+// disables metrics.
+func (r *Request) SetMetricsSink(sink MetricsSink) {
+	r.metrics = sink
+}
@@ -0,0 +1,111 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package braid
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recordingSink is a MetricsSink that totals what it receives
+type recordingSink struct {
+	sync.Mutex
+	bytes   map[int]int64
+	retries map[int]int
+	ttfbs   int
+}
+
+func (s *recordingSink) IncBytes(job int, n int64) {
+	s.Lock()
+	defer s.Unlock()
+	s.bytes[job] += n
+}
+
+func (s *recordingSink) IncRetry(job int) {
+	s.Lock()
+	defer s.Unlock()
+	s.retries[job]++
+}
+
+func (s *recordingSink) ObserveTTFB(job int, d time.Duration) {
+	s.Lock()
+	defer s.Unlock()
+	s.ttfbs++
+}
+
+func TestMetricsSink(t *testing.T) {
+	var filename string = "metrics.bin"
+	content := randomContent(1 << 20)
+	defer os.Remove(filename)
+
+	var mu sync.Mutex
+	ranges := map[string]int{}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		rng := r.Header.Get("Range")
+		ranges[rng]++
+		attempt := ranges[rng]
+		mu.Unlock()
+
+		switch {
+		case rng == "bytes=262144-524287" && attempt == 1:
+			http.Error(w, "try again", http.StatusServiceUnavailable)
+			return
+		case rng == "bytes=524288-786431" && attempt == 1:
+			w = &truncatingWriter{ResponseWriter: w, limit: 100000}
+		}
+		http.ServeContent(w, r, filename, time.Time{}, bytes.NewReader(content))
+	}))
+	defer ts.Close()
+
+	sink := &recordingSink{bytes: map[int]int64{}, retries: map[int]int{}}
+	br, err := NewRequest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	br.SetJobs(4)
+	br.SetMaxRetries(2)
+	br.SetRetryBackoff(10 * time.Millisecond)
+	br.SetMetricsSink(sink)
+	file, err := br.FetchFile(context.Background(), ts.URL, filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+
+	var total int64
+	for job, n := range sink.bytes {
+		if n != br.JobStats()[job].TotalBytes {
+			t.Fatalf("expected job %d to count %d bytes, got %d", job, br.JobStats()[job].TotalBytes, n)
+		}
+		total += n
+	}
+	if total != int64(len(content)) {
+		t.Fatalf("expected %d bytes counted, got %d", len(content), total)
+	}
+	if len(sink.retries) != 2 || sink.retries[1] != 1 || sink.retries[2] != 1 {
+		t.Fatalf("expected a retry each by jobs 1 and 2, got %v", sink.retries)
+	}
+	// each job's successful response, and the one that dropped part way through
+	if sink.ttfbs != 5 {
+		t.Fatalf("expected 5 responses observed, got %d", sink.ttfbs)
+	}
+}
@@ -0,0 +1,231 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package braid
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// DefaultMirrorCooldown is how long a mirror refusing requests is quarantined for by default.
+const DefaultMirrorCooldown = time.Minute
+
+// maxMirrorAttempts limits how many times each mirror is tried for a single chunk.
+const maxMirrorAttempts = 2
+
+// SetMirrors sets additional URLs serving the same resource. Before downloading, each mirror is checked
+// to answer, support ranges and serve a resource of the same length, and any that don't are left out.
+// Jobs are spread across the URL passed to FetchFile and the mirrors in turn at first, then in proportion
+// to the speed each has shown, so that faster mirrors take on more of the download. A job that fails is
+// retried on a different mirror where there is one.
+func (r *Request) SetMirrors(urls ...string) {
+	r.mirrors = urls
+}
+
+// SetMirrorCooldown sets how long a mirror is quarantined for after responding with 403 Forbidden
+// or 429 Too Many Requests. Chunks assigned to a quarantined mirror are sent to the remaining mirrors
+// until the cooldown has passed, after which the mirror is tried again. DefaultMirrorCooldown is used by default.
+func (r *Request) SetMirrorCooldown(d time.Duration) {
+	r.mirrorCooldown = d
+}
+
+// mirrorSet tracks which mirrors are quarantined, and how fast and busy each is.
+type mirrorSet struct {
+	mu       sync.Mutex
+	urls     []string
+	cooldown time.Duration
+	// blocked holds the time each quarantined mirror may be used again
+	blocked map[string]time.Time
+	// rates holds the bytes per second each mirror has served, and active its requests in progress
+	rates  map[string]float64
+	active map[string]int
+	// failed holds the mirror each job's last request failed on
+	failed map[int]string
+}
+
+func newMirrorSet(urls []string, cooldown time.Duration) *mirrorSet {
+	return &mirrorSet{
+		urls:     urls,
+		cooldown: cooldown,
+		blocked:  make(map[string]time.Time),
+		rates:    make(map[string]float64),
+		active:   make(map[string]int),
+		failed:   make(map[int]string),
+	}
+}
+
+// get returns the mirror job should use, skipping quarantined mirrors and, if there is another, the
+// mirror the job last failed on, see choose. If all mirrors are quarantined, it waits for the first
+// cooldown to pass. The request made to the mirror must be reported with done.
+func (m *mirrorSet) get(ctx context.Context, jobID int) (string, error) {
+	for {
+		m.mu.Lock()
+		now := time.Now()
+		var wait time.Duration
+		var usable []string
+		for i := range m.urls {
+			url := m.urls[(jobID+i)%len(m.urls)]
+			until, ok := m.blocked[url]
+			if !ok || !now.Before(until) {
+				usable = append(usable, url)
+				continue
+			}
+			if wait == 0 || until.Sub(now) < wait {
+				wait = until.Sub(now)
+			}
+		}
+		if len(usable) > 0 {
+			url := m.choose(usable, m.failed[jobID])
+			m.active[url]++
+			m.mu.Unlock()
+			return url, nil
+		}
+		m.mu.Unlock()
+
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return "", ctx.Err()
+		}
+	}
+}
+
+// choose returns the mirror of usable, other than failed if possible, that would serve another request
+// soonest given its speed and the requests it has in progress. A mirror whose speed is unknown is given
+// one request at a time until it has been measured, and if no speed is known the first is used.
+func (m *mirrorSet) choose(usable []string, failed string) string {
+	if len(usable) > 1 && failed != "" {
+		for i, url := range usable {
+			if url == failed {
+				usable = append(usable[:i:i], usable[i+1:]...)
+				break
+			}
+		}
+	}
+	for _, url := range usable {
+		if _, ok := m.rates[url]; !ok && m.active[url] == 0 {
+			return url
+		}
+	}
+	best := usable[0]
+	var bestTime float64
+	for _, url := range usable {
+		rate, ok := m.rates[url]
+		if !ok {
+			continue
+		}
+		if t := float64(m.active[url]+1) / rate; bestTime == 0 || t < bestTime {
+			best, bestTime = url, t
+		}
+	}
+	return best
+}
+
+// done reports a request by job to url that read n bytes in d, and whether it failed.
+func (m *mirrorSet) done(jobID int, url string, n int64, d time.Duration, failed bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.active[url]--
+	if n > 0 && d > 0 {
+		rate := float64(n) / d.Seconds()
+		if last, ok := m.rates[url]; ok {
+			// recent requests count for more, as a mirror's speed may change
+			rate = (rate + last) / 2
+		}
+		m.rates[url] = rate
+	}
+	if failed {
+		m.failed[jobID] = url
+	} else {
+		delete(m.failed, jobID)
+	}
+}
+
+// remove stops url being used.
+func (m *mirrorSet) remove(url string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i, u := range m.urls {
+		if u == url {
+			m.urls = append(m.urls[:i:i], m.urls[i+1:]...)
+			return
+		}
+	}
+}
+
+// resolve replaces the primary URL with final, the URL its redirects led to.
+func (m *mirrorSet) resolve(final string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.urls[0] = final
+}
+
+// block quarantines url for the cooldown period.
+func (m *mirrorSet) block(url string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.blocked[url] = time.Now().Add(m.cooldown)
+}
+
+// checkMirrors removes the mirrors that don't support ranges or serve a resource of length bytes,
+// checking them all at once with a request for the first byte. A mirror that fails to answer, or
+// answers with anything but the byte, is removed too.
+func (r *Request) checkMirrors(ctx context.Context, length int64) {
+	var wg sync.WaitGroup
+	for _, url := range r.mirrorSet.urls[1:] {
+		wg.Add(1)
+		go func(url string) {
+			defer wg.Done()
+			if err := r.checkMirror(ctx, url, length); err != nil {
+				r.log(slog.LevelWarn, fmt.Sprintf("not using mirror %s: %s", url, err), slog.String("mirror", url))
+				r.mirrorSet.remove(url)
+			}
+		}(url)
+	}
+	wg.Wait()
+}
+
+// checkMirror returns an error if url doesn't support ranges or serves a resource of other than length bytes.
+func (r *Request) checkMirror(ctx context.Context, url string, length int64) error {
+	req, err := r.newHTTPRequest(ctx, "GET", url)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Range", "bytes=0-0")
+	resp, err := r.do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return ErrRangesNotSupported
+	case http.StatusPartialContent:
+	default:
+		return newStatusError(url, resp)
+	}
+	_, _, mirrorLength, err := parseContentRange(resp.Header.Get("Content-Range"))
+	if err != nil {
+		return err
+	}
+	if mirrorLength != length {
+		return fmt.Errorf("length %d doesn't match %d", mirrorLength, length)
+	}
+	return nil
+}
@@ -0,0 +1,365 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package braid
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestMirrorCooldown(t *testing.T) {
+	var filename string = "mirror.bin"
+	content := randomContent(1 << 20)
+
+	ts := newContentServer(content)
+	defer ts.Close()
+
+	// blocked passes the check of its first byte, then refuses the jobs
+	var blockedHits int32
+	blocked := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Range") == "bytes=0-0" {
+			http.ServeContent(w, r, filename, time.Time{}, bytes.NewReader(content))
+			return
+		}
+		atomic.AddInt32(&blockedHits, 1)
+		http.Error(w, "slow down", http.StatusTooManyRequests)
+	}))
+	defer blocked.Close()
+	defer os.Remove(filename)
+
+	br, err := NewRequest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	br.SetJobs(4)
+	br.SetMirrors(blocked.URL)
+	file, err := br.FetchFile(context.Background(), ts.URL, filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+
+	if atomic.LoadInt32(&blockedHits) == 0 {
+		t.Fatalf("expected blocked mirror to be tried")
+	}
+	got, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, content) {
+		t.Fatalf("downloaded content doesn't match")
+	}
+}
+
+func TestMaxTotalRetries(t *testing.T) {
+	var filename string = "retries.bin"
+	content := randomContent(1 << 20)
+
+	ts := newContentServer(content)
+	defer ts.Close()
+	defer os.Remove(filename)
+
+	// blocked serves HEAD requests and the check of a mirror's first byte, but refuses the jobs
+	blocked := make([]string, 2)
+	for i := range blocked {
+		s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method == "HEAD" || r.Header.Get("Range") == "bytes=0-0" {
+				http.ServeContent(w, r, filename, time.Time{}, bytes.NewReader(content))
+				return
+			}
+			http.Error(w, "slow down", http.StatusTooManyRequests)
+		}))
+		defer s.Close()
+		blocked[i] = s.URL
+	}
+
+	tests := []struct {
+		name    string
+		url     string
+		mirrors []string
+		budget  int
+		fail    bool
+	}{
+		{"within budget", ts.URL, blocked[:1], 10, false},
+		{"exhausted", blocked[0], blocked[1:], 3, true},
+	}
+
+	for _, tt := range tests {
+		br, err := NewRequest()
+		if err != nil {
+			t.Fatal(err)
+		}
+		br.SetJobs(2)
+		br.SetMirrors(tt.mirrors...)
+		br.SetMirrorCooldown(10 * time.Millisecond)
+		br.SetMaxTotalRetries(tt.budget)
+		file, err := br.FetchFile(context.Background(), tt.url, filename)
+		if tt.fail && !errors.Is(err, ErrRetryBudgetExhausted) {
+			t.Fatalf("%s: expected ErrRetryBudgetExhausted, got %v", tt.name, err)
+		}
+		if !tt.fail && err != nil {
+			t.Fatalf("%s: %s", tt.name, err)
+		}
+		file.Close()
+	}
+}
+
+func TestMirrorSetCooldown(t *testing.T) {
+	var cooldown time.Duration = 100 * time.Millisecond
+	m := newMirrorSet([]string{"a", "b"}, cooldown)
+
+	m.block("a")
+	url, err := m.get(context.Background(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if url != "b" {
+		t.Fatalf("expected quarantined mirror to be skipped, got %s", url)
+	}
+
+	// with every mirror quarantined, get waits for the cooldown to pass
+	m.block("b")
+	start := time.Now()
+	url, err = m.get(context.Background(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if url != "a" {
+		t.Fatalf("expected mirror a after its cooldown, got %s", url)
+	}
+	if elapsed := time.Since(start); elapsed < cooldown/2 {
+		t.Fatalf("expected to wait for cooldown, waited %s", elapsed)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	m.block("a")
+	m.block("b")
+	if _, err = m.get(ctx, 0); err != context.Canceled {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+}
+
+// countingServer serves content, counting the bytes it serves to ranged GET requests other than the
+// first byte, and throttling them if bytesPerSec is set. If fail is set every such request is refused.
+func countingServer(content []byte, bytesPerSec int, fail bool, served *int64) *httptest.Server {
+	mu := &sync.Mutex{}
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if rng := r.Header.Get("Range"); r.Method == "GET" && rng != "" && rng != "bytes=0-0" {
+			if fail {
+				http.Error(w, "try again", http.StatusServiceUnavailable)
+				return
+			}
+			var first, last int64
+			fmt.Sscanf(rng, "bytes=%d-%d", &first, &last)
+			atomic.AddInt64(served, last-first+1)
+			if bytesPerSec > 0 {
+				w = &throttledWriter{ResponseWriter: w, mu: mu, bytesPerSec: bytesPerSec}
+			}
+		}
+		http.ServeContent(w, r, "mirror.bin", time.Time{}, bytes.NewReader(content))
+	}))
+}
+
+func TestMirrorsFastest(t *testing.T) {
+	var filename string = "mirror.bin"
+	content := randomContent(1 << 20)
+	defer os.Remove(filename)
+
+	var fastServed, slowServed int64
+	fast := countingServer(content, 0, false, &fastServed)
+	defer fast.Close()
+	slow := countingServer(content, 256<<10, false, &slowServed)
+	defer slow.Close()
+
+	br, err := NewRequest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	br.SetJobs(4)
+	br.SetChunkSize(64 << 10)
+	br.SetMirrors(slow.URL)
+	file, err := br.FetchFile(context.Background(), fast.URL, filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+
+	got, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, content) {
+		t.Fatalf("downloaded content doesn't match")
+	}
+	if slowServed == 0 || fastServed <= slowServed {
+		t.Fatalf("expected both mirrors to be used, the fast one most, got fast %d slow %d bytes", fastServed, slowServed)
+	}
+}
+
+func TestMirrorsInconsistent(t *testing.T) {
+	var filename string = "mirror.bin"
+	content := randomContent(1 << 20)
+	defer os.Remove(filename)
+
+	var served, otherServed int64
+	ts := countingServer(content, 0, false, &served)
+	defer ts.Close()
+	other := countingServer(content[:len(content)-1], 0, false, &otherServed)
+	defer other.Close()
+
+	br, err := NewRequest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	br.SetJobs(4)
+	br.SetMirrors(other.URL)
+	file, err := br.FetchFile(context.Background(), ts.URL, filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+
+	got, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, content) {
+		t.Fatalf("downloaded content doesn't match")
+	}
+	if otherServed != 0 {
+		t.Fatalf("expected mirror of a different length not to be used, it served %d bytes", otherServed)
+	}
+}
+
+func TestMirrorsUnavailable(t *testing.T) {
+	var filename string = "mirror.bin"
+	content := randomContent(1 << 20)
+	defer os.Remove(filename)
+
+	var served int64
+	ts := countingServer(content, 0, false, &served)
+	defer ts.Close()
+	var missingHits int32
+	missing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&missingHits, 1)
+		http.NotFound(w, r)
+	}))
+	defer missing.Close()
+	closed := httptest.NewServer(http.NotFoundHandler())
+	closed.Close()
+
+	br, err := NewRequest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	br.SetJobs(4)
+	br.SetMirrors(missing.URL, closed.URL)
+	file, err := br.FetchFile(context.Background(), ts.URL, filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+
+	got, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, content) {
+		t.Fatalf("downloaded content doesn't match")
+	}
+	if hits := atomic.LoadInt32(&missingHits); hits != 1 {
+		t.Fatalf("expected the missing mirror to be left out after its check, it had %d requests", hits)
+	}
+	if served != int64(len(content)) {
+		t.Fatalf("expected every chunk to be fetched from the URL, it served %d bytes", served)
+	}
+}
+
+func TestMirrorsFailover(t *testing.T) {
+	var filename string = "mirror.bin"
+	content := randomContent(1 << 20)
+	defer os.Remove(filename)
+
+	var served, failingServed int64
+	ts := countingServer(content, 0, false, &served)
+	defer ts.Close()
+	failing := countingServer(content, 0, true, &failingServed)
+	defer failing.Close()
+
+	br, err := NewRequest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	br.SetJobs(4)
+	br.SetMaxRetries(1)
+	br.SetRetryBackoff(10 * time.Millisecond)
+	br.SetMirrors(failing.URL)
+	file, err := br.FetchFile(context.Background(), ts.URL, filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+
+	got, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, content) {
+		t.Fatalf("downloaded content doesn't match")
+	}
+	if served != int64(len(content)) {
+		t.Fatalf("expected failed chunks to be fetched from the other mirror, it served %d bytes", served)
+	}
+}
+
+func TestMirrorSetChoose(t *testing.T) {
+	m := newMirrorSet([]string{"a", "b"}, time.Minute)
+
+	// mirrors are used in turn until their speeds are known
+	if url, _ := m.get(context.Background(), 0); url != "a" {
+		t.Fatalf("expected mirror a, got %s", url)
+	}
+	if url, _ := m.get(context.Background(), 1); url != "b" {
+		t.Fatalf("expected mirror b, got %s", url)
+	}
+	m.done(0, "a", 1000, time.Second, false)
+	m.done(1, "b", 4000, time.Second, false)
+
+	// b is four times as fast, so takes requests until it has four in progress
+	for i := 0; i < 4; i++ {
+		if url, _ := m.get(context.Background(), i); url != "b" {
+			t.Fatalf("request %d: expected faster mirror b, got %s", i, url)
+		}
+	}
+	if url, _ := m.get(context.Background(), 0); url != "a" {
+		t.Fatalf("expected busy mirror b to be passed over, got %s", url)
+	}
+
+	// a job isn't given the mirror it failed on
+	m.done(2, "b", 0, 0, true)
+	if url, _ := m.get(context.Background(), 2); url != "a" {
+		t.Fatalf("expected job to move off the mirror it failed on, got %s", url)
+	}
+}
@@ -0,0 +1,80 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package braid
+
+import (
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+)
+
+// byteRangesBoundary returns the boundary of a multipart/byteranges response, or "" if resp isn't one.
+func byteRangesBoundary(resp *http.Response) string {
+	mediaType, params, err := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/byteranges" {
+		return ""
+	}
+	return params["boundary"]
+}
+
+// byteRangesReader reads the parts of a multipart/byteranges response as one stream of bytes. The parts
+// must follow on from each other, starting at the beginning of the range requested, so that each is
+// written to the offset given by its Content-Range.
+type byteRangesReader struct {
+	r         *Request
+	mr        *multipart.Reader
+	part      *multipart.Part
+	offset    int64
+	max       int64
+	remaining int64
+}
+
+// newByteRangesReader returns a reader of the parts of body, a response to a request for bytes min to
+// max-1, or to the end if max is negative.
+func (r *Request) newByteRangesReader(body io.Reader, boundary string, min, max int64) *byteRangesReader {
+	return &byteRangesReader{r: r, mr: multipart.NewReader(body, boundary), offset: min, max: max}
+}
+
+func (b *byteRangesReader) Read(p []byte) (int, error) {
+	for b.remaining == 0 {
+		part, err := b.mr.NextPart()
+		if err != nil {
+			return 0, err
+		}
+		contentRange := part.Header.Get("Content-Range")
+		if err = b.r.checkContentRange(contentRange, b.offset, b.max); err != nil {
+			return 0, fmt.Errorf("multipart/byteranges part: %w", err)
+		}
+		first, last, _, _ := parseContentRange(contentRange)
+		b.part = part
+		b.remaining = last - first + 1
+	}
+
+	if int64(len(p)) > b.remaining {
+		p = p[:b.remaining]
+	}
+	n, err := b.part.Read(p)
+	b.offset += int64(n)
+	b.remaining -= int64(n)
+	if err == io.EOF {
+		if b.remaining > 0 {
+			return n, io.ErrUnexpectedEOF
+		}
+		err = nil
+	}
+	return n, err
+}
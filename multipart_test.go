@@ -0,0 +1,120 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package braid
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// byteRangesServer answers range requests with a multipart/byteranges body, splitting each range into
+// two parts. If reversed is set the parts are sent in the wrong order.
+func byteRangesServer(content []byte, reversed bool) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Accept-Ranges", "bytes")
+		rng := r.Header.Get("Range")
+		if rng == "" {
+			w.Header().Set("Content-Length", strconv.Itoa(len(content)))
+			if r.Method == "GET" {
+				w.Write(content)
+			}
+			return
+		}
+		firstStr, lastStr, _ := strings.Cut(strings.TrimPrefix(rng, "bytes="), "-")
+		first, _ := strconv.Atoi(firstStr)
+		last := len(content) - 1
+		if lastStr != "" {
+			last, _ = strconv.Atoi(lastStr)
+		}
+
+		mid := first + (last-first)/2
+		parts := [][2]int{{first, mid}, {mid + 1, last}}
+		if reversed {
+			parts[0], parts[1] = parts[1], parts[0]
+		}
+		var buf bytes.Buffer
+		mw := multipart.NewWriter(&buf)
+		for _, p := range parts {
+			if p[1] < p[0] {
+				continue
+			}
+			pw, _ := mw.CreatePart(textproto.MIMEHeader{
+				"Content-Type":  {"application/octet-stream"},
+				"Content-Range": {fmt.Sprintf("bytes %d-%d/%d", p[0], p[1], len(content))},
+			})
+			pw.Write(content[p[0] : p[1]+1])
+		}
+		mw.Close()
+		w.Header().Set("Content-Type", "multipart/byteranges; boundary="+mw.Boundary())
+		w.WriteHeader(http.StatusPartialContent)
+		w.Write(buf.Bytes())
+	}))
+}
+
+func TestByteRanges(t *testing.T) {
+	var filename string = "byteranges.bin"
+	content := randomContent(1<<20 + 5)
+	ts := byteRangesServer(content, false)
+	defer ts.Close()
+	defer os.Remove(filename)
+
+	br, err := NewRequest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	br.SetJobs(3)
+	file, err := br.FetchFile(context.Background(), ts.URL, filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+
+	got, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, content) {
+		t.Fatalf("downloaded content doesn't match")
+	}
+}
+
+func TestByteRangesOutOfOrder(t *testing.T) {
+	var filename string = "byteranges.bin"
+	content := randomContent(1 << 20)
+	ts := byteRangesServer(content, true)
+	defer ts.Close()
+	defer os.Remove(filename)
+
+	br, err := NewRequest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	br.SetJobs(2)
+	br.SetMaxRetries(0)
+	_, err = br.FetchFile(context.Background(), ts.URL, filename)
+	if !errors.Is(err, ErrContentRangeMismatch) {
+		t.Fatalf("expected ErrContentRangeMismatch, got %v", err)
+	}
+}
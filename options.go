@@ -0,0 +1,49 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package braid
+
+import "net/http"
+
+// Option configures a Request when it is created by NewRequest. Each option has the same effect
+// as the setter it is named after.
+type Option func(*Request)
+
+// WithJobs sets the number of parallel requests that will be made. See SetJobs.
+func WithJobs(jobs int) Option {
+	return func(r *Request) {
+		r.SetJobs(jobs)
+	}
+}
+
+// WithUserAgent sets the 'User-Agent' HTTP header used when making requests. See SetUserAgent.
+func WithUserAgent(userAgent string) Option {
+	return func(r *Request) {
+		r.SetUserAgent(userAgent)
+	}
+}
+
+// WithClient sets the client used for all requests. See SetClient.
+func WithClient(c *http.Client) Option {
+	return func(r *Request) {
+		r.SetClient(c)
+	}
+}
+
+// WithHeader sets a header sent with every request. See SetHeader.
+func WithHeader(key, value string) Option {
+	return func(r *Request) {
+		r.SetHeader(key, value)
+	}
+}
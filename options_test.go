@@ -0,0 +1,52 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package braid
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestOptions(t *testing.T) {
+	client := &http.Client{Timeout: time.Minute}
+	br, err := NewRequest(
+		WithJobs(8),
+		WithUserAgent("braid-test"),
+		WithClient(client),
+		WithHeader("X-Token", "abc"),
+		WithHeader("Accept", "application/octet-stream"),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if br.jobs != 8 {
+		t.Fatalf("expected 8 jobs, got %d", br.jobs)
+	}
+	if br.userAgent != "braid-test" {
+		t.Fatalf("expected user agent braid-test, got %q", br.userAgent)
+	}
+	if br.httpClient != client {
+		t.Fatalf("expected client to be set")
+	}
+	if br.requestHeader.Get("X-Token") != "abc" || br.requestHeader.Get("Accept") != "application/octet-stream" {
+		t.Fatalf("expected headers to be set, got %v", br.requestHeader)
+	}
+	// defaults are kept for anything not configured
+	if br.fileMode != DefaultFileMode || br.retryBackoff != DefaultRetryBackoff {
+		t.Fatalf("expected defaults to be kept")
+	}
+}
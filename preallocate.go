@@ -0,0 +1,43 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package braid
+
+import (
+	"os"
+)
+
+// SetPreallocate sets whether FetchFile should allocate the file to the full length of the resource
+// before downloading into it, so that jobs write into allocated space rather than extending the file.
+// Where the platform supports it the space is reserved on disk, otherwise the file is extended with
+// Truncate. Enabled by default; it may be disabled for filesystems where this is undesirable.
+func (r *Request) SetPreallocate(preallocate bool) {
+	r.preallocate = preallocate
+}
+
+// allocate extends f to length bytes, reserving the space on disk where possible. A file already at
+// least length bytes long is left unchanged.
+func allocate(f *os.File, length int64) error {
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if fi.Size() >= length {
+		return nil
+	}
+	if fallocate(f, length) == nil {
+		return nil
+	}
+	return f.Truncate(length)
+}
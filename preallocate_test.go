@@ -0,0 +1,91 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package braid
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+func TestAllocate(t *testing.T) {
+	var length int64 = 3<<20 + 17
+
+	f, err := os.Create(filepath.Join(t.TempDir(), "allocate.bin"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	if err = allocate(f, length); err != nil {
+		t.Fatal(err)
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Size() != length {
+		t.Fatalf("expected file of %d bytes, got %d", length, fi.Size())
+	}
+
+	// a larger file is left alone
+	if err = allocate(f, 10); err != nil {
+		t.Fatal(err)
+	}
+	if fi, _ = f.Stat(); fi.Size() != length {
+		t.Fatalf("expected file to remain %d bytes, got %d", length, fi.Size())
+	}
+}
+
+func TestPreallocate(t *testing.T) {
+	var filename string = "preallocate.bin"
+	content := randomContent(2 << 20)
+	ts := newContentServer(content)
+	defer ts.Close()
+	defer os.Remove(filename)
+
+	br, err := NewRequest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var once sync.Once
+	var size int64
+	br.SetProgressFunc(func(s Stat) {
+		once.Do(func() {
+			if fi, err := os.Stat(partFilename(filename)); err == nil {
+				size = fi.Size()
+			}
+		})
+	})
+	file, err := br.FetchFile(context.Background(), ts.URL, filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+
+	if size != int64(len(content)) {
+		t.Fatalf("expected file to be allocated to %d bytes before the download completed, got %d", len(content), size)
+	}
+	got, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, content) {
+		t.Fatalf("downloaded content doesn't match")
+	}
+}
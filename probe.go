@@ -0,0 +1,117 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package braid
+
+import "context"
+
+// Capabilities describes a resource and what the server supports for downloading it.
+type Capabilities struct {
+	// Size is the length of the resource, or -1 if it's unknown
+	Size int64
+	// SupportsRanges is whether the resource can be fetched in parallel and resumed
+	SupportsRanges bool
+	ContentType    string
+	ETag           string
+}
+
+// Probe reports the capabilities of the resource without downloading it, using the same HEAD request,
+// or ranged GET if the HEAD request is refused, that a download starts with. Like a download,
+// it mustn't be called while another download is in progress on the request.
+func (r *Request) Probe(ctx context.Context, url string) (Capabilities, error) {
+	length, rangesSupported, err := r.probe(ctx, url)
+	if err != nil {
+		return Capabilities{}, err
+	}
+
+	c := Capabilities{
+		Size:           length,
+		SupportsRanges: rangesSupported && length >= 0,
+	}
+	if r.header != nil {
+		c.ContentType = r.header.Get("Content-Type")
+		c.ETag = r.header.Get("ETag")
+	}
+	return c, nil
+}
+
+// probe makes the request a download starts with for Probe and Plan, returning the length of the
+// resource, or -1 if it's unknown, and whether it supports ranges.
+func (r *Request) probe(ctx context.Context, url string) (int64, bool, error) {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+
+	var err error
+	r.url = url
+	r.client, err = r.newClient()
+	if err != nil {
+		return 0, false, err
+	}
+	if r.transport != nil {
+		defer r.transport.CloseIdleConnections()
+	}
+	r.resolvedURL = ""
+	r.header = nil
+	r.gzipped = false
+
+	first, length, rangesSupported, err := r.discover(ctx, condition{})
+	if err != nil {
+		return 0, false, err
+	}
+	if first != nil {
+		// the server ignored the range and sent the whole resource
+		first.Body.Close()
+		rangesSupported = false
+	}
+	return length, rangesSupported, nil
+}
+
+// Chunk is a range of the resource fetched by a single job. See Plan.
+type Chunk struct {
+	// Start and End are the offsets of the first byte of the chunk and the byte after its last, with End
+	// -1 if the length of the resource is unknown
+	Start int64
+	End   int64
+	// JobID identifies the job in JobStats and the log
+	JobID int
+}
+
+// Plan reports the chunks a download of the resource would be divided into with the current settings,
+// without fetching any of it. It makes the same HEAD request, or ranged GET if the HEAD request is
+// refused, that a download starts with. The division made by adaptive jobs isn't known until the
+// download calibrates it, so isn't taken into account. Like a download, it mustn't be called while
+// another download is in progress on the request.
+func (r *Request) Plan(ctx context.Context, url string) ([]Chunk, error) {
+	length, rangesSupported, err := r.probe(ctx, url)
+	if err != nil {
+		return nil, err
+	}
+	if length < 0 {
+		return []Chunk{{Start: 0, End: -1}}, nil
+	}
+
+	jobs := 1
+	if rangesSupported {
+		jobs = r.jobCount(length)
+	}
+	chunks, err := r.layout(0, length, jobs, r.chunkSize, rangesSupported)
+	if err != nil {
+		return nil, err
+	}
+	plan := make([]Chunk, len(chunks))
+	for i, c := range chunks {
+		plan[i] = Chunk{Start: c.Start, End: c.End, JobID: i}
+	}
+	return plan, nil
+}
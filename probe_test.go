@@ -0,0 +1,137 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package braid
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestProbe(t *testing.T) {
+	content := randomContent(1 << 20)
+
+	var gets int
+	ranged := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "GET" {
+			gets++
+		}
+		w.Header().Set("ETag", `"v1"`)
+		w.Header().Set("Content-Type", "application/x-test")
+		http.ServeContent(w, r, "data.bin", time.Time{}, bytes.NewReader(content))
+	}))
+	defer ranged.Close()
+
+	noRanges := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "GET" {
+			gets++
+		}
+		w.Header().Set("Accept-Ranges", "none")
+		w.Header().Set("Content-Length", strconv.Itoa(len(content)))
+		if r.Method == "GET" {
+			w.Write(content)
+		}
+	}))
+	defer noRanges.Close()
+
+	tests := []struct {
+		name     string
+		url      string
+		expected Capabilities
+	}{
+		{"ranges", ranged.URL, Capabilities{Size: int64(len(content)), SupportsRanges: true, ContentType: "application/x-test", ETag: `"v1"`}},
+		{"no ranges", noRanges.URL, Capabilities{Size: int64(len(content)), SupportsRanges: false}},
+	}
+
+	for _, tt := range tests {
+		br, err := NewRequest()
+		if err != nil {
+			t.Fatal(err)
+		}
+		c, err := br.Probe(context.Background(), tt.url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if c != tt.expected {
+			t.Fatalf("%s: expected %+v, got %+v", tt.name, tt.expected, c)
+		}
+	}
+	// a HEAD request with Content-Length and Accept-Ranges is enough
+	if gets != 0 {
+		t.Fatalf("expected no GET requests, got %d", gets)
+	}
+}
+
+func TestPlan(t *testing.T) {
+	var length int = 1000003
+	content := randomContent(length)
+
+	var gets int
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "GET" {
+			gets++
+		}
+		http.ServeContent(w, r, "data.bin", time.Time{}, bytes.NewReader(content))
+	}))
+	defer ts.Close()
+
+	tests := []struct {
+		name      string
+		jobs      int
+		chunkSize int64
+		expected  []Chunk
+	}{
+		{"one job", 1, 0, []Chunk{{0, 1000003, 0}}},
+		// the last chunk takes the remainder
+		{"jobs", 4, 0, []Chunk{{0, 250000, 0}, {250000, 500000, 1}, {500000, 750000, 2}, {750000, 1000003, 3}}},
+		{"chunk size", 2, 300000, []Chunk{{0, 250000, 0}, {250000, 500000, 1}, {500000, 750000, 2}, {750000, 1000003, 3}}},
+	}
+
+	for _, tt := range tests {
+		br, err := NewRequest()
+		if err != nil {
+			t.Fatal(err)
+		}
+		br.SetJobs(tt.jobs)
+		br.SetChunkSize(tt.chunkSize)
+		plan, err := br.Plan(context.Background(), ts.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(plan, tt.expected) {
+			t.Fatalf("%s: expected %v, got %v", tt.name, tt.expected, plan)
+		}
+
+		// the chunks follow on from each other to cover the whole resource
+		var end int64
+		for _, c := range plan {
+			if c.Start != end || c.End <= c.Start {
+				t.Fatalf("%s: chunk %v doesn't follow on from %d", tt.name, c, end)
+			}
+			end = c.End
+		}
+		if end != int64(length) {
+			t.Fatalf("%s: expected chunks to cover %d bytes, got %d", tt.name, length, end)
+		}
+	}
+	if gets != 0 {
+		t.Fatalf("expected nothing to be fetched, got %d GET requests", gets)
+	}
+}
@@ -0,0 +1,231 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package braid
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// progressInterval is the minimum time between calls to the progress function.
+const progressInterval = 100 * time.Millisecond
+
+// SetProgressFunc sets a function to be called with the download's stats as bytes are read, at most
+// every 100ms, and once more when every job has finished. Calls are made from the jobs' goroutines
+// but never concurrently, and none are made after the download returns.
+func (r *Request) SetProgressFunc(f func(Stat)) {
+	r.progressFunc = f
+}
+
+// rateWindow is the period over which the current throughput is measured. It spans many
+// samples so that bursts of writes are smoothed out.
+const rateWindow = 5 * time.Second
+
+// Progress is the progress of a download, along with its throughput.
+type Progress struct {
+	Stat
+	// BytesPerSec is the throughput over the last few seconds
+	BytesPerSec float64
+	// AvgBytesPerSec is the throughput since the download started
+	AvgBytesPerSec float64
+	// ETA is the estimated time remaining at the current throughput, or zero if unknown
+	ETA time.Duration
+}
+
+// Progress returns the download's stats along with its throughput and estimated time remaining.
+// It is thread safe and can be called from a goroutine.
+func (r *Request) Progress() Progress {
+	p := Progress{Stat: r.Stats()}
+
+	r.mu.Lock()
+	started, finished := r.started, r.finished
+	r.mu.Unlock()
+	if finished.IsZero() {
+		finished = time.Now()
+	}
+	if elapsed := finished.Sub(started).Seconds(); !started.IsZero() && elapsed > 0 {
+		p.AvgBytesPerSec = float64(p.ReadBytes) / elapsed
+	}
+	p.BytesPerSec = r.rate.rate()
+	p.ETA = eta(p.TotalBytes-p.ReadBytes, p.BytesPerSec)
+	return p
+}
+
+// progressBarWidth is the number of characters inside the bar written by WriteProgress.
+const progressBarWidth = 30
+
+// WriteProgress writes a progress bar for the download to w every interval, redrawing it in place on a
+// single line with the bytes fetched, the percentage complete and the throughput. It returns once the
+// download has ended, whether it succeeded, failed or was cancelled, or ctx is done, having written the
+// final progress and a newline. It's intended to be run in a goroutine alongside the download, and a
+// request that has already made a download should be Reset first, unless WriteProgress is called once
+// the next download has started. Zero or a negative interval uses 100ms.
+func (r *Request) WriteProgress(ctx context.Context, w io.Writer, interval time.Duration) {
+	if interval <= 0 {
+		interval = progressInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	var width int
+	for {
+		done := false
+		select {
+		case <-ctx.Done():
+			done = true
+		case <-ticker.C:
+			r.mu.Lock()
+			ended := r.done
+			r.mu.Unlock()
+			// ended is nil, so never ready, until a download begins
+			select {
+			case <-ended:
+				done = true
+			default:
+			}
+		}
+
+		line := r.progressLine()
+		// a shorter line is padded to overwrite the last one
+		padding := ""
+		if len(line) < width {
+			padding = strings.Repeat(" ", width-len(line))
+		}
+		width = len(line)
+		if done {
+			fmt.Fprint(w, "\r"+line+padding+"\n")
+			return
+		}
+		fmt.Fprint(w, "\r"+line+padding)
+	}
+}
+
+// begin marks the start of a download for WriteProgress, returning the function that marks its end.
+func (r *Request) begin() func() {
+	done := make(chan struct{})
+	r.mu.Lock()
+	r.done = done
+	r.finished = time.Time{}
+	r.mu.Unlock()
+	return func() { close(done) }
+}
+
+// progressLine returns the line written by WriteProgress, without a bar if the length is unknown.
+func (r *Request) progressLine() string {
+	p := r.Progress()
+	percent := r.Percent()
+	if percent < 0 {
+		return fmt.Sprintf("%s %s/s", FormatBytes(float64(p.ReadBytes)), FormatBytes(p.BytesPerSec))
+	}
+	filled := int(percent * progressBarWidth / 100)
+	return fmt.Sprintf("[%s%s] %5.1f%% %s/%s %s/s", strings.Repeat("=", filled), strings.Repeat(" ", progressBarWidth-filled),
+		percent, FormatBytes(float64(p.ReadBytes)), FormatBytes(float64(p.TotalBytes)), FormatBytes(p.BytesPerSec))
+}
+
+// FormatBytes returns n in human readable binary units e.g. '1.5MiB'
+func FormatBytes(n float64) string {
+	units := []string{"B", "KiB", "MiB", "GiB", "TiB"}
+	i := 0
+	for n >= 1024 && i < len(units)-1 {
+		n /= 1024
+		i++
+	}
+	if i == 0 {
+		return strconv.Itoa(int(n)) + units[i]
+	}
+	return strconv.FormatFloat(n, 'f', 1, 64) + units[i]
+}
+
+// eta returns how long remaining bytes take at bytesPerSec, or zero if that's unknown.
+func eta(remaining int64, bytesPerSec float64) time.Duration {
+	if remaining <= 0 || bytesPerSec <= 0 {
+		return 0
+	}
+	return time.Duration(float64(remaining) / bytesPerSec * float64(time.Second))
+}
+
+// rateSample is the total number of bytes read at a point in time.
+type rateSample struct {
+	t     time.Time
+	bytes int64
+}
+
+// rateMeter measures throughput from samples of the total bytes read over a rolling window.
+type rateMeter struct {
+	mu      sync.Mutex
+	window  time.Duration
+	samples []rateSample
+}
+
+func (m *rateMeter) reset() {
+	m.mu.Lock()
+	m.samples = nil
+	m.mu.Unlock()
+}
+
+// add records that bytes had been read in total at time t.
+func (m *rateMeter) add(t time.Time, bytes int64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.samples = append(m.samples, rateSample{t, bytes})
+	// keep one sample from before the window so that it's spanned completely
+	for len(m.samples) > 2 && t.Sub(m.samples[1].t) >= m.window {
+		m.samples = m.samples[1:]
+	}
+}
+
+// rate returns the bytes per second read between the first and last samples.
+func (m *rateMeter) rate() float64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if len(m.samples) < 2 {
+		return 0
+	}
+	first, last := m.samples[0], m.samples[len(m.samples)-1]
+	dt := last.t.Sub(first.t).Seconds()
+	if dt <= 0 {
+		return 0
+	}
+	return float64(last.bytes-first.bytes) / dt
+}
+
+// update samples the throughput and calls the progress function, if progressInterval has passed
+// since it was last done or final is true.
+func (r *Request) update(final bool) {
+	// checked before locking so that jobs don't contend on every read
+	now := time.Now()
+	if !final && now.UnixNano()-atomic.LoadInt64(&r.lastProgress) < int64(progressInterval) {
+		return
+	}
+
+	r.progressMu.Lock()
+	defer r.progressMu.Unlock()
+	if !final && now.UnixNano()-atomic.LoadInt64(&r.lastProgress) < int64(progressInterval) {
+		return
+	}
+	atomic.StoreInt64(&r.lastProgress, now.UnixNano())
+
+	stat := r.Stats()
+	r.rate.add(now, stat.ReadBytes)
+	if r.progressFunc != nil {
+		r.progressFunc(stat)
+	}
+}
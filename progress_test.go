@@ -0,0 +1,257 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package braid
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRateMeter(t *testing.T) {
+	start := time.Now()
+	at := func(ms int) time.Time {
+		return start.Add(time.Duration(ms) * time.Millisecond)
+	}
+
+	m := &rateMeter{window: 2 * time.Second}
+	if m.rate() != 0 {
+		t.Fatalf("expected no rate without samples")
+	}
+
+	// bursts of writes average out over the window
+	m.add(at(0), 0)
+	m.add(at(100), 1500)
+	m.add(at(200), 1500)
+	m.add(at(900), 1500)
+	m.add(at(1000), 2000)
+	if rate := m.rate(); rate != 2000 {
+		t.Fatalf("expected 2000 bytes/s, got %f", rate)
+	}
+
+	// older samples drop out of the window
+	for ms := 1100; ms <= 10000; ms += 100 {
+		m.add(at(ms), 2000+int64(ms-1000)*10)
+	}
+	if rate := m.rate(); rate != 10000 {
+		t.Fatalf("expected 10000 bytes/s, got %f", rate)
+	}
+	if len(m.samples) > 22 {
+		t.Fatalf("expected samples outside the window to be dropped, have %d", len(m.samples))
+	}
+
+	m.reset()
+	if m.rate() != 0 {
+		t.Fatalf("expected no rate after reset")
+	}
+}
+
+func TestETA(t *testing.T) {
+	tests := []struct {
+		remaining int64
+		rate      float64
+		expected  time.Duration
+	}{
+		{5000, 1000, 5 * time.Second},
+		{1500, 1000, 1500 * time.Millisecond},
+		{0, 1000, 0},
+		{5000, 0, 0},
+	}
+	for _, tt := range tests {
+		if got := eta(tt.remaining, tt.rate); got != tt.expected {
+			t.Errorf("%d bytes at %f bytes/s: expected %s, got %s", tt.remaining, tt.rate, tt.expected, got)
+		}
+	}
+}
+
+func TestProgress(t *testing.T) {
+	var filename string = "progress.bin"
+	content := randomContent(4 << 20)
+	ts := newContentServer(content)
+	defer ts.Close()
+	defer os.Remove(filename)
+
+	br, err := NewRequest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	file, err := br.FetchFile(context.Background(), ts.URL, filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+
+	p := br.Progress()
+	if p.ReadBytes != int64(len(content)) || p.AvgBytesPerSec <= 0 || p.ETA != 0 {
+		t.Fatalf("unexpected progress %+v", p)
+	}
+}
+
+func TestWriteProgress(t *testing.T) {
+	var filename string = "writeprogress.bin"
+	content := randomContent(1 << 20)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tw := &throttledWriter{ResponseWriter: w, mu: &sync.Mutex{}, bytesPerSec: 1 << 20}
+		http.ServeContent(tw, r, filename, time.Time{}, bytes.NewReader(content))
+	}))
+	defer ts.Close()
+	defer os.Remove(filename)
+
+	br, err := NewRequest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	br.SetJobs(4)
+	var buf bytes.Buffer
+	done := make(chan struct{})
+	go func() {
+		br.WriteProgress(context.Background(), &buf, 20*time.Millisecond)
+		close(done)
+	}()
+	file, err := br.FetchFile(context.Background(), ts.URL, filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatalf("expected WriteProgress to return once the download finished")
+	}
+	out := buf.String()
+	if strings.Count(out, "\r") < 2 {
+		t.Fatalf("expected the progress to be redrawn, got %q", out)
+	}
+	last := out[strings.LastIndex(out, "\r")+1:]
+	if !strings.HasSuffix(last, "\n") || !strings.Contains(last, "100.0% 1.0MiB/1.0MiB") {
+		t.Fatalf("expected the final line to show the download complete, got %q", last)
+	}
+
+	// a cancelled context stops it before any download starts
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	br.Reset()
+	buf.Reset()
+	br.WriteProgress(ctx, &buf, time.Hour)
+	if !strings.HasSuffix(buf.String(), "\n") {
+		t.Fatalf("expected a final line once cancelled, got %q", buf.String())
+	}
+}
+
+func TestWriteProgressFailed(t *testing.T) {
+	var filename string = "writeprogress.bin"
+	ts := httptest.NewServer(http.NotFoundHandler())
+	defer ts.Close()
+	defer os.Remove(filename)
+
+	br, err := NewRequest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	done := make(chan struct{})
+	go func() {
+		br.WriteProgress(context.Background(), &buf, 20*time.Millisecond)
+		close(done)
+	}()
+	if _, err := br.FetchFile(context.Background(), ts.URL, filename); err == nil {
+		t.Fatalf("expected the HEAD request to fail")
+	}
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatalf("expected WriteProgress to return once the download failed")
+	}
+	if !strings.HasSuffix(buf.String(), "\n") {
+		t.Fatalf("expected a final line once the download failed, got %q", buf.String())
+	}
+}
+
+func TestWriteProgressReused(t *testing.T) {
+	var filename string = "writeprogress.bin"
+	content := randomContent(1 << 20)
+	headed := make(chan struct{}, 2)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "HEAD" {
+			headed <- struct{}{}
+			// WriteProgress is well under way before the download learns of the resource
+			time.Sleep(200 * time.Millisecond)
+		}
+		tw := &throttledWriter{ResponseWriter: w, mu: &sync.Mutex{}, bytesPerSec: 1 << 20}
+		http.ServeContent(tw, r, filename, time.Time{}, bytes.NewReader(content))
+	}))
+	defer ts.Close()
+	defer os.Remove(filename)
+
+	br, err := NewRequest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	br.SetJobs(4)
+	file, err := br.FetchFile(context.Background(), ts.URL, filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+	<-headed
+
+	// the request is reused without Reset, WriteProgress starting once the next download has
+	fetched := make(chan error, 1)
+	go func() {
+		file, err := br.FetchFile(context.Background(), ts.URL, filename)
+		if err == nil {
+			file.Close()
+		}
+		fetched <- err
+	}()
+	<-headed
+	var buf bytes.Buffer
+	br.WriteProgress(context.Background(), &buf, 10*time.Millisecond)
+	select {
+	case err := <-fetched:
+		if err != nil {
+			t.Fatal(err)
+		}
+	default:
+		t.Fatalf("expected WriteProgress to return once the download finished, got %q", buf.String())
+	}
+	last := buf.String()[strings.LastIndex(buf.String(), "\r")+1:]
+	if !strings.Contains(last, "100.0% 1.0MiB/1.0MiB") {
+		t.Fatalf("expected the final line to show the download complete, got %q", last)
+	}
+}
+
+func TestFormatBytes(t *testing.T) {
+	tests := map[float64]string{
+		0:       "0B",
+		1023:    "1023B",
+		1536:    "1.5KiB",
+		5 << 20: "5.0MiB",
+		3 << 30: "3.0GiB",
+	}
+	for n, expected := range tests {
+		if got := FormatBytes(n); got != expected {
+			t.Errorf("FormatBytes(%f): expected %s, got %s", n, expected, got)
+		}
+	}
+}
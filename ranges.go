@@ -0,0 +1,199 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package braid
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+// FetchRanges fetches the given [start,end) byte ranges of the resource, writing each to its own
+// offset in w. Ranges needn't be contiguous or in order, which allows sparse reads of a large resource.
+// Each range is fetched by its own job, with at most the number of jobs set by SetJobs running at once,
+// which take turns to fetch should SetMaxConnsPerHost allow fewer.
+// The server must support ranges, unless the only range starts at zero.
+func (r *Request) FetchRanges(ctx context.Context, url string, ranges [][2]int64, w io.WriterAt) (err error) {
+	end := r.begin()
+	defer end()
+	defer func() { r.closeEvents(err) }()
+	for i, rng := range ranges {
+		if rng[0] < 0 || rng[1] < rng[0] {
+			return fmt.Errorf("invalid range %d %v", i, rng)
+		}
+	}
+
+	r.resolvedURL = url
+	r.header = nil
+	r.gzipped = false
+	r.ifRange = ""
+	return r.fetchRanges(ctx, url, ranges, w)
+}
+
+// fetchRanges fetches ranges of the resource to w for FetchRanges. What is known of the resource is kept,
+// so that once it has been probed, jobs go straight to the URL its redirects led to and make their
+// requests conditional on it being unchanged.
+func (r *Request) fetchRanges(ctx context.Context, url string, ranges [][2]int64, w io.WriterAt) (err error) {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+	ctx, stop := r.cancellable(ctx)
+	defer stop()
+
+	parent := ctx
+	defer func() { err = r.cancelledError(parent, err) }()
+	ctx, r.cancel = context.WithCancel(ctx)
+	defer r.cancel()
+
+	r.url = url
+	r.w = w
+	r.client, err = r.newClient()
+	if err != nil {
+		return err
+	}
+	if r.transport != nil {
+		defer r.transport.CloseIdleConnections()
+	}
+	r.mirrorSet = newMirrorSet(append([]string{url}, r.mirrors...), r.mirrorCooldown)
+	if r.resolvedURL != "" {
+		r.mirrorSet.resolve(r.resolvedURL)
+	}
+	r.ifMatch = ""
+	r.retries = 0
+	atomic.StoreInt32(&r.connsReused, 0)
+	atomic.StoreInt32(&r.connsNew, 0)
+	r.verifier = nil
+	r.digester = nil
+	r.digest = nil
+
+	r.mu.Lock()
+	r.file = nil
+	r.stats = nil
+	r.ranges = nil
+	r.hedges = nil
+	r.balancer = nil
+	r.length = 0
+	r.started = time.Now()
+	r.rate.reset()
+	r.finished = time.Time{}
+	r.mu.Unlock()
+
+	r.log(slog.LevelInfo, fmt.Sprintf("fetching %d ranges of %s", len(ranges), url))
+
+	queue := make(chan queuedChunk, len(ranges))
+	for _, rng := range ranges {
+		jobID := r.addJob(rng[0], rng[1])
+		if rng[0] == rng[1] {
+			continue
+		}
+		queue <- queuedChunk{jobID: jobID, min: rng[0], max: rng[1]}
+	}
+	close(queue)
+
+	workers := r.jobs
+	if workers <= 0 {
+		workers = 1
+	}
+	if workers > len(queue) {
+		workers = len(queue)
+	}
+	r.concurrency = r.newConcurrency(workers)
+
+	errChan := make(chan error, len(ranges))
+	r.wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go r.worker(ctx, queue, errChan)
+	}
+
+	errs := r.wait(errChan)
+	if len(errs) == 0 && ctx.Err() != nil {
+		// ranges still queued when the download was cancelled were never fetched
+		errs = append(errs, ctx.Err())
+	}
+	if len(errs) > 0 {
+		return jobsError(parent, errs)
+	}
+	return nil
+}
+
+// FetchRange fetches bytes [start,end) of the resource to filename, so that the file holds just that
+// slice of the resource, beginning at its start. The slice is divided between jobs like a whole download,
+// see SetJobs and SetChunkSize. The resource's length is discovered first, and the range must lie
+// within it. As with FetchFile, the slice is written to filename with a '.part' suffix that's renamed
+// once it's complete, and no file is returned if the download fails unless it's stopped by Cancel.
+// Resuming and caching only apply to FetchFile.
+func (r *Request) FetchRange(ctx context.Context, url, filename string, start, end int64) (file *os.File, err error) {
+	ended := r.begin()
+	defer ended()
+	defer func() { r.closeEvents(err) }()
+	if start < 0 || end < start {
+		return nil, fmt.Errorf("invalid range [%d,%d)", start, end)
+	}
+
+	c, err := r.Probe(ctx, url)
+	if err != nil {
+		return nil, err
+	}
+	if c.Size < 0 {
+		return nil, ErrNoContentLength
+	}
+	if end > c.Size {
+		return nil, fmt.Errorf("%w: range [%d,%d) of %d bytes", ErrRangeNotSatisfiable, start, end, c.Size)
+	}
+	if !c.SupportsRanges && (start > 0 || end < c.Size) {
+		return nil, ErrRangesNotSupported
+	}
+	// as in a whole download, jobs detect the resource changing since it was probed
+	r.ifRange = ""
+	if c.SupportsRanges {
+		r.ifRange = ifRangeValidator(r.header)
+	}
+
+	jobs := 1
+	if c.SupportsRanges {
+		jobs = r.jobCount(end - start)
+	}
+	chunks, err := r.layout(start, end, jobs, r.chunkSize, c.SupportsRanges)
+	if err != nil {
+		return nil, err
+	}
+	ranges := make([][2]int64, len(chunks))
+	for i, chunk := range chunks {
+		ranges[i] = [2]int64{chunk.Start, chunk.End}
+	}
+
+	file, err = r.openFile(partFilename(filename), os.O_RDWR|os.O_CREATE|os.O_TRUNC)
+	if err != nil {
+		return nil, err
+	}
+	// writes are at offsets in the resource, so are moved back by start to begin the file
+	if err := r.fetchRanges(ctx, url, ranges, io.NewOffsetWriter(file, -start)); err != nil {
+		if keepPartial(file, err) {
+			return file, err
+		}
+		file.Close()
+		os.Remove(partFilename(filename))
+		return nil, err
+	}
+	r.file = file
+	if err := r.complete(filename); err != nil {
+		os.Remove(partFilename(filename))
+		return nil, err
+	}
+	return r.file, nil
+}
@@ -0,0 +1,196 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package braid
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// bufferAt is an in-memory io.WriterAt
+type bufferAt struct {
+	sync.Mutex
+	b []byte
+}
+
+func (w *bufferAt) WriteAt(p []byte, off int64) (int, error) {
+	w.Lock()
+	defer w.Unlock()
+	return copy(w.b[off:], p), nil
+}
+
+func TestFetchRanges(t *testing.T) {
+	content := randomContent(1 << 20)
+	ts := newContentServer(content)
+	defer ts.Close()
+
+	ranges := [][2]int64{{900000, 1 << 20}, {0, 10}, {4096, 8192}, {500000, 500000}, {12345, 654321}}
+	w := &bufferAt{b: make([]byte, len(content))}
+
+	br, err := NewRequest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	br.SetJobs(2)
+	if err = br.FetchRanges(context.Background(), ts.URL, ranges, w); err != nil {
+		t.Fatal(err)
+	}
+
+	var total int64
+	for _, rng := range ranges {
+		if !bytes.Equal(w.b[rng[0]:rng[1]], content[rng[0]:rng[1]]) {
+			t.Fatalf("range %v doesn't match", rng)
+		}
+		total += rng[1] - rng[0]
+	}
+	// bytes outside the ranges are left alone
+	if !bytes.Equal(w.b[10:4096], make([]byte, 4086)) {
+		t.Fatalf("bytes outside ranges were written")
+	}
+
+	stat := br.Stats()
+	if stat.TotalBytes != total || stat.ReadBytes != total {
+		t.Fatalf("expected stats to cover %d bytes, got %+v", total, stat)
+	}
+
+	if err = br.FetchRanges(context.Background(), ts.URL, [][2]int64{{10, 5}}, w); err == nil {
+		t.Fatalf("expected invalid range to fail")
+	}
+}
+
+func TestFetchRangesNotSupported(t *testing.T) {
+	content := randomContent(100000)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(content)
+	}))
+	defer ts.Close()
+
+	br, err := NewRequest()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a full response is fine for a range at the start
+	w := &bufferAt{b: make([]byte, len(content))}
+	if err = br.FetchRanges(context.Background(), ts.URL, [][2]int64{{0, 100}}, w); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(w.b[:100], content[:100]) || w.b[100] != 0 {
+		t.Fatalf("range content doesn't match")
+	}
+
+	err = br.FetchRanges(context.Background(), ts.URL, [][2]int64{{0, 100}, {5000, 6000}}, w)
+	if !errors.Is(err, ErrRangesNotSupported) {
+		t.Fatalf("expected ErrRangesNotSupported, got %v", err)
+	}
+}
+
+func TestFetchRange(t *testing.T) {
+	var filename string = "range.bin"
+	content := randomContent(4 << 20)
+	defer os.Remove(filename)
+	ts := newContentServer(content)
+	defer ts.Close()
+
+	var start, end int64 = 1 << 20, 2<<20 + 12345
+	br, err := NewRequest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	br.SetJobs(4)
+	file, err := br.FetchRange(context.Background(), ts.URL, filename, start, end)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := io.ReadAll(file)
+	file.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, content[start:end]) {
+		t.Fatalf("expected %d bytes matching the range, got %d bytes", end-start, len(got))
+	}
+	if jobs := len(br.JobStats()); jobs != 4 {
+		t.Fatalf("expected the range split between 4 jobs, got %d", jobs)
+	}
+
+	_, err = br.FetchRange(context.Background(), ts.URL, filename, start, int64(len(content))+1)
+	if !errors.Is(err, ErrRangeNotSatisfiable) {
+		t.Fatalf("expected ErrRangeNotSatisfiable for a range beyond the resource, got %v", err)
+	}
+}
+
+func TestFetchRangeProbed(t *testing.T) {
+	var filename string = "rangeprobed.bin"
+	content := randomContent(1 << 20)
+	defer os.Remove(filename)
+
+	var mu sync.Mutex
+	etag := `"v1"`
+	change := false
+	var redirects int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/moved" {
+			atomic.AddInt32(&redirects, 1)
+			http.Redirect(w, r, "/file", http.StatusFound)
+			return
+		}
+		mu.Lock()
+		w.Header().Set("ETag", etag)
+		if change && r.Method == "HEAD" {
+			// the resource changes once it has been probed
+			etag = `"v2"`
+		}
+		mu.Unlock()
+		w.Header().Set("Content-Type", "application/x-test")
+		http.ServeContent(w, r, filename, time.Time{}, bytes.NewReader(content))
+	}))
+	defer ts.Close()
+
+	br, err := NewRequest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	br.SetJobs(4)
+	file, err := br.FetchRange(context.Background(), ts.URL+"/moved", filename, 1000, 600000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+	// only the probe is redirected, the jobs going straight to the resolved URL
+	if n := atomic.LoadInt32(&redirects); n != 1 {
+		t.Fatalf("expected 1 request to be redirected, got %d", n)
+	}
+	if ct := br.ResponseHeaders().Get("Content-Type"); ct != "application/x-test" {
+		t.Fatalf("expected the probed response headers, got Content-Type %q", ct)
+	}
+
+	mu.Lock()
+	change = true
+	mu.Unlock()
+	_, err = br.FetchRange(context.Background(), ts.URL+"/moved", filename, 1000, 600000)
+	if !errors.Is(err, ErrResourceChanged) {
+		t.Fatalf("expected ErrResourceChanged, got %v", err)
+	}
+}
@@ -0,0 +1,174 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package braid
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"time"
+)
+
+// resumeInterval is how often resume state is saved while downloading.
+const resumeInterval = time.Second
+
+// resumeState records the progress of each job so that an interrupted download can be resumed.
+type resumeState struct {
+	URL string `json:"url"`
+	// ETag is the entity tag of the resource when the download started
+	ETag string `json:"etag,omitempty"`
+	// LastModified is the resource's Last-Modified header when the download started
+	LastModified string          `json:"last_modified,omitempty"`
+	Length       int64           `json:"length"`
+	Chunks       []ManifestChunk `json:"chunks"`
+}
+
+// check returns an error if the state can't be used to resume the download of a resource of
+// the given length and response header to filename.
+func (s *resumeState) check(filename string, length int64, header http.Header) error {
+	if s.Length != length {
+		return fmt.Errorf("resource length changed from %d to %d", s.Length, length)
+	}
+	if etag := header.Get("ETag"); s.ETag != "" && etag != "" && etag != s.ETag {
+		return fmt.Errorf("resource ETag changed from %s to %s", s.ETag, etag)
+	}
+	if lm := header.Get("Last-Modified"); s.LastModified != "" && lm != "" && lm != s.LastModified {
+		return fmt.Errorf("resource Last-Modified changed from %s to %s", s.LastModified, lm)
+	}
+
+	var end int64
+	for i, c := range s.Chunks {
+		if c.Start != end || c.End < c.Start || c.Bytes < 0 || c.Bytes > c.End-c.Start {
+			return fmt.Errorf("invalid chunk %d %+v", i, c)
+		}
+		end = c.End
+	}
+	if end != length {
+		return fmt.Errorf("chunks cover %d of %d bytes", end, length)
+	}
+
+	fi, err := os.Stat(filename)
+	if err != nil {
+		return err
+	}
+	if fi.Size() > length {
+		return fmt.Errorf("partial file is larger than the resource")
+	}
+	return nil
+}
+
+// SetResume sets whether an interrupted download should be resumed. While downloading, the progress
+// of each job is saved to a sidecar file named after the download with a '.braid' suffix, which is
+// removed once the download succeeds. If the sidecar exists when FetchFile is next called for the same
+// URL, only the remaining bytes are fetched.
+//
+// The partial download is discarded and the download restarts from scratch if the resource's length,
+// ETag or Last-Modified header has changed, or the sidecar doesn't match the partial '.part' file. Resumed
+// requests are also made conditional on the ETag being unchanged, in case it changes while downloading.
+// Disabled by default.
+func (r *Request) SetResume(resume bool) {
+	r.resume = resume
+}
+
+func resumeFilename(filename string) string {
+	return filename + ".braid"
+}
+
+// loadResumeState returns the saved state of a previous download of url to filename, or nil if there is none.
+func (r *Request) loadResumeState(filename, url string) *resumeState {
+	b, err := os.ReadFile(resumeFilename(filename))
+	if err != nil {
+		return nil
+	}
+	state := &resumeState{}
+	if err = json.Unmarshal(b, state); err != nil {
+		r.log(slog.LevelWarn, fmt.Sprintf("ignoring invalid resume state: %s", err))
+		return nil
+	}
+	if state.URL != url {
+		return nil
+	}
+	return state
+}
+
+// saveResumeState saves the progress of the current download of filename.
+func (r *Request) saveResumeState(filename string) error {
+	m := r.Manifest()
+	state := resumeState{
+		URL:    m.URL,
+		ETag:   m.ETag,
+		Length: m.Size,
+		Chunks: m.Chunks,
+	}
+	if r.ifMatch != "" {
+		state.ETag = r.ifMatch
+	}
+	if r.header != nil {
+		state.LastModified = r.header.Get("Last-Modified")
+	}
+
+	b, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+
+	// write then rename so that an interruption never leaves a partial state file
+	tmp := resumeFilename(filename) + ".tmp"
+	if err = os.WriteFile(tmp, b, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, resumeFilename(filename))
+}
+
+// saveResumeStateEvery saves the progress of the current download every resumeInterval until quit is closed.
+func (r *Request) saveResumeStateEvery(filename string, quit chan struct{}) {
+	ticker := time.NewTicker(resumeInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := r.saveResumeState(filename); err != nil {
+				r.log(slog.LevelWarn, fmt.Sprintf("error saving resume state: %s", err))
+			}
+		case <-quit:
+			return
+		}
+	}
+}
+
+// partFilename is where a download to filename is written until it completes.
+func partFilename(filename string) string {
+	return filename + ".part"
+}
+
+func removeResumeState(filename string) {
+	os.Remove(resumeFilename(filename))
+}
+
+// resumed records that the bytes of chunk c fetched by a previous session are already in place.
+func (r *Request) resumed(jobID int, c ManifestChunk) error {
+	stat, _ := r.jobStat(jobID)
+	stat.read.Store(c.Bytes)
+
+	if r.digester != nil {
+		r.digester.wrote(c.Start, c.Bytes)
+	}
+	if r.verifier != nil {
+		return r.verifier.wrote(c.Start, c.Bytes)
+	}
+	return nil
+}
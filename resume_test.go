@@ -0,0 +1,244 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package braid
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+// versionedServer serves content with an ETag, both of which can be changed between requests
+type versionedServer struct {
+	sync.Mutex
+	content []byte
+	etag    string
+	modtime time.Time
+	ranges  []string
+}
+
+func (v *versionedServer) set(content []byte, etag string) {
+	v.Lock()
+	defer v.Unlock()
+	v.content = content
+	v.etag = etag
+}
+
+func (v *versionedServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	v.Lock()
+	content, etag, modtime := v.content, v.etag, v.modtime
+	if r.Method == "GET" {
+		v.ranges = append(v.ranges, r.Header.Get("Range"))
+	}
+	v.Unlock()
+
+	// ServeContent answers If-Match with 412 Precondition Failed when the ETag doesn't match
+	w.Header().Set("ETag", etag)
+	http.ServeContent(w, r, "data.bin", modtime, bytes.NewReader(content))
+}
+
+// writePartial simulates an interrupted download of content, where each chunk got half way
+func writePartial(t *testing.T, filename string, content []byte, chunks []ManifestChunk) {
+	partial := make([]byte, len(content))
+	for i := range chunks {
+		chunks[i].Bytes = (chunks[i].End - chunks[i].Start) / 2
+		copy(partial[chunks[i].Start:], content[chunks[i].Start:chunks[i].Start+chunks[i].Bytes])
+	}
+	if err := os.WriteFile(filename, partial, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func saveState(t *testing.T, filename string, state resumeState) {
+	b, err := json.Marshal(state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = os.WriteFile(resumeFilename(filename), b, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestResume(t *testing.T) {
+	var filename string = "resume.bin"
+	content := randomContent(1 << 20)
+	half := int64(len(content) / 2)
+
+	v := &versionedServer{content: content, etag: `"v1"`}
+	ts := httptest.NewServer(v)
+	defer ts.Close()
+	defer os.Remove(filename)
+	defer removeResumeState(filename)
+
+	chunks := []ManifestChunk{{Start: 0, End: half}, {Start: half, End: int64(len(content))}}
+	writePartial(t, partFilename(filename), content, chunks)
+	saveState(t, filename, resumeState{URL: ts.URL, ETag: `"v1"`, Length: int64(len(content)), Chunks: chunks})
+
+	br, err := NewRequest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	br.SetResume(true)
+	file, err := br.FetchFile(context.Background(), ts.URL, filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+
+	got, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, content) {
+		t.Fatalf("resumed content doesn't match")
+	}
+	// only the second half of each chunk should have been requested
+	expected := []string{"bytes=262144-524287", "bytes=786432-1048575"}
+	if len(v.ranges) != 2 || !(v.ranges[0] == expected[0] && v.ranges[1] == expected[1] || v.ranges[0] == expected[1] && v.ranges[1] == expected[0]) {
+		t.Fatalf("expected ranges %v, got %v", expected, v.ranges)
+	}
+	if br.Stats().ReadBytes != int64(len(content)) {
+		t.Fatalf("expected stats to include resumed bytes: got %d", br.Stats().ReadBytes)
+	}
+	if _, err = os.Stat(resumeFilename(filename)); !os.IsNotExist(err) {
+		t.Fatalf("expected resume state to be removed on success")
+	}
+}
+
+func TestResumeETagChanged(t *testing.T) {
+	var filename string = "resume.bin"
+	content := randomContent(1 << 20)
+	half := int64(len(content) / 2)
+
+	// the resource changes between sessions
+	changed := randomContent(1<<20 + 1)[1:]
+	v := &versionedServer{content: changed, etag: `"v2"`}
+	ts := httptest.NewServer(v)
+	defer ts.Close()
+	defer os.Remove(filename)
+	defer removeResumeState(filename)
+
+	chunks := []ManifestChunk{{Start: 0, End: half}, {Start: half, End: int64(len(content))}}
+	writePartial(t, partFilename(filename), content, chunks)
+	saveState(t, filename, resumeState{URL: ts.URL, ETag: `"v1"`, Length: int64(len(content)), Chunks: chunks})
+
+	br, err := NewRequest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	br.SetResume(true)
+	file, err := br.FetchFile(context.Background(), ts.URL, filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+
+	got, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, changed) {
+		t.Fatalf("expected download to restart with the changed content")
+	}
+}
+
+func TestResumeInvalidState(t *testing.T) {
+	var filename string = "resume.bin"
+	content := randomContent(1 << 20)
+	length := int64(len(content))
+	half := length / 2
+	modtime := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	v := &versionedServer{content: content, etag: `"v1"`, modtime: modtime}
+	ts := httptest.NewServer(v)
+	defer ts.Close()
+	defer os.Remove(filename)
+	defer removeResumeState(filename)
+
+	chunks := func() []ManifestChunk {
+		return []ManifestChunk{{Start: 0, End: half, Bytes: half / 2}, {Start: half, End: length, Bytes: half / 2}}
+	}
+	tests := []struct {
+		name    string
+		state   resumeState
+		partial bool
+	}{
+		{
+			"last modified changed",
+			resumeState{ETag: `"v1"`, LastModified: modtime.Add(-time.Hour).Format(http.TimeFormat), Length: length, Chunks: chunks()},
+			true,
+		},
+		{
+			"chunks beyond length",
+			resumeState{ETag: `"v1"`, Length: length, Chunks: []ManifestChunk{{Start: 0, End: half, Bytes: half}, {Start: half, End: length + 100, Bytes: 10}}},
+			true,
+		},
+		{
+			"bytes beyond chunk",
+			resumeState{ETag: `"v1"`, Length: length, Chunks: []ManifestChunk{{Start: 0, End: half, Bytes: half + 1}, {Start: half, End: length}}},
+			true,
+		},
+		{
+			"missing partial file",
+			resumeState{ETag: `"v1"`, Length: length, Chunks: chunks()},
+			false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			os.Remove(filename)
+			if tt.partial {
+				// the partial file has the wrong bytes, so resuming would corrupt the download
+				writePartial(t, partFilename(filename), randomContent(len(content) + 1)[1:], chunks())
+			}
+			tt.state.URL = ts.URL
+			saveState(t, filename, tt.state)
+			v.Lock()
+			v.ranges = nil
+			v.Unlock()
+
+			br, err := NewRequest()
+			if err != nil {
+				t.Fatal(err)
+			}
+			br.SetJobs(2)
+			br.SetResume(true)
+			file, err := br.FetchFile(context.Background(), ts.URL, filename)
+			if err != nil {
+				t.Fatal(err)
+			}
+			file.Close()
+
+			got, err := os.ReadFile(filename)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, content) {
+				t.Fatalf("expected download to restart")
+			}
+			expected := []string{"bytes=0-524287", "bytes=524288-1048575"}
+			if len(v.ranges) != 2 || !(v.ranges[0] == expected[0] && v.ranges[1] == expected[1] || v.ranges[0] == expected[1] && v.ranges[1] == expected[0]) {
+				t.Fatalf("expected ranges %v, got %v", expected, v.ranges)
+			}
+		})
+	}
+}
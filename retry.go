@@ -0,0 +1,99 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package braid
+
+import (
+	"context"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// DefaultRetryBackoff is the delay before the first retry of a failed chunk by default.
+const DefaultRetryBackoff = 500 * time.Millisecond
+
+// maxRetryBackoff caps the delay between retries of a chunk.
+const maxRetryBackoff = 30 * time.Second
+
+// maxRetryAfter caps the delay a server can ask for with a Retry-After header.
+const maxRetryAfter = 2 * time.Minute
+
+// SetMaxRetries sets how many times each job retries a transient failure, such as a network error,
+// a 5xx response or a 429 Too Many Requests response. If the response has a Retry-After header, the
+// retry is delayed by as long as it asks, up to a limit, rather than by the backoff. Only the part of the job's range that hasn't been fetched yet is requested again.
+// Retries count towards the budget set by SetMaxTotalRetries. Zero (the default) disables retries.
+func (r *Request) SetMaxRetries(n int) {
+	r.maxRetries = n
+}
+
+// SetRetryBackoff sets the delay before a job's first retry, which doubles with each subsequent retry
+// up to a limit. Each delay is randomised by up to half to avoid jobs retrying in lockstep.
+// DefaultRetryBackoff is used by default.
+func (r *Request) SetRetryBackoff(d time.Duration) {
+	r.retryBackoff = d
+}
+
+// backoff returns the delay before retry number attempt, counting from zero.
+func (r *Request) backoff(attempt int) time.Duration {
+	d := r.retryBackoff
+	for i := 0; i < attempt && d < maxRetryBackoff; i++ {
+		d *= 2
+	}
+	if d > maxRetryBackoff {
+		d = maxRetryBackoff
+	}
+	if d <= 0 {
+		return 0
+	}
+	// full delay less up to half of it
+	return d - time.Duration(rand.Int63n(int64(d)/2+1))
+}
+
+// sleepContext waits for d, returning early with the context's error if it is done first.
+func sleepContext(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// retryableStatus returns whether a request that got a response with the status code is worth retrying.
+func retryableStatus(code int) bool {
+	return code == http.StatusTooManyRequests || code >= 500
+}
+
+// parseRetryAfter returns the delay in a Retry-After header, which is either a number of seconds
+// or an HTTP date, relative to now. It returns zero if the header is missing or invalid.
+func parseRetryAfter(header string, now time.Time) time.Duration {
+	if header == "" {
+		return 0
+	}
+	if secs, err := strconv.Atoi(header); err == nil {
+		if secs < 0 {
+			return 0
+		}
+		return time.Duration(secs) * time.Second
+	}
+	t, err := http.ParseTime(header)
+	if err != nil || !t.After(now) {
+		return 0
+	}
+	return t.Sub(now)
+}
@@ -0,0 +1,318 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package braid
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+// truncatingWriter aborts the response once limit bytes of the body have been written
+type truncatingWriter struct {
+	http.ResponseWriter
+	limit int
+}
+
+func (w *truncatingWriter) Write(p []byte) (int, error) {
+	if len(p) > w.limit {
+		w.ResponseWriter.Write(p[:w.limit])
+		w.ResponseWriter.(http.Flusher).Flush()
+		panic(http.ErrAbortHandler)
+	}
+	w.limit -= len(p)
+	return w.ResponseWriter.Write(p)
+}
+
+func TestRetry(t *testing.T) {
+	var filename string = "retry.bin"
+	content := randomContent(1 << 20)
+	defer os.Remove(filename)
+
+	var mu sync.Mutex
+	ranges := map[string]int{}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		rng := r.Header.Get("Range")
+		ranges[rng]++
+		attempt := ranges[rng]
+		mu.Unlock()
+
+		switch {
+		case rng == "bytes=262144-524287" && attempt == 1:
+			http.Error(w, "try again", http.StatusServiceUnavailable)
+			return
+		case rng == "bytes=524288-786431" && attempt == 1:
+			// the connection drops part way through the range
+			w = &truncatingWriter{ResponseWriter: w, limit: 100000}
+		}
+		http.ServeContent(w, r, filename, time.Time{}, bytes.NewReader(content))
+	}))
+	defer ts.Close()
+
+	br, err := NewRequest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	br.SetJobs(4)
+	br.SetMaxRetries(2)
+	br.SetRetryBackoff(10 * time.Millisecond)
+	file, err := br.FetchFile(context.Background(), ts.URL, filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+
+	got, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, content) {
+		t.Fatalf("downloaded content doesn't match")
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if ranges["bytes=262144-524287"] != 2 {
+		t.Fatalf("expected failed range to be retried once, got %v", ranges)
+	}
+	// only the bytes after those already read are requested again
+	var retried []string
+	for rng := range ranges {
+		var start, end int
+		fmt.Sscanf(rng, "bytes=%d-%d", &start, &end)
+		if end == 786431 && start > 524288 {
+			retried = append(retried, rng)
+		}
+	}
+	if ranges["bytes=524288-786431"] != 1 || len(retried) != 1 {
+		t.Fatalf("expected the remainder of the truncated range to be requested, got %v", ranges)
+	}
+}
+
+func TestShortResponse(t *testing.T) {
+	var filename string = "short.bin"
+	content := randomContent(1 << 20)
+	defer os.Remove(filename)
+
+	for _, always := range []bool{false, true} {
+		var mu sync.Mutex
+		ranges := map[string]int{}
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			mu.Lock()
+			rng := r.Header.Get("Range")
+			ranges[rng]++
+			attempt := ranges[rng]
+			mu.Unlock()
+
+			var start, end int
+			fmt.Sscanf(rng, "bytes=%d-%d", &start, &end)
+			if end == 786431 && (always || attempt == 1 && start == 524288) {
+				// the response ends cleanly, without a Content-Length to show it's incomplete
+				w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, len(content)))
+				w.WriteHeader(http.StatusPartialContent)
+				w.(http.Flusher).Flush()
+				w.Write(content[start : start+1000])
+				return
+			}
+			http.ServeContent(w, r, filename, time.Time{}, bytes.NewReader(content))
+		}))
+
+		br, err := NewRequest()
+		if err != nil {
+			t.Fatal(err)
+		}
+		br.SetJobs(4)
+		br.SetMaxRetries(1)
+		br.SetRetryBackoff(10 * time.Millisecond)
+		file, err := br.FetchFile(context.Background(), ts.URL, filename)
+		ts.Close()
+		if always {
+			if !errors.Is(err, ErrShortResponse) {
+				t.Fatalf("expected ErrShortResponse, got %v", err)
+			}
+			if _, err = os.Stat(partFilename(filename)); !os.IsNotExist(err) {
+				t.Fatalf("expected the incomplete download to be removed")
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		file.Close()
+
+		got, err := os.ReadFile(filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, content) {
+			t.Fatalf("downloaded content doesn't match")
+		}
+		// only the remainder of the short range is requested again
+		if ranges["bytes=525288-786431"] != 1 {
+			t.Fatalf("expected the remainder of the short range to be requested, got %v", ranges)
+		}
+	}
+}
+
+func TestRetryCancel(t *testing.T) {
+	var filename string = "retry.bin"
+	defer os.Remove(filename)
+
+	content := randomContent(1 << 10)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "GET" {
+			http.Error(w, "try again", http.StatusServiceUnavailable)
+			return
+		}
+		http.ServeContent(w, r, filename, time.Time{}, bytes.NewReader(content))
+	}))
+	defer ts.Close()
+
+	br, err := NewRequest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	br.SetMaxRetries(5)
+	br.SetRetryBackoff(time.Minute)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(100*time.Millisecond, cancel)
+	start := time.Now()
+	file, err := br.FetchFile(ctx, ts.URL, filename)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+	file.Close()
+	if elapsed := time.Since(start); elapsed > 10*time.Second {
+		t.Fatalf("expected retries to stop on cancel, took %s", elapsed)
+	}
+}
+
+func TestRetryNotTransient(t *testing.T) {
+	var filename string = "retry.bin"
+	defer os.Remove(filename)
+
+	var gets int
+	var mu sync.Mutex
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "GET" {
+			mu.Lock()
+			gets++
+			mu.Unlock()
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Accept-Ranges", "bytes")
+		w.Header().Set("Content-Length", "1000")
+	}))
+	defer ts.Close()
+
+	br, err := NewRequest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	br.SetJobs(1)
+	br.SetMaxRetries(3)
+	br.SetRetryBackoff(time.Millisecond)
+	file, err := br.FetchFile(context.Background(), ts.URL, filename)
+	var statusErr *StatusError
+	if !errors.As(err, &statusErr) || statusErr.StatusCode != http.StatusNotFound {
+		t.Fatalf("expected 404 StatusError, got %v", err)
+	}
+	file.Close()
+	if gets != 1 {
+		t.Fatalf("expected 404 not to be retried, got %d requests", gets)
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	var filename string = "retry.bin"
+	content := randomContent(1 << 20)
+	defer os.Remove(filename)
+
+	for _, status := range []int{http.StatusServiceUnavailable, http.StatusTooManyRequests} {
+		var mu sync.Mutex
+		attempts := map[string]int{}
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			mu.Lock()
+			rng := r.Header.Get("Range")
+			attempts[rng]++
+			attempt := attempts[rng]
+			mu.Unlock()
+
+			if rng == "bytes=524288-1048575" && attempt == 1 {
+				w.Header().Set("Retry-After", "1")
+				http.Error(w, "slow down", status)
+				return
+			}
+			http.ServeContent(w, r, filename, time.Time{}, bytes.NewReader(content))
+		}))
+
+		br, err := NewRequest()
+		if err != nil {
+			t.Fatal(err)
+		}
+		br.SetJobs(2)
+		br.SetMaxRetries(1)
+		br.SetRetryBackoff(time.Millisecond)
+		start := time.Now()
+		file, err := br.FetchFile(context.Background(), ts.URL, filename)
+		ts.Close()
+		if err != nil {
+			t.Fatalf("status %d: %s", status, err)
+		}
+		file.Close()
+
+		if elapsed := time.Since(start); elapsed < time.Second {
+			t.Fatalf("status %d: expected retry to wait for Retry-After, took %s", status, elapsed)
+		}
+		got, err := os.ReadFile(filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, content) {
+			t.Fatalf("status %d: downloaded content doesn't match", status)
+		}
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		header   string
+		expected time.Duration
+	}{
+		{"", 0},
+		{"0", 0},
+		{"120", 2 * time.Minute},
+		{"-5", 0},
+		{"soon", 0},
+		{now.Add(90 * time.Second).Format(http.TimeFormat), 90 * time.Second},
+		{now.Add(-time.Minute).Format(http.TimeFormat), 0},
+	}
+	for _, tt := range tests {
+		if got := parseRetryAfter(tt.header, now); got != tt.expected {
+			t.Errorf("%q: expected %s, got %s", tt.header, tt.expected, got)
+		}
+	}
+}
@@ -0,0 +1,77 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package braid
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// SetMinSpeed sets the slowest a job may read its response. If a job reads fewer than bytesPerSec
+// bytes per second over window, its connection is dropped and the rest of its range is requested
+// again, so that one stalled connection doesn't hold up the whole download. Reissuing a slow request
+// doesn't count as a retry for SetMaxRetries, but does count towards the budget set by SetMaxTotalRetries.
+// Zero (the default) disables the check.
+func (r *Request) SetMinSpeed(bytesPerSec int64, window time.Duration) {
+	r.minSpeed = bytesPerSec
+	r.minSpeedWindow = window
+}
+
+// speedWatch aborts a job's response if the job reads too slowly.
+type speedWatch struct {
+	quit     chan struct{}
+	done     chan struct{}
+	stopOnce sync.Once
+	slow     atomic.Bool
+}
+
+// watchSpeed checks the progress of the job with stat every minSpeedWindow, calling abort if it has
+// read fewer bytes than minSpeed allows. The watch runs until it is stopped.
+func (r *Request) watchSpeed(stat *jobStat, abort func()) *speedWatch {
+	w := &speedWatch{quit: make(chan struct{}), done: make(chan struct{})}
+	minBytes := int64(float64(r.minSpeed) * r.minSpeedWindow.Seconds())
+
+	go func() {
+		defer close(w.done)
+		ticker := time.NewTicker(r.minSpeedWindow)
+		defer ticker.Stop()
+		last := stat.read.Load()
+		for {
+			select {
+			case <-ticker.C:
+				read := stat.read.Load()
+				if read-last < minBytes {
+					w.slow.Store(true)
+					abort()
+					return
+				}
+				last = read
+			case <-w.quit:
+				return
+			}
+		}
+	}()
+	return w
+}
+
+// stop stops the watch, returning whether the response was aborted for being too slow.
+func (w *speedWatch) stop() bool {
+	w.stopOnce.Do(func() {
+		close(w.quit)
+		<-w.done
+	})
+	return w.slow.Load()
+}
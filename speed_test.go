@@ -0,0 +1,99 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package braid
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestMinSpeed(t *testing.T) {
+	var filename string = "slow.bin"
+	content := randomContent(1 << 20)
+	defer os.Remove(filename)
+
+	var mu sync.Mutex
+	var slowRanges []string
+	throttled := false
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rng := r.Header.Get("Range")
+		mu.Lock()
+		if strings.HasSuffix(rng, "-786431") {
+			slowRanges = append(slowRanges, rng)
+		}
+		throttle := rng == "bytes=524288-786431" && !throttled
+		throttled = throttled || throttle
+		mu.Unlock()
+
+		if !throttle {
+			http.ServeContent(w, r, filename, time.Time{}, bytes.NewReader(content))
+			return
+		}
+		// trickle the chunk out until the client gives up on it
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes 524288-786431/%d", len(content)))
+		w.Header().Set("Content-Length", "262144")
+		w.WriteHeader(http.StatusPartialContent)
+		for off := 524288; off < 786432; off += 1024 {
+			if _, err := w.Write(content[off : off+1024]); err != nil {
+				return
+			}
+			w.(http.Flusher).Flush()
+			select {
+			case <-time.After(50 * time.Millisecond):
+			case <-r.Context().Done():
+				return
+			}
+		}
+	}))
+	defer ts.Close()
+
+	br, err := NewRequest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	br.SetJobs(4)
+	br.SetMinSpeed(64<<10, 200*time.Millisecond)
+	file, err := br.FetchFile(context.Background(), ts.URL, filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+
+	got, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, content) {
+		t.Fatalf("downloaded content doesn't match")
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	// the slow chunk is reissued from where it got to
+	if len(slowRanges) != 2 || slowRanges[1] == slowRanges[0] {
+		t.Fatalf("expected the slow chunk to be reissued once, got ranges %v", slowRanges)
+	}
+	if stats := br.JobStats(); stats[2].ReadBytes != stats[2].TotalBytes {
+		t.Fatalf("expected the slow chunk to complete, got %+v", stats[2])
+	}
+}
@@ -0,0 +1,179 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package braid
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync"
+)
+
+// DefaultStreamBufferSize is the most bytes FetchStream buffers ahead of the reader by default.
+const DefaultStreamBufferSize = 16 << 20
+
+// errStreamClosed is returned by writes to a stream that has been closed or whose download has been cancelled.
+var errStreamClosed = errors.New("stream closed")
+
+// SetStreamBufferSize sets the most bytes FetchStream buffers ahead of the reader. Jobs that get this
+// far ahead of the reader pause until it catches up. DefaultStreamBufferSize is used by default, or if
+// size isn't positive.
+func (r *Request) SetStreamBufferSize(size int64) {
+	r.streamBufferSize = size
+}
+
+// FetchStream fetches the resource at url in parallel, returning its bytes in order as they arrive. The
+// resource is divided into chunks that jobs take in order, so that they work just ahead of the reader.
+// FetchStream returns once the first bytes have arrived, or with an error if the download fails before
+// then. Later errors are returned by Read. Closing the stream cancels the download.
+func (r *Request) FetchStream(ctx context.Context, url string) (io.ReadCloser, error) {
+	size := r.streamBufferSize
+	if size <= 0 {
+		size = DefaultStreamBufferSize
+	}
+	// a job's write must fit in the buffer
+	if size < int64(r.bufferSize) {
+		size = int64(r.bufferSize)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	s := &streamBuffer{buf: make([]byte, size), cancel: cancel, done: make(chan struct{})}
+	s.cond = sync.NewCond(&s.mu)
+	go func() {
+		_, err := r.fetch(ctx, url, "", s, false)
+		s.finish(err)
+	}()
+
+	s.mu.Lock()
+	for len(s.spans) == 0 && !s.finished {
+		s.cond.Wait()
+	}
+	err := s.err
+	s.mu.Unlock()
+	if err != nil {
+		s.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// streamBuffer is the io.WriterAt that jobs write a streamed download to, and the io.ReadCloser it's read
+// from. It holds the bytes written ahead of the reader in a ring buffer, and blocks writes that would
+// overwrite bytes yet to be read.
+type streamBuffer struct {
+	mu   sync.Mutex
+	cond *sync.Cond
+	buf  []byte
+	// pos is the offset in the resource of the next byte to be read
+	pos int64
+	// spans are the ranges of the resource written but not yet read
+	spans [][2]int64
+
+	aborted  bool
+	closed   bool
+	finished bool
+	err      error
+
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// chunkSize returns the size of the chunks jobs fetch, so that together they fill the buffer.
+func (s *streamBuffer) chunkSize(jobs int) int64 {
+	size := int64(len(s.buf)) / int64(jobs)
+	if size < 1 {
+		size = 1
+	}
+	return size
+}
+
+func (s *streamBuffer) WriteAt(p []byte, off int64) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for off+int64(len(p)) > s.pos+int64(len(s.buf)) && !s.aborted {
+		s.cond.Wait()
+	}
+	if s.aborted {
+		return 0, errStreamClosed
+	}
+	i := int(off % int64(len(s.buf)))
+	n := copy(s.buf[i:], p)
+	copy(s.buf, p[n:])
+	s.spans = addSpan(s.spans, off, off+int64(len(p)))
+	s.cond.Broadcast()
+	return len(p), nil
+}
+
+func (s *streamBuffer) Read(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for {
+		if s.closed {
+			return 0, errStreamClosed
+		}
+		if len(s.spans) > 0 && s.spans[0][0] <= s.pos {
+			break
+		}
+		if s.finished {
+			if s.err != nil {
+				return 0, s.err
+			}
+			return 0, io.EOF
+		}
+		s.cond.Wait()
+	}
+
+	if avail := s.spans[0][1] - s.pos; int64(len(p)) > avail {
+		p = p[:avail]
+	}
+	i := int(s.pos % int64(len(s.buf)))
+	n := copy(p, s.buf[i:])
+	n += copy(p[n:], s.buf)
+	s.pos += int64(n)
+	if s.pos == s.spans[0][1] {
+		s.spans = s.spans[1:]
+	}
+	s.cond.Broadcast()
+	return n, nil
+}
+
+// Close cancels the download if it's still running, and waits for its jobs to stop.
+func (s *streamBuffer) Close() error {
+	s.mu.Lock()
+	s.closed = true
+	s.mu.Unlock()
+	s.cancel()
+	s.abort()
+	<-s.done
+	return nil
+}
+
+// abort makes writes fail rather than wait for the reader, so that jobs stop once the download is cancelled.
+func (s *streamBuffer) abort() {
+	s.mu.Lock()
+	s.aborted = true
+	s.cond.Broadcast()
+	s.mu.Unlock()
+}
+
+// finish records the outcome of the download once all its jobs have stopped.
+func (s *streamBuffer) finish(err error) {
+	s.mu.Lock()
+	s.finished = true
+	s.err = err
+	s.cond.Broadcast()
+	s.mu.Unlock()
+	close(s.done)
+}
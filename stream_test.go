@@ -0,0 +1,110 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package braid
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestFetchStream(t *testing.T) {
+	var bufferSize int64 = 256 << 10
+	content := randomContent(4<<20 + 3)
+	ts := newContentServer(content)
+	defer ts.Close()
+
+	br, err := NewRequest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	br.SetJobs(4)
+	br.SetStreamBufferSize(bufferSize)
+	stream, err := br.FetchStream(context.Background(), ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stream.Close()
+
+	first := make([]byte, 1)
+	if _, err = io.ReadFull(stream, first); err != nil {
+		t.Fatal(err)
+	}
+
+	// jobs can't get further ahead of the reader than the buffer allows
+	time.Sleep(100 * time.Millisecond)
+	if read := br.Stats().ReadBytes; read > bufferSize+1 {
+		t.Fatalf("expected at most %d bytes buffered, got %d", bufferSize+1, read)
+	}
+	if len(br.JobStats()) < int(int64(len(content))/bufferSize) {
+		t.Fatalf("expected the stream to be fetched in chunks of the buffer, got %d chunks", len(br.JobStats()))
+	}
+
+	rest, err := io.ReadAll(stream)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(append(first, rest...), content) {
+		t.Fatalf("streamed content doesn't match")
+	}
+}
+
+func TestFetchStreamClose(t *testing.T) {
+	content := randomContent(4 << 20)
+	ts := newContentServer(content)
+	defer ts.Close()
+
+	br, err := NewRequest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	br.SetStreamBufferSize(64 << 10)
+	stream, err := br.FetchStream(context.Background(), ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 1000)
+	if _, err = io.ReadFull(stream, buf); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf, content[:len(buf)]) {
+		t.Fatalf("streamed content doesn't match")
+	}
+
+	// closing stops the jobs waiting for the reader
+	if err = stream.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = stream.Read(buf); err == nil {
+		t.Fatalf("expected error reading a closed stream")
+	}
+}
+
+func TestFetchStreamError(t *testing.T) {
+	ts := httptest.NewServer(http.NotFoundHandler())
+	defer ts.Close()
+
+	br, err := NewRequest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = br.FetchStream(context.Background(), ts.URL); err == nil {
+		t.Fatalf("expected error streaming a missing resource")
+	}
+}
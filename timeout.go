@@ -0,0 +1,43 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package braid
+
+import (
+	"context"
+	"time"
+)
+
+// SetTimeout sets the longest a download may take as a whole, after which it's cancelled and fails
+// with context.DeadlineExceeded. It applies along with any deadline of the context the download is
+// given. Zero (the default) means no timeout.
+func (r *Request) SetTimeout(d time.Duration) {
+	r.timeout = d
+}
+
+// SetPerJobTimeout sets the longest each of a job's requests may take, from sending the request to
+// reading the last byte of the response, so that a hung connection doesn't hold up the download. A
+// request that times out fails with ErrJobTimeout and is retried like any other transient failure,
+// see SetMaxRetries. Zero (the default) means no timeout.
+func (r *Request) SetPerJobTimeout(d time.Duration) {
+	r.jobTimeout = d
+}
+
+// withTimeout returns ctx with the deadline set by SetTimeout, if any.
+func (r *Request) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if r.timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, r.timeout)
+}
@@ -0,0 +1,132 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package braid
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+// hangingWriter stops responding once limit bytes of the body have been written, until the request is cancelled
+type hangingWriter struct {
+	http.ResponseWriter
+	r     *http.Request
+	limit int
+}
+
+func (w *hangingWriter) Write(p []byte) (int, error) {
+	if len(p) > w.limit {
+		n, _ := w.ResponseWriter.Write(p[:w.limit])
+		w.ResponseWriter.(http.Flusher).Flush()
+		select {
+		case <-w.r.Context().Done():
+		case <-time.After(10 * time.Second):
+		}
+		return n, w.r.Context().Err()
+	}
+	w.limit -= len(p)
+	return w.ResponseWriter.Write(p)
+}
+
+func TestPerJobTimeout(t *testing.T) {
+	var filename string = "jobtimeout.bin"
+	content := randomContent(1 << 20)
+	defer os.Remove(filename)
+
+	for _, retries := range []int{0, 1} {
+		var mu sync.Mutex
+		ranges := map[string]int{}
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			mu.Lock()
+			rng := r.Header.Get("Range")
+			ranges[rng]++
+			attempt := ranges[rng]
+			mu.Unlock()
+
+			if rng == "bytes=262144-524287" && attempt == 1 {
+				w = &hangingWriter{ResponseWriter: w, r: r, limit: 1000}
+			}
+			http.ServeContent(w, r, filename, time.Time{}, bytes.NewReader(content))
+		}))
+
+		br, err := NewRequest()
+		if err != nil {
+			t.Fatal(err)
+		}
+		br.SetJobs(4)
+		br.SetMaxRetries(retries)
+		br.SetRetryBackoff(10 * time.Millisecond)
+		br.SetPerJobTimeout(200 * time.Millisecond)
+		start := time.Now()
+		file, err := br.FetchFile(context.Background(), ts.URL, filename)
+		ts.Close()
+		if elapsed := time.Since(start); elapsed > 5*time.Second {
+			t.Fatalf("expected the hung job to time out, took %s", elapsed)
+		}
+		if retries == 0 {
+			if !errors.Is(err, ErrJobTimeout) {
+				t.Fatalf("expected ErrJobTimeout, got %v", err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		file.Close()
+
+		got, err := os.ReadFile(filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, content) {
+			t.Fatalf("downloaded content doesn't match")
+		}
+	}
+}
+
+func TestTimeout(t *testing.T) {
+	var filename string = "timeout.bin"
+	content := randomContent(1 << 20)
+	defer os.Remove(filename)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "GET" {
+			w = &hangingWriter{ResponseWriter: w, r: r, limit: 1000}
+		}
+		http.ServeContent(w, r, filename, time.Time{}, bytes.NewReader(content))
+	}))
+	defer ts.Close()
+
+	br, err := NewRequest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	br.SetTimeout(200 * time.Millisecond)
+	start := time.Now()
+	_, err = br.FetchFile(context.Background(), ts.URL, filename)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected context.DeadlineExceeded, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Fatalf("expected the download to time out, took %s", elapsed)
+	}
+}
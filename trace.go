@@ -0,0 +1,75 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package braid
+
+import (
+	"context"
+	"net/http"
+)
+
+// Tracer creates spans for distributed tracing of downloads, so that braid can be traced with a library
+// such as OpenTelemetry through a small adapter, without depending on it. A download has a span, with a
+// child span for each chunk fetched. Its methods are called from the jobs' goroutines, so must be safe
+// for concurrent use.
+type Tracer interface {
+	// Start starts a span called name, a child of any span in ctx, returning ctx with the span.
+	Start(ctx context.Context, name string) (context.Context, Span)
+	// Inject adds the headers propagating the span in ctx to header, which are sent with a request.
+	Inject(ctx context.Context, header http.Header)
+}
+
+// Span is a span started by a Tracer.
+type Span interface {
+	// SetAttribute records an attribute of the span, such as the number of bytes fetched. The value
+	// is a string, int or int64.
+	SetAttribute(key string, value any)
+	// RecordError records the error that failed the span.
+	RecordError(err error)
+	End()
+}
+
+// SetTracer sets the tracer that creates spans for downloads, whose trace headers are added to every
+// request before it's passed to any request modifier, see SetRequestModifier. Nil, the default,
+// disables tracing.
+func (r *Request) SetTracer(t Tracer) {
+	r.tracer = t
+}
+
+// spanKey is the context key of braid's current span.
+type spanKey struct{}
+
+// startSpan starts a span called name if a tracer is set, returning ctx with the span. The span is nil
+// if there is no tracer.
+func (r *Request) startSpan(ctx context.Context, name string) (context.Context, Span) {
+	if r.tracer == nil {
+		return ctx, nil
+	}
+	ctx, span := r.tracer.Start(ctx, name)
+	return context.WithValue(ctx, spanKey{}, span), span
+}
+
+// spanFromContext returns the span started by startSpan in ctx, or nil if there is none.
+func spanFromContext(ctx context.Context) Span {
+	span, _ := ctx.Value(spanKey{}).(Span)
+	return span
+}
+
+// endSpan records err, if any, and ends span.
+func endSpan(span Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+	}
+	span.End()
+}
@@ -0,0 +1,148 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package braid
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+// memoryTracer is a Tracer that keeps its spans in memory
+type memoryTracer struct {
+	sync.Mutex
+	spans []*memorySpan
+}
+
+type memorySpan struct {
+	sync.Mutex
+	id     int
+	name   string
+	parent *memorySpan
+	attrs  map[string]any
+	err    error
+	ended  bool
+}
+
+type memorySpanKey struct{}
+
+func (t *memoryTracer) Start(ctx context.Context, name string) (context.Context, Span) {
+	t.Lock()
+	defer t.Unlock()
+	parent, _ := ctx.Value(memorySpanKey{}).(*memorySpan)
+	span := &memorySpan{id: len(t.spans) + 1, name: name, parent: parent, attrs: map[string]any{}}
+	t.spans = append(t.spans, span)
+	return context.WithValue(ctx, memorySpanKey{}, span), span
+}
+
+func (t *memoryTracer) Inject(ctx context.Context, header http.Header) {
+	if span, ok := ctx.Value(memorySpanKey{}).(*memorySpan); ok {
+		header.Set("Traceparent", fmt.Sprint(span.id))
+	}
+}
+
+func (s *memorySpan) SetAttribute(key string, value any) {
+	s.Lock()
+	defer s.Unlock()
+	s.attrs[key] = value
+}
+
+func (s *memorySpan) RecordError(err error) {
+	s.Lock()
+	defer s.Unlock()
+	s.err = err
+}
+
+func (s *memorySpan) End() {
+	s.Lock()
+	defer s.Unlock()
+	s.ended = true
+}
+
+func TestTracer(t *testing.T) {
+	var filename string = "trace.bin"
+	var jobs int = 4
+	content := randomContent(1 << 20)
+	defer os.Remove(filename)
+
+	var mu sync.Mutex
+	traceparents := map[string]string{}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		traceparents[r.Method+" "+r.Header.Get("Range")] = r.Header.Get("Traceparent")
+		mu.Unlock()
+		http.ServeContent(w, r, filename, time.Time{}, bytes.NewReader(content))
+	}))
+	defer ts.Close()
+
+	tracer := &memoryTracer{}
+	br, err := NewRequest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	br.SetJobs(jobs)
+	br.SetTracer(tracer)
+	file, err := br.FetchFile(context.Background(), ts.URL, filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+
+	// a span for the download and one for each chunk
+	tracer.Lock()
+	defer tracer.Unlock()
+	if len(tracer.spans) != jobs+1 {
+		t.Fatalf("expected %d spans, got %d", jobs+1, len(tracer.spans))
+	}
+	download := tracer.spans[0]
+	if download.name != "braid.fetch" || download.parent != nil || !download.ended || download.err != nil {
+		t.Fatalf("unexpected download span %+v", download)
+	}
+	if download.attrs["braid.bytes"] != int64(len(content)) || download.attrs["braid.url"] != ts.URL {
+		t.Fatalf("unexpected download span attributes %v", download.attrs)
+	}
+
+	var total int64
+	for _, span := range tracer.spans[1:] {
+		if span.name != "braid.chunk" || span.parent != download || !span.ended || span.err != nil {
+			t.Fatalf("unexpected chunk span %+v", span)
+		}
+		if span.attrs["http.status_code"] != http.StatusPartialContent {
+			t.Fatalf("expected chunk span to record status 206, got %v", span.attrs)
+		}
+		total += span.attrs["braid.bytes"].(int64)
+
+		// each chunk's request carries its span
+		rng := fmt.Sprintf("GET bytes=%d-%d", span.attrs["braid.start"], span.attrs["braid.end"].(int64)-1)
+		mu.Lock()
+		traceparent := traceparents[rng]
+		mu.Unlock()
+		if traceparent != fmt.Sprint(span.id) {
+			t.Fatalf("expected %s to carry span %d, got %q", rng, span.id, traceparent)
+		}
+	}
+	if total != int64(len(content)) {
+		t.Fatalf("expected chunk spans to cover %d bytes, got %d", len(content), total)
+	}
+	if traceparents["HEAD "] != fmt.Sprint(download.id) {
+		t.Fatalf("expected the HEAD request to carry the download span, got %q", traceparents["HEAD "])
+	}
+}
@@ -0,0 +1,678 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package braid
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestResolverCache(t *testing.T) {
+	var filename string = "resolver.bin"
+	content := randomContent(1 << 20)
+	ts := newContentServer(content)
+	defer ts.Close()
+	defer os.Remove(filename)
+
+	var lookups int32
+	br, err := NewRequest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	br.SetJobs(5)
+	br.SetResolverCache(time.Minute)
+	br.lookupHost = func(ctx context.Context, host string) ([]string, error) {
+		atomic.AddInt32(&lookups, 1)
+		// give concurrent lookups a chance to pile up
+		time.Sleep(10 * time.Millisecond)
+		return []string{"127.0.0.1"}, nil
+	}
+
+	url := strings.Replace(ts.URL, "127.0.0.1", "braid.test", 1)
+	file, err := br.FetchFile(context.Background(), url, filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+
+	if lookups != 1 {
+		t.Fatalf("expected a single lookup, got %d", lookups)
+	}
+	got, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, content) {
+		t.Fatalf("downloaded content doesn't match")
+	}
+}
+
+func TestConnStats(t *testing.T) {
+	var filename string = "conns.bin"
+	content := randomContent(1 << 20)
+	ts := newContentServer(content)
+	defer ts.Close()
+	defer os.Remove(filename)
+
+	br, err := NewRequest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	br.SetJobs(5)
+	br.SetResolverCache(time.Minute)
+	result, err := br.Download(context.Background(), ts.URL, filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result.File.Close()
+
+	// one HEAD and a GET for each job
+	conns := result.Conns
+	if conns.Reused+conns.New != 6 {
+		t.Fatalf("expected 6 connections to be used, got %+v", conns)
+	}
+	if conns.New == 0 {
+		t.Fatalf("expected a new connection to be dialed, got %+v", conns)
+	}
+	if conns.Reused == 0 {
+		t.Fatalf("expected the HEAD connection to be reused, got %+v", conns)
+	}
+}
+
+// countingTransport counts the requests it sends
+type countingTransport struct {
+	requests int32
+}
+
+func (c *countingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	atomic.AddInt32(&c.requests, 1)
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+func TestSetClient(t *testing.T) {
+	var filename string = "client.bin"
+	content := randomContent(1 << 20)
+	ts := newContentServer(content)
+	defer ts.Close()
+	defer os.Remove(filename)
+
+	transport := &countingTransport{}
+	br, err := NewRequest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	br.SetJobs(4)
+	br.SetClient(&http.Client{Transport: transport})
+	file, err := br.FetchFile(context.Background(), ts.URL, filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+
+	// one HEAD and a GET for each job
+	if transport.requests != 5 {
+		t.Fatalf("expected 5 requests through the client, got %d", transport.requests)
+	}
+}
+
+// socks5Server is a minimal SOCKS5 proxy, without authentication, that connects every
+// request to upstream and records the addresses asked for.
+type socks5Server struct {
+	sync.Mutex
+	listener net.Listener
+	upstream string
+	addrs    []string
+}
+
+func newSocks5Server(t *testing.T, upstream string) *socks5Server {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &socks5Server{listener: l, upstream: upstream}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+func (s *socks5Server) serve(conn net.Conn) {
+	defer conn.Close()
+
+	// greeting: version, number of methods and the methods, answered with no authentication
+	buf := make([]byte, 262)
+	if _, err := io.ReadFull(conn, buf[:2]); err != nil {
+		return
+	}
+	if _, err := io.ReadFull(conn, buf[:buf[1]]); err != nil {
+		return
+	}
+	conn.Write([]byte{5, 0})
+
+	// request: version, command, reserved, address type, address and port
+	if _, err := io.ReadFull(conn, buf[:4]); err != nil {
+		return
+	}
+	var host string
+	switch buf[3] {
+	case 1:
+		io.ReadFull(conn, buf[:4])
+		host = net.IP(buf[:4]).String()
+	case 3:
+		io.ReadFull(conn, buf[:1])
+		n := int(buf[0])
+		io.ReadFull(conn, buf[:n])
+		host = string(buf[:n])
+	default:
+		return
+	}
+	if _, err := io.ReadFull(conn, buf[:2]); err != nil {
+		return
+	}
+	port := binary.BigEndian.Uint16(buf[:2])
+	s.Lock()
+	s.addrs = append(s.addrs, net.JoinHostPort(host, strconv.Itoa(int(port))))
+	s.Unlock()
+
+	upstream, err := net.Dial("tcp", s.upstream)
+	if err != nil {
+		return
+	}
+	defer upstream.Close()
+	conn.Write([]byte{5, 0, 0, 1, 0, 0, 0, 0, 0, 0})
+
+	go io.Copy(upstream, conn)
+	io.Copy(conn, upstream)
+}
+
+func TestRedirects(t *testing.T) {
+	var filename string = "redirect.bin"
+	content := randomContent(1 << 20)
+	backend := newContentServer(content)
+	defer backend.Close()
+	defer os.Remove(filename)
+
+	var redirects atomic.Int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		redirects.Add(1)
+		http.Redirect(w, r, backend.URL+"/file", http.StatusFound)
+	}))
+	defer ts.Close()
+
+	br, err := NewRequest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	br.SetJobs(4)
+	var checked []string
+	br.SetCheckRedirect(func(req *http.Request, via []*http.Request) error {
+		checked = append(checked, via[0].Method+" "+req.URL.String())
+		return nil
+	})
+	result, err := br.Download(context.Background(), ts.URL, filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result.File.Close()
+
+	got, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, content) {
+		t.Fatalf("downloaded content doesn't match")
+	}
+	if result.URL != backend.URL+"/file" {
+		t.Fatalf("expected resolved URL %s, got %s", backend.URL+"/file", result.URL)
+	}
+	// only the HEAD request is redirected, the jobs going straight to the resolved URL
+	if n := redirects.Load(); n != 1 {
+		t.Fatalf("expected 1 request to be redirected, got %d", n)
+	}
+	if len(checked) != 1 || checked[0] != "HEAD "+backend.URL+"/file" {
+		t.Fatalf("expected the HEAD redirect to be checked, got %v", checked)
+	}
+
+	br.SetCheckRedirect(nil)
+	br.SetMaxRedirects(0)
+	file, err := br.FetchFile(context.Background(), ts.URL, filename)
+	if !errors.Is(err, ErrTooManyRedirects) {
+		t.Fatalf("expected ErrTooManyRedirects, got %v", err)
+	}
+	file.Close()
+
+	errRefused := errors.New("refused")
+	br.SetMaxRedirects(DefaultMaxRedirects)
+	br.SetCheckRedirect(func(req *http.Request, via []*http.Request) error {
+		return errRefused
+	})
+	file, err = br.FetchFile(context.Background(), ts.URL, filename)
+	if !errors.Is(err, errRefused) {
+		t.Fatalf("expected the check's error, got %v", err)
+	}
+	file.Close()
+}
+
+func TestCookieJar(t *testing.T) {
+	var filename string = "cookie.bin"
+	content := randomContent(1 << 20)
+	defer os.Remove(filename)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "HEAD" {
+			http.SetCookie(w, &http.Cookie{Name: "session", Value: "secret"})
+		} else if c, err := r.Cookie("session"); err != nil || c.Value != "secret" {
+			http.Error(w, "no session", http.StatusForbidden)
+			return
+		}
+		http.ServeContent(w, r, filename, time.Time{}, bytes.NewReader(content))
+	}))
+	defer ts.Close()
+
+	br, err := NewRequest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	br.SetJobs(4)
+	file, err := br.FetchFile(context.Background(), ts.URL, filename)
+	if err == nil {
+		t.Fatalf("expected the download to fail without the session cookie")
+	}
+	file.Close()
+
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	br.SetCookieJar(jar)
+	file, err = br.FetchFile(context.Background(), ts.URL, filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+
+	got, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, content) {
+		t.Fatalf("downloaded content doesn't match")
+	}
+}
+
+func TestResponseHeaderTimeout(t *testing.T) {
+	var filename string = "headertimeout.bin"
+	content := randomContent(1 << 20)
+	defer os.Remove(filename)
+
+	delay := 500 * time.Millisecond
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(delay)
+		http.ServeContent(w, r, filename, time.Time{}, bytes.NewReader(content))
+	}))
+	defer ts.Close()
+
+	br, err := NewRequest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	br.SetJobs(4)
+	br.SetMaxRetries(0)
+	br.SetResponseHeaderTimeout(50 * time.Millisecond)
+	start := time.Now()
+	file, err := br.FetchFile(context.Background(), ts.URL, filename)
+	if err == nil || !strings.Contains(err.Error(), "timeout awaiting response headers") {
+		t.Fatalf("expected the response header timeout to expire, got %v", err)
+	}
+	file.Close()
+	if took := time.Since(start); took >= delay {
+		t.Fatalf("expected FetchFile to fail within the timeout, took %s", took)
+	}
+
+	// the timeout doesn't apply to a custom client
+	br.SetClient(&http.Client{})
+	file, err = br.FetchFile(context.Background(), ts.URL, filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+}
+
+// failingFirstServer serves content, failing the first request for each range
+func failingFirstServer(content []byte, tls bool) *httptest.Server {
+	var mu sync.Mutex
+	failed := map[string]bool{}
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rng := r.Header.Get("Range")
+		mu.Lock()
+		first := r.Method == "GET" && !failed[rng]
+		failed[rng] = true
+		mu.Unlock()
+		if first {
+			http.Error(w, "try again", http.StatusServiceUnavailable)
+			return
+		}
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(content))
+	})
+	if tls {
+		return httptest.NewTLSServer(handler)
+	}
+	return httptest.NewServer(handler)
+}
+
+func TestMaxIdleConnsPerHost(t *testing.T) {
+	var filename string = "idle.bin"
+	var jobs int = 8
+	content := randomContent(1 << 20)
+	defer os.Remove(filename)
+
+	for _, idle := range []int{0, 1} {
+		ts := failingFirstServer(content, false)
+		defer ts.Close()
+		br, err := NewRequest()
+		if err != nil {
+			t.Fatal(err)
+		}
+		br.SetJobs(jobs)
+		br.SetMaxRetries(1)
+		br.SetRetryBackoff(10 * time.Millisecond)
+		br.SetMaxIdleConnsPerHost(idle)
+		result, err := br.Download(context.Background(), ts.URL, filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		result.File.Close()
+
+		// the HEAD, then a failed request and its retry for each job
+		conns := result.Conns
+		if conns.Reused+conns.New != 1+2*jobs {
+			t.Fatalf("expected %d requests, got %+v", 1+2*jobs, conns)
+		}
+		if idle == 0 && conns.New > jobs {
+			t.Fatalf("expected the retries to reuse the jobs' connections, got %+v", conns)
+		}
+		if idle == 1 && conns.New <= jobs {
+			t.Fatalf("expected retries to dial new connections with one kept idle, got %+v", conns)
+		}
+	}
+}
+
+func BenchmarkRetryConns(b *testing.B) {
+	var filename string = "benchidle.bin"
+	content := randomContent(4 << 20)
+	defer os.Remove(filename)
+
+	for _, idle := range []int{1, 0} {
+		b.Run(fmt.Sprintf("idle=%d", idle), func(b *testing.B) {
+			b.SetBytes(int64(len(content)))
+			var dialed int
+			for i := 0; i < b.N; i++ {
+				// each iteration has a fresh server, so that its ranges fail once again
+				ts := failingFirstServer(content, true)
+				br, err := NewRequest()
+				if err != nil {
+					b.Fatal(err)
+				}
+				br.SetLogger(nil)
+				br.SetJobs(16)
+				br.SetMaxRetries(1)
+				br.SetRetryBackoff(time.Millisecond)
+				br.SetMaxIdleConnsPerHost(idle)
+				br.SetTLSConfig(ts.Client().Transport.(*http.Transport).TLSClientConfig)
+				result, err := br.Download(context.Background(), ts.URL, filename)
+				if err != nil {
+					b.Fatal(err)
+				}
+				result.File.Close()
+				dialed += result.Conns.New
+				ts.Close()
+			}
+			b.ReportMetric(float64(dialed)/float64(b.N), "handshakes/op")
+		})
+	}
+}
+
+func TestLocalAddrs(t *testing.T) {
+	var filename string = "local.bin"
+	content := randomContent(1 << 20)
+	ts := newContentServer(content)
+	defer ts.Close()
+	defer os.Remove(filename)
+
+	locals := []net.Addr{
+		&net.TCPAddr{IP: net.ParseIP("10.0.0.1")},
+		&net.TCPAddr{IP: net.ParseIP("10.0.0.2")},
+	}
+	for _, n := range []int{1, 2} {
+		var mu sync.Mutex
+		dialed := map[string]int{}
+		br, err := NewRequest()
+		if err != nil {
+			t.Fatal(err)
+		}
+		br.SetJobs(4)
+		if n == 1 {
+			br.SetLocalAddr(locals[0])
+		} else {
+			br.SetLocalAddrs(locals...)
+		}
+		br.dial = func(d *net.Dialer, ctx context.Context, network, addr string) (net.Conn, error) {
+			mu.Lock()
+			dialed[d.LocalAddr.String()]++
+			mu.Unlock()
+			// the addresses aren't this host's, so the connection is made without them
+			local := *d
+			local.LocalAddr = nil
+			return local.DialContext(ctx, network, addr)
+		}
+		file, err := br.FetchFile(context.Background(), ts.URL, filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		file.Close()
+
+		got, err := os.ReadFile(filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, content) {
+			t.Fatalf("downloaded content doesn't match")
+		}
+		if len(dialed) != n {
+			t.Fatalf("expected connections from %d local addresses, got %v", n, dialed)
+		}
+		for _, local := range locals[:n] {
+			if dialed[local.String()] == 0 {
+				t.Fatalf("expected connections from %s, got %v", local, dialed)
+			}
+		}
+	}
+}
+
+func TestProxy(t *testing.T) {
+	var filename string = "proxy.bin"
+	content := randomContent(1 << 20)
+	ts := newContentServer(content)
+	defer ts.Close()
+	defer os.Remove(filename)
+
+	// the target can only be reached through the proxy
+	target := "http://braid.test/data.bin"
+
+	var mu sync.Mutex
+	var forwarded []string
+	httpProxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		forwarded = append(forwarded, r.RequestURI)
+		mu.Unlock()
+		http.ServeContent(w, r, "data.bin", time.Time{}, bytes.NewReader(content))
+	}))
+	defer httpProxy.Close()
+
+	socksProxy := newSocks5Server(t, ts.Listener.Addr().String())
+	defer socksProxy.listener.Close()
+
+	for _, proxyURL := range []string{httpProxy.URL, "socks5://" + socksProxy.listener.Addr().String()} {
+		for _, ttl := range []time.Duration{0, time.Minute} {
+			os.Remove(filename)
+			br, err := NewRequest()
+			if err != nil {
+				t.Fatal(err)
+			}
+			br.SetJobs(3)
+			br.SetResolverCache(ttl)
+			br.SetProxy(proxyURL)
+			file, err := br.FetchFile(context.Background(), target, filename)
+			if err != nil {
+				t.Fatalf("%s: %s", proxyURL, err)
+			}
+			file.Close()
+
+			got, err := os.ReadFile(filename)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, content) {
+				t.Fatalf("%s: downloaded content doesn't match", proxyURL)
+			}
+		}
+	}
+
+	// a forward proxy is sent the absolute URL, and a SOCKS proxy is asked to connect to the target's host
+	mu.Lock()
+	defer mu.Unlock()
+	if len(forwarded) == 0 {
+		t.Fatalf("expected requests through the HTTP proxy")
+	}
+	for _, uri := range forwarded {
+		if uri != target {
+			t.Fatalf("expected HTTP proxy to be asked for %s, got %s", target, uri)
+		}
+	}
+	socksProxy.Lock()
+	defer socksProxy.Unlock()
+	if len(socksProxy.addrs) == 0 {
+		t.Fatalf("expected connections through the SOCKS5 proxy")
+	}
+	for _, addr := range socksProxy.addrs {
+		if addr != "braid.test:80" {
+			t.Fatalf("expected SOCKS5 proxy to be asked for braid.test:80, got %s", addr)
+		}
+	}
+}
+
+func TestProxyInvalid(t *testing.T) {
+	for _, proxyURL := range []string{"ftp://proxy.test", "http://[::1"} {
+		br, err := NewRequest()
+		if err != nil {
+			t.Fatal(err)
+		}
+		br.SetProxy(proxyURL)
+		if _, err = br.FetchFile(context.Background(), "http://braid.test/data.bin", "proxy.bin"); err == nil || !strings.Contains(err.Error(), "invalid proxy URL") {
+			t.Fatalf("%s: expected invalid proxy URL error, got %v", proxyURL, err)
+		}
+	}
+}
+
+func TestTLSConfig(t *testing.T) {
+	var filename string = "tls.bin"
+	content := randomContent(1 << 20)
+	defer os.Remove(filename)
+
+	// the client must present a certificate
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if len(r.TLS.PeerCertificates) == 0 {
+			http.Error(w, "", http.StatusForbidden)
+			return
+		}
+		http.ServeContent(w, r, "data.bin", time.Time{}, bytes.NewReader(content))
+	}))
+	ts.TLS = &tls.Config{ClientAuth: tls.RequireAnyClientCert}
+	// the handshake failing without the CA is expected
+	ts.Config.ErrorLog = log.New(io.Discard, "", 0)
+	ts.StartTLS()
+	defer ts.Close()
+
+	pool := x509.NewCertPool()
+	pool.AddCert(ts.Certificate())
+	config := &tls.Config{RootCAs: pool, Certificates: ts.TLS.Certificates}
+
+	br, err := NewRequest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var certErr *tls.CertificateVerificationError
+	if _, err = br.FetchFile(context.Background(), ts.URL, filename); !errors.As(err, &certErr) {
+		t.Fatalf("expected the server certificate to be rejected without the CA, got %v", err)
+	}
+
+	client := &http.Client{Timeout: time.Minute}
+	for _, c := range []*http.Client{nil, client} {
+		br, err := NewRequest()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if c != nil {
+			br.SetClient(c)
+		}
+		br.SetTLSConfig(config)
+		file, err := br.FetchFile(context.Background(), ts.URL, filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		file.Close()
+
+		got, err := os.ReadFile(filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, content) {
+			t.Fatalf("downloaded content doesn't match")
+		}
+	}
+	if client.Transport != nil {
+		t.Fatalf("expected the custom client not to be changed")
+	}
+
+	br.SetClient(&http.Client{Transport: &countingTransport{}})
+	br.SetTLSConfig(config)
+	if _, err = br.FetchFile(context.Background(), ts.URL, filename); err == nil {
+		t.Fatalf("expected an error applying TLS config to a transport that isn't an *http.Transport")
+	}
+}
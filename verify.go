@@ -0,0 +1,245 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package braid
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"sync"
+)
+
+// ErrSegmentMismatch is returned when a segment of the download doesn't match the hash set with SetSegmentHashes.
+var ErrSegmentMismatch = errors.New("segment hash mismatch")
+
+// ErrChecksumMismatch is returned when the digest of the download doesn't match the one set with SetExpectedChecksum.
+var ErrChecksumMismatch = errors.New("checksum mismatch")
+
+// segmentVerifier tracks how much of each fixed-size segment has been written
+// and verifies a segment by reading it back as soon as it is complete.
+type segmentVerifier struct {
+	mu      sync.Mutex
+	ra      io.ReaderAt
+	length  int64
+	size    int64
+	hashes  [][]byte
+	written []int64
+}
+
+func newSegmentVerifier(ra io.ReaderAt, length, size int64, hashes [][]byte) (*segmentVerifier, error) {
+	if size <= 0 {
+		return nil, fmt.Errorf("segment size must be positive, got %d", size)
+	}
+	segments := (length + size - 1) / size
+	if int64(len(hashes)) != segments {
+		return nil, fmt.Errorf("expected %d segment hashes for %d bytes, got %d", segments, length, len(hashes))
+	}
+
+	v := &segmentVerifier{
+		ra:      ra,
+		length:  length,
+		size:    size,
+		hashes:  hashes,
+		written: make([]int64, segments),
+	}
+	return v, nil
+}
+
+// wrote records that n bytes were written at offset off, verifying any segments that are now complete.
+func (v *segmentVerifier) wrote(off, n int64) error {
+	var complete []int64
+
+	v.mu.Lock()
+	end := off + n
+	for off < end {
+		seg := off / v.size
+		segStart, segEnd := v.bounds(seg)
+		m := segEnd - off
+		if end < segEnd {
+			m = end - off
+		}
+		v.written[seg] += m
+		if v.written[seg] == segEnd-segStart {
+			complete = append(complete, seg)
+		}
+		off += m
+	}
+	v.mu.Unlock()
+
+	for _, seg := range complete {
+		if err := v.verify(seg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (v *segmentVerifier) verify(seg int64) error {
+	start, end := v.bounds(seg)
+	h := sha256.New()
+	_, err := io.Copy(h, io.NewSectionReader(v.ra, start, end-start))
+	if err != nil {
+		return fmt.Errorf("error reading back segment %d: %s", seg, err)
+	}
+	if !bytes.Equal(h.Sum(nil), v.hashes[seg]) {
+		return fmt.Errorf("%w: segment %d (bytes %d-%d)", ErrSegmentMismatch, seg, start, end-1)
+	}
+	return nil
+}
+
+// bounds returns the [start,end) byte offsets of segment seg.
+func (v *segmentVerifier) bounds(seg int64) (int64, int64) {
+	start := seg * v.size
+	end := start + v.size
+	if end > v.length {
+		end = v.length
+	}
+	return start, end
+}
+
+// digester computes a digest of the download by reading it back. When concurrent, it hashes
+// the contiguous data at the start of the download in the background as it is written.
+type digester struct {
+	ra     io.ReaderAt
+	h      hash.Hash
+	length int64
+	// offset is how much has been hashed so far
+	offset int64
+
+	// spans holds the sorted, merged [start,end) ranges written so far
+	mu    sync.Mutex
+	spans [][2]int64
+
+	concurrent bool
+	notify     chan struct{}
+	quit       chan struct{}
+	done       chan struct{}
+	stopOnce   sync.Once
+	err        error
+}
+
+func newDigester(ra io.ReaderAt, length int64, newHash func() hash.Hash, concurrent bool) *digester {
+	d := &digester{
+		ra:         ra,
+		h:          newHash(),
+		length:     length,
+		concurrent: concurrent,
+		notify:     make(chan struct{}, 1),
+		quit:       make(chan struct{}),
+		done:       make(chan struct{}),
+	}
+	if concurrent {
+		go d.run()
+	} else {
+		close(d.done)
+	}
+	return d
+}
+
+// wrote records that n bytes were written at offset off.
+func (d *digester) wrote(off, n int64) {
+	if !d.concurrent {
+		return
+	}
+
+	d.mu.Lock()
+	d.spans = addSpan(d.spans, off, off+n)
+	d.mu.Unlock()
+
+	select {
+	case d.notify <- struct{}{}:
+	default:
+	}
+}
+
+func (d *digester) run() {
+	defer close(d.done)
+	for {
+		select {
+		case <-d.notify:
+		case <-d.quit:
+			return
+		}
+
+		d.mu.Lock()
+		var end int64
+		if len(d.spans) > 0 && d.spans[0][0] == 0 {
+			end = d.spans[0][1]
+		}
+		d.mu.Unlock()
+
+		if err := d.advance(end); err != nil {
+			d.err = err
+			return
+		}
+	}
+}
+
+// advance hashes the data from the current offset up to end.
+func (d *digester) advance(end int64) error {
+	if end <= d.offset {
+		return nil
+	}
+	_, err := io.Copy(d.h, io.NewSectionReader(d.ra, d.offset, end-d.offset))
+	if err != nil {
+		return fmt.Errorf("error reading back download: %s", err)
+	}
+	d.offset = end
+	return nil
+}
+
+// stop stops the background hashing, if any.
+func (d *digester) stop() {
+	d.stopOnce.Do(func() {
+		close(d.quit)
+	})
+	<-d.done
+}
+
+// sum returns the digest once the whole download has been written.
+func (d *digester) sum() ([]byte, error) {
+	d.stop()
+	if d.err != nil {
+		return nil, d.err
+	}
+	if err := d.advance(d.length); err != nil {
+		return nil, err
+	}
+	return d.h.Sum(nil), nil
+}
+
+// addSpan adds [start,end) to the sorted spans, merging it with any it touches.
+func addSpan(spans [][2]int64, start, end int64) [][2]int64 {
+	i := 0
+	for i < len(spans) && spans[i][1] < start {
+		i++
+	}
+	j := i
+	for j < len(spans) && spans[j][0] <= end {
+		if spans[j][0] < start {
+			start = spans[j][0]
+		}
+		if spans[j][1] > end {
+			end = spans[j][1]
+		}
+		j++
+	}
+	merged := append([][2]int64{}, spans[:i]...)
+	merged = append(merged, [2]int64{start, end})
+	return append(merged, spans[j:]...)
+}
@@ -0,0 +1,219 @@
+/*
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package braid
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"errors"
+	"hash"
+	"os"
+	"strings"
+	"testing"
+)
+
+func segmentHashes(content []byte, size int) [][]byte {
+	var hashes [][]byte
+	for i := 0; i < len(content); i += size {
+		end := i + size
+		if end > len(content) {
+			end = len(content)
+		}
+		sum := sha256.Sum256(content[i:end])
+		hashes = append(hashes, sum[:])
+	}
+	return hashes
+}
+
+func TestSegmentHashes(t *testing.T) {
+	var filename string = "segments.bin"
+	var segmentSize int = 100 << 10
+
+	content := randomContent(1<<20 + 12345)
+	ts := newContentServer(content)
+	defer ts.Close()
+	defer os.Remove(filename)
+
+	br, err := NewRequest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	br.SetJobs(3)
+	br.SetSegmentHashes(int64(segmentSize), segmentHashes(content, segmentSize))
+
+	file, err := br.FetchFile(context.Background(), ts.URL, filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+
+	// corrupt the expected hash of one segment
+	hashes := segmentHashes(content, segmentSize)
+	hashes[4] = make([]byte, sha256.Size)
+	br.SetSegmentHashes(int64(segmentSize), hashes)
+
+	file, err = br.FetchFile(context.Background(), ts.URL, filename)
+	if !errors.Is(err, ErrSegmentMismatch) {
+		t.Fatalf("expected ErrSegmentMismatch, got %v", err)
+	}
+	file.Close()
+
+	// wrong number of hashes is rejected up front
+	br.SetSegmentHashes(int64(segmentSize), hashes[1:])
+	_, err = br.FetchFile(context.Background(), ts.URL, filename)
+	if err == nil {
+		t.Fatalf("expected error for short segment hash list")
+	}
+}
+
+func TestDigest(t *testing.T) {
+	var filename string = "digest.bin"
+
+	content := randomContent(2<<20 + 7)
+	expected := sha256.Sum256(content)
+	ts := newContentServer(content)
+	defer ts.Close()
+	defer os.Remove(filename)
+
+	for _, concurrent := range []bool{false, true} {
+		br, err := NewRequest()
+		if err != nil {
+			t.Fatal(err)
+		}
+		br.SetJobs(4)
+		br.SetDigest(sha256.New)
+		br.SetConcurrentVerify(concurrent)
+
+		file, err := br.FetchFile(context.Background(), ts.URL, filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		file.Close()
+
+		if !bytes.Equal(br.Digest(), expected[:]) {
+			t.Fatalf("concurrent %t: digest %x doesn't match expected %x", concurrent, br.Digest(), expected)
+		}
+	}
+}
+
+func TestDigestString(t *testing.T) {
+	var filename string = "digeststring.bin"
+
+	content := []byte("braid 10")
+	ts := newContentServer(content)
+	defer ts.Close()
+	defer os.Remove(filename)
+
+	br, err := NewRequest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = br.DigestString(DigestHex); err == nil {
+		t.Fatalf("expected error before a digest is computed")
+	}
+
+	br.SetDigest(md5.New)
+	file, err := br.FetchFile(context.Background(), ts.URL, filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+
+	tests := map[string]string{
+		DigestHex:       "0544bde57ad647f78ba24fb64ffff992",
+		DigestBase64:    "BUS95XrWR/eLok+2T//5kg==",
+		DigestBase64URL: "BUS95XrWR_eLok-2T__5kg==",
+	}
+	for encoding, expected := range tests {
+		got, err := br.DigestString(encoding)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != expected {
+			t.Errorf("encoding %s: expected %s, got %s", encoding, expected, got)
+		}
+	}
+
+	if _, err = br.DigestString("base32"); err == nil {
+		t.Fatalf("expected error for unknown encoding")
+	}
+}
+
+func TestAddSpan(t *testing.T) {
+	var spans [][2]int64
+	spans = addSpan(spans, 10, 20)
+	spans = addSpan(spans, 30, 40)
+	spans = addSpan(spans, 0, 5)
+	if len(spans) != 3 {
+		t.Fatalf("expected 3 spans, got %v", spans)
+	}
+	spans = addSpan(spans, 5, 10)
+	spans = addSpan(spans, 20, 30)
+	if len(spans) != 1 || spans[0] != [2]int64{0, 40} {
+		t.Fatalf("expected spans to merge to [0,40), got %v", spans)
+	}
+}
+
+func TestExpectedChecksum(t *testing.T) {
+	var filename string = "checksum.bin"
+
+	content := randomContent(1<<20 + 3)
+	ts := newContentServer(content)
+	defer ts.Close()
+	defer os.Remove(filename)
+
+	sha256Sum := sha256.Sum256(content)
+	sha512Sum := sha512.Sum512(content)
+	tests := []struct {
+		name     string
+		newHash  func() hash.Hash
+		expected string
+		match    bool
+	}{
+		{"sha256", sha256.New, hex.EncodeToString(sha256Sum[:]), true},
+		{"sha256 upper case", sha256.New, strings.ToUpper(hex.EncodeToString(sha256Sum[:])), true},
+		{"sha512", sha512.New, hex.EncodeToString(sha512Sum[:]), true},
+		{"mismatch", sha512.New, hex.EncodeToString(sha256Sum[:]), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			br, err := NewRequest()
+			if err != nil {
+				t.Fatal(err)
+			}
+			br.SetJobs(4)
+			br.SetExpectedChecksum(tt.newHash, tt.expected)
+			file, err := br.FetchFile(context.Background(), ts.URL, filename)
+			if !tt.match {
+				if !errors.Is(err, ErrChecksumMismatch) {
+					t.Fatalf("expected ErrChecksumMismatch, got %v", err)
+				}
+				if _, err = os.Stat(partFilename(filename)); !os.IsNotExist(err) {
+					t.Fatalf("expected corrupt download to be removed")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			file.Close()
+		})
+	}
+}